@@ -1,9 +1,87 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
 	"goetl/internal/api"
+	"goetl/internal/etl"
+	"goetl/internal/models"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "indexes" {
+		os.Exit(indexesCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		os.Exit(purgeCommand())
+	}
 	api.RunServer()
 }
+
+// indexesCommand runs "goetl indexes [list|reconcile [-drop-extra]]" and returns the exit code
+func indexesCommand(args []string) int {
+	action := "list"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("indexes "+action, flag.ContinueOnError)
+	dropExtra := fs.Bool("drop-extra", false, "drop indexes goetl does not require")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var statuses []models.IndexStatus
+	var err error
+	switch action {
+	case "list":
+		statuses, err = etl.ListIndexes()
+	case "reconcile":
+		statuses, err = etl.ReconcileIndexes(*dropExtra)
+	default:
+		fmt.Fprintf(os.Stderr, "usage: goetl indexes [list|reconcile [-drop-extra]]\n")
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "indexes %s: %v\n", action, err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tNAME\tKEYS\tUNIQUE\tTTL\tSTATUS\tERROR")
+	code := 0
+	for _, s := range statuses {
+		ttl := ""
+		if s.ExpireAfterSeconds > 0 {
+			ttl = fmt.Sprintf("%ds", s.ExpireAfterSeconds)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n", s.Collection, s.Name, s.Keys, s.Unique, ttl, s.Status, s.Error)
+		if s.Status == models.IndexFailed {
+			code = 1
+		}
+	}
+	w.Flush()
+	return code
+}
+
+// purgeCommand runs "goetl purge", deleting the data older than the retention periods, and returns the exit code
+func purgeCommand() int {
+	results, err := etl.Purge()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "purge: %v\n", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tCUTOFF\tARCHIVED\tDELETED\tARCHIVE\tERROR")
+	code := 0
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", r.Collection, r.Cutoff.Format(time.RFC3339), r.Archived, r.Deleted, r.Archive, r.Error)
+		if r.Error != "" {
+			code = 1
+		}
+	}
+	w.Flush()
+	return code
+}
@@ -0,0 +1,189 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"goetl/internal/metrics"
+	"goetl/internal/models"
+	"goetl/internal/utils"
+)
+
+// Config controls how baselines are built and when a value is flagged
+type Config struct {
+	// WindowDays is the number of days before a date used as its baseline
+	WindowDays int
+	// MinHistory is the minimum number of baseline days needed to judge a value
+	MinHistory int
+	// ZScore flags values whose absolute z-score exceeds it, 0 disables the check
+	ZScore float64
+	// PctBand flags values deviating from the baseline mean by more than this fraction, 0 disables the check
+	PctBand float64
+	// Metrics are the base fields or derived metrics checked
+	Metrics []string
+}
+
+// DefaultConfig checks cost, clicks, leads and ROAS against a 14 day baseline with a z-score of 3
+func DefaultConfig() Config {
+	return Config{
+		WindowDays: 14,
+		MinHistory: 7,
+		ZScore:     3,
+		Metrics:    []string{"cost", "clicks", "leads", "roas"},
+	}
+}
+
+// LoadFromEnv reads ANOMALY_WINDOW_DAYS, ANOMALY_MIN_HISTORY, ANOMALY_ZSCORE, ANOMALY_PCT_BAND
+// and ANOMALY_METRICS (comma separated) over DefaultConfig
+func LoadFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	var err error
+	if v := utils.Getenv("ANOMALY_WINDOW_DAYS"); v != "" {
+		if cfg.WindowDays, err = strconv.Atoi(v); err != nil || cfg.WindowDays <= 0 {
+			return cfg, fmt.Errorf("invalid ANOMALY_WINDOW_DAYS %q", v)
+		}
+	}
+	if v := utils.Getenv("ANOMALY_MIN_HISTORY"); v != "" {
+		if cfg.MinHistory, err = strconv.Atoi(v); err != nil || cfg.MinHistory < 2 {
+			return cfg, fmt.Errorf("invalid ANOMALY_MIN_HISTORY %q, must be at least 2", v)
+		}
+	}
+	if v := utils.Getenv("ANOMALY_ZSCORE"); v != "" {
+		if cfg.ZScore, err = strconv.ParseFloat(v, 64); err != nil || cfg.ZScore < 0 {
+			return cfg, fmt.Errorf("invalid ANOMALY_ZSCORE %q", v)
+		}
+	}
+	if v := utils.Getenv("ANOMALY_PCT_BAND"); v != "" {
+		if cfg.PctBand, err = strconv.ParseFloat(v, 64); err != nil || cfg.PctBand < 0 {
+			return cfg, fmt.Errorf("invalid ANOMALY_PCT_BAND %q", v)
+		}
+	}
+	if v := utils.Getenv("ANOMALY_METRICS"); v != "" {
+		cfg.Metrics = nil
+		for _, m := range strings.Split(v, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				cfg.Metrics = append(cfg.Metrics, m)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// value returns a base field or derived metric of res
+func value(res models.ETLResult, name string) (float64, bool) {
+	if v, ok := metrics.Fields(res)[name]; ok {
+		return v.Float64(), true
+	}
+	switch name {
+	case "cpc":
+		return res.CPC.Float64(), true
+	case "cpa":
+		return res.CPA.Float64(), true
+	case "cvr_lead_to_opp":
+		return res.CVRLeadToOpp.Float64(), true
+	case "cvr_opp_to_won":
+		return res.CVROppToWon.Float64(), true
+	case "roas":
+		return res.ROAS.Float64(), true
+	}
+	if v, ok := res.Metrics[name]; ok {
+		return v.Float64(), true
+	}
+	return 0, false
+}
+
+func seriesKey(res models.ETLResult) string {
+	return res.Channel + ":" + res.CampaignID
+}
+
+// Detect compares every target with its channel/campaign baseline taken from history, the
+// stored results of the preceding WindowDays days, and returns the values out of band
+func Detect(cfg Config, history, targets []models.ETLResult) []models.Anomaly {
+	series := make(map[string][]models.ETLResult)
+	for _, res := range history {
+		series[seriesKey(res)] = append(series[seriesKey(res)], res)
+	}
+	var anomalies []models.Anomaly
+	for _, target := range targets {
+		day, err := time.Parse("2006-01-02", target.Date)
+		if err != nil {
+			continue
+		}
+		windowStart := day.AddDate(0, 0, -cfg.WindowDays).Format("2006-01-02")
+		var baseline []models.ETLResult
+		for _, res := range series[seriesKey(target)] {
+			if res.Date >= windowStart && res.Date < target.Date {
+				baseline = append(baseline, res)
+			}
+		}
+		if len(baseline) < cfg.MinHistory {
+			continue
+		}
+		for _, metric := range cfg.Metrics {
+			x, ok := value(target, metric)
+			if !ok {
+				continue
+			}
+			values := make([]float64, 0, len(baseline))
+			for _, res := range baseline {
+				v, _ := value(res, metric)
+				values = append(values, v)
+			}
+			if a, flagged := check(cfg, x, values); flagged {
+				a.Date, a.Channel, a.CampaignID, a.Metric = target.Date, target.Channel, target.CampaignID, metric
+				anomalies = append(anomalies, a)
+			}
+		}
+	}
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		a, b := anomalies[i], anomalies[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		return a.CampaignID < b.CampaignID
+	})
+	return anomalies
+}
+
+// check flags x against the mean and standard deviation of values
+func check(cfg Config, x float64, values []float64) (models.Anomaly, bool) {
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(values)-1))
+
+	a := models.Anomaly{
+		Value:  x,
+		Mean:   utils.RoundFloat(mean, 4),
+		StdDev: utils.RoundFloat(stddev, 4),
+	}
+	flagged := false
+	if stddev > 0 {
+		a.ZScore = utils.RoundFloat((x-mean)/stddev, 4)
+		if cfg.ZScore > 0 && math.Abs(a.ZScore) > cfg.ZScore {
+			flagged = true
+		}
+	} else if cfg.ZScore > 0 && x != mean {
+		// a flat baseline makes any change infinitely unusual
+		flagged = true
+	}
+	if mean != 0 {
+		a.PctChange = utils.RoundFloat((x-mean)/math.Abs(mean), 4)
+		if cfg.PctBand > 0 && math.Abs(a.PctChange) > cfg.PctBand {
+			flagged = true
+		}
+	}
+	return a, flagged
+}
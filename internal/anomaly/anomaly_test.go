@@ -0,0 +1,72 @@
+package anomaly
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+)
+
+func history(days int, clicks func(i int) int) []models.ETLResult {
+	out := make([]models.ETLResult, 0, days)
+	for i := 1; i <= days; i++ {
+		out = append(out, models.ETLResult{
+			Date:       fmt.Sprintf("2025-08-%02d", i),
+			Channel:    "google_ads",
+			CampaignID: "C1",
+			Clicks:     clicks(i),
+			Cost:       money.NewFromInt(100),
+		})
+	}
+	return out
+}
+
+func TestDetect_ZScore(t *testing.T) {
+	cfg := Config{WindowDays: 14, MinHistory: 7, ZScore: 3, Metrics: []string{"clicks", "cost"}}
+	past := history(14, func(i int) int { return 100 + i%3 })
+	spike := models.ETLResult{Date: "2025-08-15", Channel: "google_ads", CampaignID: "C1", Clicks: 400, Cost: money.NewFromInt(100)}
+	normal := models.ETLResult{Date: "2025-08-15", Channel: "google_ads", CampaignID: "C2", Clicks: 400}
+
+	anomalies := Detect(cfg, append(past, spike), []models.ETLResult{spike, normal})
+	assert.Len(t, anomalies, 1)
+	a := anomalies[0]
+	assert.Equal(t, "clicks", a.Metric)
+	assert.Equal(t, "C1", a.CampaignID)
+	assert.Equal(t, 400.0, a.Value)
+	assert.Greater(t, a.ZScore, 3.0)
+}
+
+func TestDetect_PctBand(t *testing.T) {
+	cfg := Config{WindowDays: 14, MinHistory: 7, PctBand: 0.5, Metrics: []string{"clicks"}}
+	past := history(10, func(i int) int { return 100 })
+	anomalies := Detect(cfg, past, []models.ETLResult{{Date: "2025-08-11", Channel: "google_ads", CampaignID: "C1", Clicks: 140}})
+	assert.Empty(t, anomalies)
+	anomalies = Detect(cfg, past, []models.ETLResult{{Date: "2025-08-11", Channel: "google_ads", CampaignID: "C1", Clicks: 160}})
+	assert.Len(t, anomalies, 1)
+	assert.Equal(t, 0.6, anomalies[0].PctChange)
+}
+
+func TestDetect_NotEnoughHistory(t *testing.T) {
+	cfg := DefaultConfig()
+	past := history(3, func(i int) int { return 10 })
+	anomalies := Detect(cfg, past, []models.ETLResult{{Date: "2025-08-04", Channel: "google_ads", CampaignID: "C1", Clicks: 1000}})
+	assert.Empty(t, anomalies)
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	os.Setenv("ANOMALY_ZSCORE", "2.5")
+	os.Setenv("ANOMALY_METRICS", "cost, roas")
+	defer os.Unsetenv("ANOMALY_ZSCORE")
+	defer os.Unsetenv("ANOMALY_METRICS")
+	cfg, err := LoadFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, cfg.ZScore)
+	assert.Equal(t, []string{"cost", "roas"}, cfg.Metrics)
+
+	os.Setenv("ANOMALY_MIN_HISTORY", "1")
+	defer os.Unsetenv("ANOMALY_MIN_HISTORY")
+	_, err = LoadFromEnv()
+	assert.Error(t, err)
+}
@@ -1,76 +1,614 @@
 package api
 
 import (
+	"bytes"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"goetl/internal/etl"
+	"goetl/internal/export"
 	"goetl/internal/models"
 	"time"
+	"log"
 	"net/http"
+	"regexp"
+	"strings"
 	"fmt"
 	"goetl/internal/utils"
+	"goetl/internal/validation"
 )
 
 func RegisterRoutes(r *gin.Engine) {
 	r.POST("/ingest/run", ingestRunHandler)
 	r.GET("/metrics/channel", metricsByChannelHandler)
 	r.GET("/metrics/campaign", metricsByCampaignHandler)
+	r.GET("/metrics/campaign/:campaign_id/opportunities", campaignOpportunitiesHandler)
+	r.GET("/runs/:id/quality", runQualityHandler)
+	r.GET("/runs/:id/violations", runViolationsHandler)
+	r.GET("/runs/:id/restatements", runRestatementsHandler)
+	r.POST("/runs/:id/rollback", runRollbackHandler)
+	r.GET("/anomalies", anomaliesHandler)
+	r.POST("/privacy/erase", privacyEraseHandler)
+	r.GET("/privacy/erasures/:id", privacyErasureHandler)
+	r.POST("/budgets", createBudgetHandler)
+	r.GET("/budgets", listBudgetsHandler)
+	r.GET("/budgets/:id", getBudgetHandler)
+	r.PUT("/budgets/:id", updateBudgetHandler)
+	r.DELETE("/budgets/:id", deleteBudgetHandler)
+	r.GET("/pacing", pacingHandler)
+	r.GET("/indexes", indexesHandler)
+	r.GET("/reports/export.xlsx", reportExportHandler)
 }
 
-// metricsByCampaignHandler handles GET /metrics/campaign?from=YYYY-MM-DD&to=YYYY-MM-DD&utm_campaign=google_ads&limit=10&offset=0
-func metricsByCampaignHandler(c *gin.Context) {
-       from := c.Query("from")
-       to := c.Query("to")
-       utmCampaign := c.Query("utm_campaign")
-       limit := utils.ParseQueryInt(c, "limit", 10)
-       offset := utils.ParseQueryInt(c, "offset", 0)
+// indexesHandler handles GET /indexes, comparing the MongoDB indexes with the ones goetl requires
+func indexesHandler(c *gin.Context) {
+	statuses, err := etl.ListIndexes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":   len(statuses),
+		"results": statuses,
+	})
+}
 
-       // Fetch results filtered by utm_campaign and date range
-       results := etl.GetResultsByCampaign(from, to, utmCampaign, limit, offset)
+// createBudgetHandler handles POST /budgets
+func createBudgetHandler(c *gin.Context) {
+	var b models.Budget
+	if err := c.ShouldBindJSON(&b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	b.ID = ""
+	saveBudget(c, &b, http.StatusCreated)
+}
 
-       c.JSON(http.StatusOK, gin.H{
-	       "total": len(results),
-	       "limit": limit,
-	       "offset": offset,
-	       "results": results,
-       })
+// updateBudgetHandler handles PUT /budgets/:id
+func updateBudgetHandler(c *gin.Context) {
+	var b models.Budget
+	if err := c.ShouldBindJSON(&b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	b.ID = c.Param("id")
+	saveBudget(c, &b, http.StatusOK)
 }
 
-// metricsByChannelHandler handles GET /metrics/channel?from=YYYY-MM-DD&to=YYYY-MM-DD&channel=google_ads&pageSize=10&page=0
-func metricsByChannelHandler(c *gin.Context) {
+// saveBudget stores a budget, answering with status on success
+func saveBudget(c *gin.Context, b *models.Budget, status int) {
+	if err := etl.SaveBudget(b); err != nil {
+		var bErr *etl.InvalidBudgetError
+		if errors.As(err, &bErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, etl.ErrBudgetNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, b)
+}
+
+// getBudgetHandler handles GET /budgets/:id
+func getBudgetHandler(c *gin.Context) {
+	b := etl.GetBudget(c.Param("id"))
+	if b == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "budget not found"})
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// deleteBudgetHandler handles DELETE /budgets/:id
+func deleteBudgetHandler(c *gin.Context) {
+	if err := etl.DeleteBudget(c.Param("id")); err != nil {
+		if errors.Is(err, etl.ErrBudgetNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listBudgetsHandler handles GET /budgets?campaign_id=C1&date=YYYY-MM-DD&limit=10&offset=0
+func listBudgetsHandler(c *gin.Context) {
+	limit := utils.ParseQueryInt(c, "limit", 10)
+	offset := utils.ParseQueryInt(c, "offset", 0)
+	budgets, total := etl.GetBudgets(c.Query("campaign_id"), c.Query("date"), limit, offset)
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"results": budgets,
+	})
+}
+
+// pacingHandler handles GET /pacing?date=YYYY-MM-DD&campaign_id=C1, date defaults to today
+func pacingHandler(c *gin.Context) {
+	date := c.DefaultQuery("date", time.Now().UTC().Format("2006-01-02"))
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	pacing, err := etl.GetPacing(date, c.Query("campaign_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"date":    date,
+		"total":   len(pacing),
+		"results": pacing,
+	})
+}
+
+// reportExportHandler handles GET /reports/export.xlsx?from=YYYY-MM-DD&to=YYYY-MM-DD, a workbook of the results
+// of the range summed by channel, by campaign and by day
+func reportExportHandler(c *gin.Context) {
+	from := c.Query("from")
+	to := c.Query("to")
+	_, fromErr := time.Parse("2006-01-02", from)
+	_, toErr := time.Parse("2006-01-02", to)
+	if fromErr != nil || toErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be YYYY-MM-DD"})
+		return
+	}
+	if from > to {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+	report, err := etl.BuildReport(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if err := export.WriteXLSX(&buf, report); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="goetl-report-%s-%s.xlsx"`, from, to))
+	c.Data(http.StatusOK, export.XLSXContentType, buf.Bytes())
+}
+
+// runRestatementsHandler handles GET /runs/:id/restatements?limit=100&offset=0
+func runRestatementsHandler(c *gin.Context) {
+	limit := utils.ParseQueryInt(c, "limit", 100)
+	offset := utils.ParseQueryInt(c, "offset", 0)
+	restatements, total := etl.GetRestatements(c.Param("id"), limit, offset)
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"results": restatements,
+	})
+}
+
+// rollbackRequest is the body of POST /runs/:id/rollback, the inclusive date range to restore
+type rollbackRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// runRollbackHandler handles POST /runs/:id/rollback, restoring a date range to its state before the run
+func runRollbackHandler(c *gin.Context) {
+	var req rollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, fromErr := time.Parse("2006-01-02", req.From)
+	_, toErr := time.Parse("2006-01-02", req.To)
+	if fromErr != nil || toErr != nil || req.From > req.To {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be YYYY-MM-DD with from <= to"})
+		return
+	}
+	rollback, err := etl.RollbackRun(c.Param("id"), req.From, req.To)
+	if err != nil {
+		if errors.Is(err, etl.ErrRunNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rollback)
+}
+
+// anomaliesHandler handles GET /anomalies?from=YYYY-MM-DD&to=YYYY-MM-DD&channel=google_ads&limit=10&offset=0
+func anomaliesHandler(c *gin.Context) {
 	from := c.Query("from")
 	to := c.Query("to")
 	channel := c.Query("channel")
 	limit := utils.ParseQueryInt(c, "limit", 10)
 	offset := utils.ParseQueryInt(c, "offset", 0)
 
-	results := etl.GetResultsByChannel(from, to, channel, limit, offset)
+	anomalies, total := etl.GetAnomalies(from, to, channel, limit, offset)
 
 	c.JSON(http.StatusOK, gin.H{
-		"total":  len(results),
-		"limit":  limit,
-		"offset": offset,
-		"results": results,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"results": anomalies,
 	})
 }
 
+// eraseRequest is the body of POST /privacy/erase, one of both fields is required
+type eraseRequest struct {
+	Email     string `json:"email"`
+	EmailHash string `json:"email_hash"`
+}
 
-// ingestRunHandler handles POST /ingest/run?since=YYYY-MM-DD
+var contactHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// privacyEraseHandler handles POST /privacy/erase
+func privacyEraseHandler(c *gin.Context) {
+	var req eraseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash := strings.ToLower(strings.TrimSpace(req.EmailHash))
+	if req.Email != "" {
+		var err error
+		if hash, err = etl.ContactHash(req.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !contactHashPattern.MatchString(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email or a 64 character hex email_hash is required"})
+		return
+	}
+	receipt, err := etl.EraseContact(hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// privacyErasureHandler handles GET /privacy/erasures/:id
+func privacyErasureHandler(c *gin.Context) {
+	receipt := etl.GetErasureReceipt(c.Param("id"))
+	if receipt == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "erasure receipt not found"})
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// runViolationsHandler handles GET /runs/:id/violations?limit=100&offset=0
+func runViolationsHandler(c *gin.Context) {
+	limit := utils.ParseQueryInt(c, "limit", 100)
+	offset := utils.ParseQueryInt(c, "offset", 0)
+	violations, total := etl.GetViolations(c.Param("id"), limit, offset)
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"results": violations,
+	})
+}
+
+// runQualityHandler handles GET /runs/:id/quality
+func runQualityHandler(c *gin.Context) {
+	report := etl.GetQualityReport(c.Param("id"))
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "quality report not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// metricsByCampaignHandler handles GET /metrics/campaign?from=YYYY-MM-DD&to=YYYY-MM-DD&utm_campaign=google_ads&limit=10&offset=0,
+// with cursor=<next_cursor> to page after an earlier page and as_of=<timestamp> or run_id=<id> to read the
+// results as they were then
+func metricsByCampaignHandler(c *gin.Context) {
+	format, ok := formatParam(c)
+	if !ok {
+		return
+	}
+	from := c.Query("from")
+	to := c.Query("to")
+	utmCampaign := c.Query("utm_campaign")
+	limit := utils.ParseQueryInt(c, "limit", defaultLimit(format))
+	offset := utils.ParseQueryInt(c, "offset", 0)
+	cursor := c.Query("cursor")
+	asOf, ok := asOfParam(c)
+	if !ok {
+		return
+	}
+	if asOf.IsZero() {
+		if format != export.JSON {
+			streamResults(c, format, func(fn func(models.ETLResult) error) error {
+				return etl.EachResultByCampaign(from, to, utmCampaign, limit, offset, cursor, fn)
+			})
+			return
+		}
+		// Fetch results filtered by utm_campaign and date range
+		page, err := etl.GetResultsByCampaign(from, to, utmCampaign, limit, offset, cursor)
+		if err != nil {
+			c.JSON(resultsErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, pageResponse(page, limit, offset))
+		return
+	}
+	page, err := etl.GetResultsByCampaignAsOf(from, to, utmCampaign, limit, offset, cursor, asOf)
+	respondAsOf(c, format, asOf, limit, offset, page, err)
+}
+
+// campaignOpportunitiesHandler handles GET /metrics/campaign/:campaign_id/opportunities?date=YYYY-MM-DD, listing
+// the opportunities attributed to the results of the campaign that day. Contact data is masked unless the
+// request carries "Authorization: Bearer <token>" with one of PII_READER_TOKENS.
+func campaignOpportunitiesHandler(c *gin.Context) {
+	campaignID := c.Param("campaign_id")
+	date := c.Query("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	canReadContacts, err := etl.CanReadContacts(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	opportunities, err := etl.GetResultOpportunities(campaignID, date, canReadContacts)
+	switch {
+	case errors.Is(err, etl.ErrNoResults):
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no results for campaign %s on %s", campaignID, date)})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"campaign_id":     campaignID,
+		"date":            date,
+		"contacts_masked": !canReadContacts,
+		"total":           len(opportunities),
+		"results":         opportunities,
+	})
+}
+
+// metricsByChannelHandler handles GET /metrics/channel?from=YYYY-MM-DD&to=YYYY-MM-DD&channel=google_ads&limit=10&offset=0,
+// with cursor=<next_cursor> to page after an earlier page and as_of=<timestamp> or run_id=<id> to read the
+// results as they were then
+func metricsByChannelHandler(c *gin.Context) {
+	format, ok := formatParam(c)
+	if !ok {
+		return
+	}
+	from := c.Query("from")
+	to := c.Query("to")
+	channel := c.Query("channel")
+	limit := utils.ParseQueryInt(c, "limit", defaultLimit(format))
+	offset := utils.ParseQueryInt(c, "offset", 0)
+	cursor := c.Query("cursor")
+	asOf, ok := asOfParam(c)
+	if !ok {
+		return
+	}
+	if asOf.IsZero() {
+		if format != export.JSON {
+			streamResults(c, format, func(fn func(models.ETLResult) error) error {
+				return etl.EachResultByChannel(from, to, channel, limit, offset, cursor, fn)
+			})
+			return
+		}
+		page, err := etl.GetResultsByChannel(from, to, channel, limit, offset, cursor)
+		if err != nil {
+			c.JSON(resultsErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, pageResponse(page, limit, offset))
+		return
+	}
+	page, err := etl.GetResultsByChannelAsOf(from, to, channel, limit, offset, cursor, asOf)
+	respondAsOf(c, format, asOf, limit, offset, page, err)
+}
+
+// asOfParam returns the time of the past results requested with as_of, an RFC 3339 timestamp or a date
+// meaning its end in UTC, or with run_id, the time the run finished writing results. It is zero for the
+// current results. An error response is sent and false returned when the params are invalid.
+func asOfParam(c *gin.Context) (time.Time, bool) {
+	asOf := c.Query("as_of")
+	runID := c.Query("run_id")
+	switch {
+	case asOf != "" && runID != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of and run_id are mutually exclusive"})
+		return time.Time{}, false
+	case asOf != "":
+		if t, err := time.Parse(time.RFC3339Nano, asOf); err == nil {
+			return t.UTC(), true
+		}
+		if d, err := time.Parse("2006-01-02", asOf); err == nil {
+			return d.Add(24*time.Hour - time.Millisecond), true
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of must be an RFC 3339 timestamp or YYYY-MM-DD"})
+		return time.Time{}, false
+	case runID != "":
+		t, err := etl.RunLoadedAt(runID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, etl.ErrRunNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	return time.Time{}, true
+}
+
+// respondAsOf answers a metrics request for the results as they were at asOf
+func respondAsOf(c *gin.Context, format string, asOf time.Time, limit, offset int, page models.ResultPage, err error) {
+	if err != nil {
+		c.JSON(resultsErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if format != export.JSON {
+		streamResults(c, format, func(fn func(models.ETLResult) error) error {
+			for _, res := range page.Results {
+				if err := fn(res); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return
+	}
+	response := pageResponse(page, limit, offset)
+	response["as_of"] = asOf
+	c.JSON(http.StatusOK, response)
+}
+
+// pageResponse is the JSON body of a page of results. total counts every result of the query, and
+// next_cursor, present when more results follow, asks for the next page.
+func pageResponse(page models.ResultPage, limit, offset int) gin.H {
+	response := gin.H{
+		"total":   page.Total,
+		"limit":   limit,
+		"offset":  offset,
+		"results": page.Results,
+	}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+	return response
+}
+
+// resultsErrorStatus is the status of a failed metrics request: 400 for a cursor the API did not issue, 500
+// for anything else
+func resultsErrorStatus(err error) int {
+	if errors.Is(err, etl.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// formatParam returns the output format of a metrics request, from the format param or the Accept header.
+// A 400 response is sent and false returned for an unknown format.
+func formatParam(c *gin.Context) (string, bool) {
+	format, err := export.Negotiate(c.Query("format"), c.GetHeader("Accept"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return format, true
+}
+
+// defaultLimit is the page size when no limit is given: 10 results in JSON, every result in the streamed formats
+func defaultLimit(format string) int {
+	if format == export.JSON {
+		return 10
+	}
+	return 0
+}
+
+// streamResults writes the results passed by each to fn in a streamed format, encoding them as they arrive.
+// A failure before any byte is sent gets an error response; a later one can only end the response early.
+func streamResults(c *gin.Context, format string, each func(fn func(models.ETLResult) error) error) {
+	names, err := etl.MetricNames()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Type", export.ContentTypes[format])
+	if format == export.CSV {
+		c.Header("Content-Disposition", `attachment; filename="metrics.csv"`)
+	}
+	c.Status(http.StatusOK)
+	w, err := export.NewWriter(format, c.Writer, names)
+	if err == nil {
+		rows := 0
+		err = each(func(res models.ETLResult) error {
+			rows++
+			return w.Write(res)
+		})
+		if err != nil && c.Writer.Written() {
+			log.Printf("Metrics %s export stopped after %d results: %v", format, rows, err)
+			return
+		}
+	}
+	if err != nil {
+		c.Writer.Header().Del("Content-Disposition")
+		c.Writer.Header().Del("Content-Type")
+		c.JSON(resultsErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Metrics %s export failed: %v", format, err)
+	}
+}
+
+
+// ingestRunHandler handles POST /ingest/run?since=YYYY-MM-DD&source=live|staging
 func ingestRunHandler(c *gin.Context) {
 	since := c.Query("since")
+	run := etl.RunETL
+	switch source := c.DefaultQuery("source", models.ExtractLive); source {
+	case models.ExtractLive:
+	case models.ExtractStaging:
+		run = etl.RunETLFromStaging
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("source must be %q or %q", models.ExtractLive, models.ExtractStaging)})
+		return
+	}
 	// Retry logic with backoff (simple, 3 attempts)
 	var lastErr error
 	var results []models.ETLResult
+	var report *models.QualityReport
 	var err error
 	for attempt := 1; attempt <= 3; attempt++ {
-		results, err = etl.RunETL(since)
+		results, report, err = run(since)
 		if err == nil {
 			c.JSON(http.StatusOK, gin.H{
 				"message": fmt.Sprintf("ETL process completed successfully. Processed %d records.", len(results)),
+				"run_id":  report.RunID,
+				"status":  report.Status,
+				"quality": report,
 				"results": results,
 			})
 			return
 		}
 		lastErr = err
+		// A run whose results were not all written is reported as is, its quality report is already stored
+		var lErr *etl.LoadError
+		if errors.As(err, &lErr) {
+			status := http.StatusInternalServerError
+			if lErr.Partial() {
+				status = http.StatusMultiStatus
+			}
+			c.JSON(status, gin.H{
+				"error":    err.Error(),
+				"run_id":   report.RunID,
+				"status":   report.Status,
+				"quality":  report,
+				"failures": lErr.Failures,
+				"results":  results,
+			})
+			return
+		}
+		// A run aborted by validation rules fails the same way on every attempt
+		var vErr *validation.Error
+		if errors.As(err, &vErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":   err.Error(),
+				"run_id":  report.RunID,
+				"quality": report,
+			})
+			return
+		}
 		// If the client canceled the request, stop retrying
 		if c.Request.Context().Err() != nil {
 			break
@@ -1,10 +1,26 @@
 package api
 
 import (
+	"log"
+	"time"
 	"github.com/gin-gonic/gin"
+	"goetl/internal/etl"
+	"goetl/internal/utils"
 )
 
 func RunServer() {
+	// build missing indexes in the background, the status is logged and available at GET /indexes
+	go etl.EnsureIndexes()
+	// purge the data older than the RETENTION_* periods now and every RETENTION_PURGE_INTERVAL
+	etl.StartRetention()
+	if env := utils.Getenv("ETL_SCHEDULE_INTERVAL"); env != "" {
+		interval, err := time.ParseDuration(env)
+		if err != nil || interval <= 0 {
+			log.Printf("Invalid ETL_SCHEDULE_INTERVAL %q, scheduler disabled", env)
+		} else {
+			etl.StartScheduler(interval)
+		}
+	}
 	r := gin.Default()
 	RegisterRoutes(r)
 	r.Run(":8080")
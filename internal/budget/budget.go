@@ -0,0 +1,151 @@
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/utils"
+	"strconv"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Pacing statuses
+const (
+	OnTrack     = "on_track"
+	OverPacing  = "over_pacing"
+	UnderPacing = "under_pacing"
+)
+
+// Config controls how pacing is judged
+type Config struct {
+	// RunRateDays is the number of most recent days averaged into the daily run rate
+	RunRateDays int
+	// Tolerance is the fraction the projection may deviate from the budget while on track
+	Tolerance float64
+}
+
+// LoadFromEnv reads PACING_RUN_RATE_DAYS (default 7) and PACING_TOLERANCE (default 0.1)
+func LoadFromEnv() (Config, error) {
+	cfg := Config{RunRateDays: 7, Tolerance: 0.1}
+	var err error
+	if v := utils.Getenv("PACING_RUN_RATE_DAYS"); v != "" {
+		if cfg.RunRateDays, err = strconv.Atoi(v); err != nil || cfg.RunRateDays <= 0 {
+			return cfg, fmt.Errorf("invalid PACING_RUN_RATE_DAYS %q", v)
+		}
+	}
+	if v := utils.Getenv("PACING_TOLERANCE"); v != "" {
+		if cfg.Tolerance, err = strconv.ParseFloat(v, 64); err != nil || cfg.Tolerance < 0 {
+			return cfg, fmt.Errorf("invalid PACING_TOLERANCE %q", v)
+		}
+	}
+	return cfg, nil
+}
+
+// Validate checks a budget and fills StartDate and EndDate for monthly budgets
+func Validate(b *models.Budget) error {
+	if b.CampaignID == "" {
+		return errors.New("campaign_id is required")
+	}
+	if b.Amount.Sign() <= 0 {
+		return errors.New("amount must be positive")
+	}
+	switch b.Type {
+	case models.BudgetMonthly:
+		month, err := time.Parse("2006-01", b.Month)
+		if err != nil {
+			return fmt.Errorf("month must be YYYY-MM: %q", b.Month)
+		}
+		b.StartDate = month.Format(dateLayout)
+		b.EndDate = month.AddDate(0, 1, -1).Format(dateLayout)
+	case models.BudgetFlight:
+		start, err := time.Parse(dateLayout, b.StartDate)
+		if err != nil {
+			return fmt.Errorf("start_date must be YYYY-MM-DD: %q", b.StartDate)
+		}
+		end, err := time.Parse(dateLayout, b.EndDate)
+		if err != nil {
+			return fmt.Errorf("end_date must be YYYY-MM-DD: %q", b.EndDate)
+		}
+		if end.Before(start) {
+			return errors.New("end_date is before start_date")
+		}
+		b.Month = ""
+	default:
+		return fmt.Errorf("type must be %q or %q", models.BudgetMonthly, models.BudgetFlight)
+	}
+	return nil
+}
+
+// Pace compares the spend of a budget up to asOf (inclusive) with its plan. dailySpend holds
+// the campaign cost per date within the budget period.
+func Pace(cfg Config, b models.Budget, asOf string, dailySpend map[string]money.Decimal) (models.Pacing, error) {
+	start, err := time.Parse(dateLayout, b.StartDate)
+	if err != nil {
+		return models.Pacing{}, err
+	}
+	end, err := time.Parse(dateLayout, b.EndDate)
+	if err != nil {
+		return models.Pacing{}, err
+	}
+	day, err := time.Parse(dateLayout, asOf)
+	if err != nil {
+		return models.Pacing{}, err
+	}
+	if day.After(end) {
+		day = end
+	}
+	totalDays := int(end.Sub(start).Hours()/24) + 1
+	elapsedDays := int(day.Sub(start).Hours()/24) + 1
+	if elapsedDays < 0 {
+		elapsedDays = 0
+	}
+
+	p := models.Pacing{
+		BudgetID:    b.ID,
+		CampaignID:  b.CampaignID,
+		Channel:     b.Channel,
+		StartDate:   b.StartDate,
+		EndDate:     b.EndDate,
+		AsOf:        day.Format(dateLayout),
+		Budget:      b.Amount,
+		ElapsedDays: elapsedDays,
+		TotalDays:   totalDays,
+		Spend:       money.Zero,
+		RunRate:     money.Zero,
+		Status:      OnTrack,
+	}
+	for d := start; !d.After(day); d = d.AddDate(0, 0, 1) {
+		p.Spend = p.Spend.Add(dailySpend[d.Format(dateLayout)])
+	}
+
+	// average the most recent days, counting days without spend as zero
+	rateDays := cfg.RunRateDays
+	if rateDays > elapsedDays {
+		rateDays = elapsedDays
+	}
+	if rateDays > 0 {
+		recent := money.Zero
+		for i := 0; i < rateDays; i++ {
+			recent = recent.Add(dailySpend[day.AddDate(0, 0, -i).Format(dateLayout)])
+		}
+		p.RunRate = recent.Div(money.NewFromInt(rateDays)).Round(2, money.HalfUp)
+	}
+	remaining := totalDays - elapsedDays
+	p.Projected = p.Spend.Add(p.RunRate.Mul(money.NewFromInt(remaining))).Round(2, money.HalfUp)
+	p.Expected = b.Amount.Mul(money.NewFromInt(elapsedDays)).Div(money.NewFromInt(totalDays)).Round(2, money.HalfUp)
+	p.PacingRatio = p.Projected.Div(b.Amount).Round(4, money.HalfUp)
+
+	ratio := p.PacingRatio.Float64()
+	if elapsedDays > 0 {
+		switch {
+		case ratio > 1+cfg.Tolerance:
+			p.Status = OverPacing
+		case ratio < 1-cfg.Tolerance:
+			p.Status = UnderPacing
+		}
+	}
+	return p, nil
+}
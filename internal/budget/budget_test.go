@@ -0,0 +1,85 @@
+package budget
+
+import (
+	"fmt"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+)
+
+func dailySpend(from, to int, amount int) map[string]money.Decimal {
+	spend := map[string]money.Decimal{}
+	for d := from; d <= to; d++ {
+		spend[fmt.Sprintf("2025-09-%02d", d)] = money.NewFromInt(amount)
+	}
+	return spend
+}
+
+func TestValidate_Monthly(t *testing.T) {
+	b := models.Budget{CampaignID: "C1", Type: models.BudgetMonthly, Month: "2025-02", Amount: money.NewFromInt(1000)}
+	assert.NoError(t, Validate(&b))
+	assert.Equal(t, "2025-02-01", b.StartDate)
+	assert.Equal(t, "2025-02-28", b.EndDate)
+}
+
+func TestValidate_Errors(t *testing.T) {
+	cases := []models.Budget{
+		{Type: models.BudgetMonthly, Month: "2025-02", Amount: money.NewFromInt(1)},
+		{CampaignID: "C1", Type: models.BudgetMonthly, Month: "2025-02"},
+		{CampaignID: "C1", Type: models.BudgetMonthly, Month: "Feb", Amount: money.NewFromInt(1)},
+		{CampaignID: "C1", Type: models.BudgetFlight, StartDate: "2025-02-10", EndDate: "2025-02-01", Amount: money.NewFromInt(1)},
+		{CampaignID: "C1", Type: "weekly", Amount: money.NewFromInt(1)},
+	}
+	for i, b := range cases {
+		assert.Error(t, Validate(&b), "case %d", i)
+	}
+}
+
+func TestPace_OnTrack(t *testing.T) {
+	b := models.Budget{ID: "b1", CampaignID: "C1", Type: models.BudgetMonthly, Month: "2025-09", Amount: money.NewFromInt(3000)}
+	assert.NoError(t, Validate(&b))
+	p, err := Pace(Config{RunRateDays: 7, Tolerance: 0.1}, b, "2025-09-10", dailySpend(1, 10, 100))
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p.ElapsedDays)
+	assert.Equal(t, 30, p.TotalDays)
+	assert.Equal(t, "1000", p.Spend.String())
+	assert.Equal(t, "1000", p.Expected.String())
+	assert.Equal(t, "100", p.RunRate.String())
+	assert.Equal(t, "3000", p.Projected.String())
+	assert.Equal(t, OnTrack, p.Status)
+}
+
+func TestPace_OverAndUnder(t *testing.T) {
+	b := models.Budget{CampaignID: "C1", Type: models.BudgetFlight, StartDate: "2025-09-01", EndDate: "2025-09-20", Amount: money.NewFromInt(2000)}
+	assert.NoError(t, Validate(&b))
+	cfg := Config{RunRateDays: 3, Tolerance: 0.1}
+
+	// steady spend that accelerates over the last three days
+	spend := dailySpend(1, 10, 100)
+	for _, d := range []string{"2025-09-08", "2025-09-09", "2025-09-10"} {
+		spend[d] = money.NewFromInt(200)
+	}
+	p, err := Pace(cfg, b, "2025-09-10", spend)
+	assert.NoError(t, err)
+	assert.Equal(t, "1300", p.Spend.String())
+	assert.Equal(t, "200", p.RunRate.String())
+	assert.Equal(t, "3300", p.Projected.String())
+	assert.Equal(t, OverPacing, p.Status)
+
+	// no spend over the last three days
+	p, err = Pace(cfg, b, "2025-09-10", dailySpend(1, 7, 100))
+	assert.NoError(t, err)
+	assert.Equal(t, "700", p.Projected.String())
+	assert.Equal(t, UnderPacing, p.Status)
+}
+
+func TestPace_AfterPeriodEnd(t *testing.T) {
+	b := models.Budget{CampaignID: "C1", Type: models.BudgetFlight, StartDate: "2025-09-01", EndDate: "2025-09-05", Amount: money.NewFromInt(500)}
+	p, err := Pace(Config{RunRateDays: 7, Tolerance: 0.1}, b, "2025-09-30", dailySpend(1, 30, 100))
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-09-05", p.AsOf)
+	assert.Equal(t, "500", p.Spend.String())
+	assert.Equal(t, "500", p.Projected.String())
+	assert.Equal(t, OnTrack, p.Status)
+}
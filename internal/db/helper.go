@@ -3,7 +3,9 @@ package db
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"goetl/internal/utils"
 )
@@ -37,3 +39,42 @@ func GetCollection(collectionName string) (*mongo.Collection, context.Context, c
 	collection := client.Database(getDBName()).Collection(collectionName)
 	return collection, ctx, cancel
 }
+
+
+// GetDatabase returns the goetl MongoDB database, for operations spanning several collections
+func GetDatabase() (*mongo.Database, error) {
+	client, err := GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Database(getDBName()), nil
+}
+
+
+var (
+	transactionsSupported bool
+	transactionsOnce      sync.Once
+)
+
+// SupportsTransactions reports whether the server is a replica set member or a mongos, which
+// multi-document transactions require
+func SupportsTransactions() bool {
+	transactionsOnce.Do(func() {
+		client, err := GetMongoClient()
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+			log.Printf("MongoDB hello failed: %v", err)
+			return
+		}
+		transactionsSupported = hello.SetName != "" || hello.Msg == "isdbgrid"
+	})
+	return transactionsSupported
+}
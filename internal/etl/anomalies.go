@@ -0,0 +1,39 @@
+package etl
+
+import (
+	"time"
+	"goetl/internal/anomaly"
+	"goetl/internal/models"
+	"goetl/internal/store"
+)
+
+
+// detectAnomalies compares the loaded results with the rolling baselines of their channel and
+// campaign built from the stored etl_results
+func detectAnomalies(runID string, results []models.ETLResult) ([]models.Anomaly, error) {
+	cfg, err := loadAnomalyConfig()
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	from, to := results[0].Date, results[0].Date
+	for _, res := range results {
+		if res.Date < from {
+			from = res.Date
+		}
+		if res.Date > to {
+			to = res.Date
+		}
+	}
+	start, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return nil, err
+	}
+	history := queryResults(store.Query{From: start.AddDate(0, 0, -cfg.WindowDays).Format("2006-01-02"), To: to})
+	anomalies := anomaly.Detect(cfg, history, results)
+	now := time.Now().UTC()
+	for i := range anomalies {
+		anomalies[i].RunID = runID
+		anomalies[i].CreatedAt = now
+	}
+	return anomalies, nil
+}
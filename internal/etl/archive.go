@@ -0,0 +1,78 @@
+package etl
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+	"goetl/internal/models"
+	"goetl/internal/pii"
+)
+
+
+// deadLetterAd records a discarded ad performance record in report
+func deadLetterAd(report *models.QualityReport, ad models.AdPerformance, reason string) {
+	payload, _ := json.Marshal(ad)
+	log.Printf("Dropped ads record %s/%s/%s: %s", ad.Date, ad.Channel, ad.CampaignID, reason)
+	report.AddDeadLetter(models.DeadLetter{
+		Source:    models.SourceAds,
+		Reason:    reason,
+		Payload:   string(payload),
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+
+// deadLetterOpportunity records a discarded opportunity in report with its contact email redacted
+func deadLetterOpportunity(report *models.QualityReport, policy *pii.Policy, opp models.Opportunity, reason string) {
+	opp = policy.Redact(opp)
+	payload, _ := json.Marshal(opp)
+	log.Printf("Dropped opportunity %s (%s): %s", opp.OpportunityID, opp.ContactEmail, reason)
+	report.AddDeadLetter(models.DeadLetter{
+		Source:      models.SourceOpportunities,
+		Reason:      reason,
+		Payload:     string(payload),
+		ContactHash: opp.ContactEmailHash,
+		CreatedAt:   time.Now().UTC(),
+	})
+}
+
+
+// buildRawPayloads archives the extracted records of a run, hashing contact emails and
+// encrypting the payloads when the policy has a key
+func buildRawPayloads(runID string, policy *pii.Policy, ads []models.AdPerformance, opportunities []models.Opportunity) ([]models.RawPayload, error) {
+	protected := make([]models.Opportunity, 0, len(opportunities))
+	hashes := make([]string, 0, len(opportunities))
+	for _, opp := range opportunities {
+		opp = policy.Protect(opp)
+		if opp.ContactEmailHash != "" {
+			hashes = append(hashes, opp.ContactEmailHash)
+		}
+		protected = append(protected, opp)
+	}
+	adsPayload, err := newRawPayload(runID, models.SourceAds, policy, ads, len(ads))
+	if err != nil {
+		return nil, err
+	}
+	oppPayload, err := newRawPayload(runID, models.SourceOpportunities, policy, protected, len(protected))
+	if err != nil {
+		return nil, err
+	}
+	oppPayload.ContactHashes = hashes
+	return []models.RawPayload{adsPayload, oppPayload}, nil
+}
+
+
+func newRawPayload(runID, source string, policy *pii.Policy, records interface{}, count int) (models.RawPayload, error) {
+	raw := models.RawPayload{RunID: runID, Source: source, Records: count, CreatedAt: time.Now().UTC()}
+	b, err := json.Marshal(records)
+	if err != nil {
+		return raw, err
+	}
+	if !policy.Encrypts() {
+		raw.Payload = string(b)
+		return raw, nil
+	}
+	raw.Ciphertext, err = policy.Encrypt(b)
+	raw.Encrypted = err == nil
+	return raw, err
+}
@@ -0,0 +1,192 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"goetl/internal/budget"
+	"goetl/internal/db"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/store"
+	"goetl/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const budgetCollection = "budgets"
+
+// ErrBudgetNotFound is returned when a budget id does not exist
+var ErrBudgetNotFound = errors.New("budget not found")
+
+// InvalidBudgetError is returned by SaveBudget when the budget does not pass budget.Validate
+type InvalidBudgetError struct {
+	Err error
+}
+
+func (e *InvalidBudgetError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidBudgetError) Unwrap() error {
+	return e.Err
+}
+
+
+// SaveBudget validates and stores a budget, creating it when ID is empty and replacing it otherwise
+func SaveBudget(b *models.Budget) error {
+	if err := budget.Validate(b); err != nil {
+		return &InvalidBudgetError{Err: err}
+	}
+	collection, ctx, cancel := db.GetCollection(budgetCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return ErrDatabaseUnavailable
+	}
+	defer cancel()
+	now := time.Now().UTC()
+	b.UpdatedAt = now
+	if b.ID == "" {
+		b.ID = utils.NewID()
+		b.CreatedAt = now
+		_, err := collection.InsertOne(ctx, b)
+		return err
+	}
+	var existing models.Budget
+	if err := collection.FindOne(ctx, bson.M{"id": b.ID}).Decode(&existing); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrBudgetNotFound
+		}
+		return err
+	}
+	b.CreatedAt = existing.CreatedAt
+	_, err := collection.ReplaceOne(ctx, bson.M{"id": b.ID}, b)
+	return err
+}
+
+
+// GetBudget returns a budget by id, or nil if it does not exist
+func GetBudget(id string) *models.Budget {
+	collection, ctx, cancel := db.GetCollection(budgetCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil
+	}
+	defer cancel()
+	var b models.Budget
+	if err := collection.FindOne(ctx, bson.M{"id": id}).Decode(&b); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Failed to fetch Budget: %v", err)
+		}
+		return nil
+	}
+	return &b
+}
+
+
+// DeleteBudget removes a budget by id
+func DeleteBudget(id string) error {
+	collection, ctx, cancel := db.GetCollection(budgetCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return ErrDatabaseUnavailable
+	}
+	defer cancel()
+	res, err := collection.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrBudgetNotFound
+	}
+	return nil
+}
+
+
+// GetBudgets returns the budgets of a campaign (all when empty) active on date (any when empty), paginated,
+// with the count of every budget matching the filters
+func GetBudgets(campaignID, date string, limit, offset int) ([]models.Budget, int) {
+	collection, ctx, cancel := db.GetCollection(budgetCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil, 0
+	}
+	defer cancel()
+	filter := bson.M{}
+	if campaignID != "" {
+		filter["campaignid"] = campaignID
+	}
+	if date != "" {
+		filter["startdate"] = bson.M{"$lte": date}
+		filter["enddate"] = bson.M{"$gte": date}
+	}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to count Budgets: %v", err)
+		return nil, 0
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "startdate", Value: -1}, {Key: "campaignid", Value: 1}, {Key: "id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Failed to fetch Budgets: %v", err)
+		return nil, 0
+	}
+	defer cursor.Close(ctx)
+	var budgets []models.Budget
+	if err := cursor.All(ctx, &budgets); err != nil {
+		log.Printf("Failed to decode Budgets: %v", err)
+	}
+	return budgets, int(total)
+}
+
+
+// GetPacing compares the spend stored in etl_results with every budget active on asOf,
+// optionally restricted to one campaign
+func GetPacing(asOf, campaignID string) ([]models.Pacing, error) {
+	cfg, err := loadPacingConfig()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := time.Parse("2006-01-02", asOf); err != nil {
+		return nil, err
+	}
+	budgets, _ := GetBudgets(campaignID, asOf, 0, 0)
+	pacing := make([]models.Pacing, 0, len(budgets))
+	for _, b := range budgets {
+		spend, err := dailySpend(b, asOf)
+		if err != nil {
+			return nil, err
+		}
+		p, err := budget.Pace(cfg, b, asOf, spend)
+		if err != nil {
+			return nil, err
+		}
+		pacing = append(pacing, p)
+	}
+	return pacing, nil
+}
+
+
+// dailySpend sums the stored cost of the campaign (and channel, if set) of b per date, from its start to asOf
+func dailySpend(b models.Budget, asOf string) (map[string]money.Decimal, error) {
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	q := store.Query{From: b.StartDate, To: asOf, CampaignID: b.CampaignID, Channel: b.Channel}
+	aggregates, err := resultStore.Aggregate(ctx, q, store.GroupDate)
+	if err != nil {
+		return nil, err
+	}
+	spend := make(map[string]money.Decimal, len(aggregates))
+	for _, agg := range aggregates {
+		spend[agg.Date] = agg.Cost
+	}
+	return spend, nil
+}
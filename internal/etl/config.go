@@ -0,0 +1,197 @@
+package etl
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"goetl/internal/anomaly"
+	"goetl/internal/budget"
+	"goetl/internal/export"
+	"goetl/internal/metrics"
+	"goetl/internal/lake"
+	"goetl/internal/pii"
+	"goetl/internal/retention"
+	"goetl/internal/store"
+	"goetl/internal/utils"
+	"goetl/internal/validation"
+)
+
+var (
+	metricSetInstance *metrics.Set
+	metricSetError    error
+	metricSetOnce     sync.Once
+
+	validatorInstance *validation.Validator
+	validatorError    error
+	validatorOnce     sync.Once
+
+	policyInstance *pii.Policy
+	policyError    error
+	policyOnce     sync.Once
+
+	anomalyConfig      anomaly.Config
+	anomalyConfigError error
+	anomalyConfigOnce  sync.Once
+
+	pacingConfig      budget.Config
+	pacingConfigError error
+	pacingConfigOnce  sync.Once
+
+	retentionConfig      retention.Config
+	retentionConfigError error
+	retentionConfigOnce  sync.Once
+
+	lakeConfig      lake.Config
+	lakeConfigError error
+	lakeConfigOnce  sync.Once
+
+	resultStoreInstance store.ResultStore
+	resultStoreError    error
+	resultStoreOnce     sync.Once
+)
+
+// loadMetricSet returns the derived metric definitions, read once from METRICS_CONFIG
+func loadMetricSet() (*metrics.Set, error) {
+	metricSetOnce.Do(func() {
+		metricSetInstance, metricSetError = metrics.LoadFromEnv()
+		if metricSetError != nil {
+			log.Printf("Invalid metrics configuration: %v", metricSetError)
+		}
+	})
+	return metricSetInstance, metricSetError
+}
+
+// loadValidator returns the ad performance validation rules, read once from VALIDATION_CONFIG
+func loadValidator() (*validation.Validator, error) {
+	validatorOnce.Do(func() {
+		validatorInstance, validatorError = validation.LoadFromEnv()
+		if validatorError != nil {
+			log.Printf("Invalid validation configuration: %v", validatorError)
+		}
+	})
+	return validatorInstance, validatorError
+}
+
+// loadPolicy returns the PII policy, read once from the PII_* environment variables
+func loadPolicy() (*pii.Policy, error) {
+	policyOnce.Do(func() {
+		policyInstance, policyError = pii.LoadFromEnv()
+		if policyError != nil {
+			log.Printf("Invalid PII configuration: %v", policyError)
+		}
+	})
+	return policyInstance, policyError
+}
+
+// loadAnomalyConfig returns the anomaly detection settings, read once from the ANOMALY_* environment variables
+func loadAnomalyConfig() (anomaly.Config, error) {
+	anomalyConfigOnce.Do(func() {
+		anomalyConfig, anomalyConfigError = anomaly.LoadFromEnv()
+		if anomalyConfigError != nil {
+			log.Printf("Invalid anomaly configuration: %v", anomalyConfigError)
+		}
+	})
+	return anomalyConfig, anomalyConfigError
+}
+
+// loadPacingConfig returns the budget pacing settings, read once from the PACING_* environment variables
+func loadPacingConfig() (budget.Config, error) {
+	pacingConfigOnce.Do(func() {
+		pacingConfig, pacingConfigError = budget.LoadFromEnv()
+		if pacingConfigError != nil {
+			log.Printf("Invalid pacing configuration: %v", pacingConfigError)
+		}
+	})
+	return pacingConfig, pacingConfigError
+}
+
+// loadRetentionConfig returns the retention policies, read once from the RETENTION_* environment variables
+func loadRetentionConfig() (retention.Config, error) {
+	retentionConfigOnce.Do(func() {
+		retentionConfig, retentionConfigError = retention.LoadFromEnv()
+		if retentionConfigError != nil {
+			log.Printf("Invalid retention configuration: %v", retentionConfigError)
+		}
+	})
+	return retentionConfig, retentionConfigError
+}
+
+// loadLakeConfig returns the lake export settings, read once from LAKE_DIR and LAKE_STAGING
+func loadLakeConfig() (lake.Config, error) {
+	lakeConfigOnce.Do(func() {
+		lakeConfig, lakeConfigError = lake.LoadFromEnv()
+		if lakeConfigError != nil {
+			log.Printf("Invalid lake configuration: %v", lakeConfigError)
+		}
+	})
+	return lakeConfig, lakeConfigError
+}
+
+// loadResultStore is replaced in tests to use a memory store
+var loadResultStore = openResultStore
+
+// openResultStore returns the result store selected once with RESULT_STORE (mongo, memory, postgres or sqlite)
+func openResultStore() (store.ResultStore, error) {
+	resultStoreOnce.Do(func() {
+		resultStoreInstance, resultStoreError = store.Open(store.Config{
+			Backend:     utils.Getenv("RESULT_STORE"),
+			PostgresDSN: utils.Getenv("POSTGRES_DSN"),
+			SQLitePath:  sqlitePath(),
+			BatchSize:   loadBatchSize(),
+			MongoSwap:   utils.Getenv("MONGO_REPLACE_SWAP") == "true",
+		})
+		if resultStoreError != nil {
+			log.Printf("Invalid result store configuration: %v", resultStoreError)
+		}
+	})
+	return resultStoreInstance, resultStoreError
+}
+
+// sqlitePath returns the database file of the sqlite result store, set with SQLITE_PATH (default goetl.db)
+func sqlitePath() string {
+	if path := utils.Getenv("SQLITE_PATH"); path != "" {
+		return path
+	}
+	return "goetl.db"
+}
+
+// loadBatchSize returns the number of results per bulk write, set with LOAD_BATCH_SIZE (default 1000)
+func loadBatchSize() int {
+	size, err := strconv.Atoi(utils.Getenv("LOAD_BATCH_SIZE"))
+	if err != nil || size <= 0 {
+		return 1000
+	}
+	return size
+}
+
+// loadParallelism returns the number of bulk writes run concurrently, set with LOAD_PARALLELISM (default 4)
+func loadParallelism() int {
+	n, err := strconv.Atoi(utils.Getenv("LOAD_PARALLELISM"))
+	if err != nil || n <= 0 {
+		return 4
+	}
+	return n
+}
+
+// loadModeReplace is the LOAD_MODE of runs replacing the range they reprocess
+const loadModeReplace = "replace"
+
+// replaceRanges reports whether runs replace the date range they reprocess instead of only upserting
+// its rows, enabled with LOAD_MODE=replace
+func replaceRanges() bool {
+	return utils.Getenv("LOAD_MODE") == loadModeReplace
+}
+
+// reportCurrencyFormat returns the Excel number format of money amounts in the workbook report, set with
+// REPORT_CURRENCY_FORMAT (default "$"#,##0.00)
+func reportCurrencyFormat() string {
+	if format := utils.Getenv("REPORT_CURRENCY_FORMAT"); format != "" {
+		return format
+	}
+	return export.DefaultCurrencyFormat
+}
+
+// archiveRawPayloads reports whether extracted payloads are archived, set with ARCHIVE_RAW_PAYLOADS=true
+func archiveRawPayloads() bool {
+	return utils.Getenv("ARCHIVE_RAW_PAYLOADS") == "true"
+}
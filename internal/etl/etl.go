@@ -1,34 +1,163 @@
 package etl
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"goetl/internal/models"
 	"goetl/internal/utils"
 	"goetl/internal/clients"
-	"strings"
+	"goetl/internal/store"
+	"goetl/internal/validation"
 	"log"
+	"sync"
 	"time"
 )
 
 
 // RunETL orchestrates the ETL process: Extract, Transform, Load. It accepts an optional 'since' parameter to filter data.
-func RunETL(since string) ([]models.ETLResult, error) {
-       ads, crm, err := Extract()
-       if err != nil {
-	       return nil, err
-       }
-       results, err := Transform(ads, crm, since)
-
-       if err != nil {
-            return nil, err
-       }
-	   if len(results) == 0 {
-		   log.Println("No ETL results to load")
-		   return results, nil
-	   }
-       Load(results)
-       // code to wait the persistence of results
-       time.Sleep(1 * time.Second)
-       return results, nil
+// Every run produces a data quality report which is persisted under the run id. When results fail to load, the
+// report status is partial or failed and a *LoadError is returned along with the results.
+func RunETL(since string) ([]models.ETLResult, *models.QualityReport, error) {
+	return runETL(since, models.ExtractLive, false)
+}
+
+
+// RunETLFromStaging runs the ETL like RunETL over the records of the staging collections instead of
+// extracting them from the APIs
+func RunETLFromStaging(since string) ([]models.ETLResult, *models.QualityReport, error) {
+	return runETL(since, models.ExtractStaging, false)
+}
+
+
+// runETL runs the ETL over records extracted live or read from staging, widening since to the restatement
+// window. Scheduled runs without a since are limited to the window instead of reprocessing every date.
+func runETL(since, extraction string, scheduled bool) ([]models.ETLResult, *models.QualityReport, error) {
+	report := models.NewQualityReport(utils.NewID(), since)
+	report.Extraction = extraction
+	version, err := transformVersion()
+	if err != nil {
+		return nil, nil, err
+	}
+	report.TransformVersion = version
+	days := restatementDays()
+	report.EffectiveSince = since
+	if since != "" || scheduled {
+		report.EffectiveSince = effectiveSince(since, days, time.Now())
+	}
+	var ads []models.AdPerformance
+	var crm []models.Opportunity
+	if extraction == models.ExtractStaging {
+		ads, crm, err = GetStaging(report.EffectiveSince)
+	} else {
+		ads, crm, err = Extract()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if extraction == models.ExtractLive && archiveRawPayloads() {
+		policy, err := loadPolicy()
+		if err != nil {
+			return nil, nil, err
+		}
+		payloads, err := buildRawPayloads(report.RunID, policy, ads, crm)
+		if err != nil {
+			return nil, nil, err
+		}
+		SaveRawPayloads(payloads)
+	}
+	batch, err := transform(ads, crm, report.EffectiveSince, report)
+	if err != nil {
+		var vErr *validation.Error
+		if !errors.As(err, &vErr) {
+			return nil, nil, err
+		}
+		// keep the evidence of a run aborted by validation rules
+		report.Status = models.RunFailed
+		report.Error = err.Error()
+		report.FinishedAt = time.Now().UTC()
+		SaveQualityReport(report)
+		SaveViolations(report.ViolationRecords())
+		SaveDeadLetters(report.DeadLetterRecords())
+		return nil, report, err
+	}
+	if extraction == models.ExtractLive && stageRecords() {
+		if err := SaveStaging(report.RunID, batch.NormalizedAds, batch.NormalizedOpportunities); err != nil {
+			log.Printf("Failed to save staging records: %v", err)
+		}
+	}
+	results := batch.Results
+	for i := range results {
+		results[i].RunID = report.RunID
+		results[i].TransformVersion = report.TransformVersion
+	}
+	restatements := detectRestatements(report.RunID, results)
+	report.Results = len(results)
+	report.Status = models.RunCompleted
+	var loadErr error
+	if len(results) == 0 {
+		log.Println("No ETL results to load")
+	} else {
+		if replaceRanges() {
+			report.Load, loadErr = ReplaceRange(report.RunID, report.EffectiveSince, results)
+		} else {
+			report.Load, loadErr = Load(results)
+		}
+		var lErr *LoadError
+		switch {
+		case errors.As(loadErr, &lErr) && lErr.Partial():
+			report.Status = models.RunPartial
+			restatements = withoutFailures(restatements, lErr.Failures)
+		case loadErr != nil:
+			report.Status = models.RunFailed
+			restatements = nil
+		}
+		if loadErr != nil {
+			report.Error = loadErr.Error()
+		}
+		if report.Status != models.RunFailed {
+			var failures []models.LoadFailure
+			if errors.As(loadErr, &lErr) {
+				failures = lErr.Failures
+			}
+			if err := SaveLineage(report.RunID, writtenLineage(batch.Lineage, results, failures)); err != nil {
+				log.Printf("Failed to save result lineage: %v", err)
+			}
+			if report.LakeManifest, err = ExportLake(report, batch, failures); err != nil {
+				log.Printf("Failed to export the lake: %v", err)
+			}
+			anomalies, err := detectAnomalies(report.RunID, results)
+			if err != nil {
+				log.Printf("Anomaly detection skipped: %v", err)
+			} else {
+				SaveAnomalies(results, anomalies)
+			}
+			report.Anomalies = len(anomalies)
+		}
+	}
+	report.Restated = len(restatements)
+	report.FinishedAt = time.Now().UTC()
+	SaveQualityReport(report)
+	SaveViolations(report.ViolationRecords())
+	SaveDeadLetters(report.DeadLetterRecords())
+	SaveRestatements(restatements)
+	return results, report, loadErr
+}
+
+
+// withoutFailures drops the restatements of results that were not written
+func withoutFailures(restatements []models.Restatement, failures []models.LoadFailure) []models.Restatement {
+	failed := make(map[string]bool, len(failures))
+	for _, f := range failures {
+		failed[f.Date+":"+f.Channel+":"+f.CampaignID] = true
+	}
+	kept := restatements[:0]
+	for _, r := range restatements {
+		if !failed[r.Date+":"+r.Channel+":"+r.CampaignID] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
 }
 
 
@@ -46,157 +175,216 @@ func Extract() ([]models.AdPerformance, []models.Opportunity, error) {
 }
 
 
-// Transformation of data, filters by 'since', deduplicates, normalizes, crosses, calculates metrics, and persists results.
-func Transform(ads []models.AdPerformance, opportunities []models.Opportunity, since string) ([]models.ETLResult, error) {
-	opportunities = TransformOpportunitiesData(opportunities)
-	ads = TransformPerformanceData(ads)
+// Transformation of data: runs the transform pipeline (normalize, filter by 'since', deduplicate, join,
+// enrich, compute metrics by default, see TRANSFORM_STAGES) over the extracted records.
+// Dropped records, resolved duplicates, join statistics and stage timings are recorded in report, which may be nil.
+func Transform(ads []models.AdPerformance, opportunities []models.Opportunity, since string, report *models.QualityReport) ([]models.ETLResult, error) {
+	batch, err := transform(ads, opportunities, since, report)
+	if err != nil {
+		return nil, err
+	}
+	return batch.Results, nil
+}
 
-	// Filter by 'since' date if provided
-	adsFiltered := make([]models.AdPerformance, 0)
-	for _, ad := range ads {
-		if since == "" || ad.Date >= since {
-			adsFiltered = append(adsFiltered, ad)
-		}
+
+// transform runs the transform pipeline and returns the batch it left, with the normalized records
+func transform(ads []models.AdPerformance, opportunities []models.Opportunity, since string, report *models.QualityReport) (*Batch, error) {
+	pipeline, err := loadPipeline()
+	if err != nil {
+		return nil, err
 	}
-	opportunitiesFiltered := make([]models.Opportunity, 0)
-	for _, opp := range opportunities {
-		if since == "" || strings.HasPrefix(opp.CreatedAt, since) || opp.CreatedAt >= since {
-			opportunitiesFiltered = append(opportunitiesFiltered, opp)
-		}
+	report.AddInput(models.SourceAds, len(ads))
+	report.AddInput(models.SourceOpportunities, len(opportunities))
+	batch := &Batch{Since: since, Ads: ads, Opportunities: opportunities, Report: report}
+	if err := pipeline.Run(batch); err != nil {
+		return nil, err
 	}
+	return batch, nil
+}
 
-	// Deduplicate ads by (date, channel, campaign_id)
-	adsMap := make(map[string]models.AdPerformance)
-	for _, ad := range adsFiltered {
-		key := ad.Date + ":" + ad.Channel + ":" + ad.CampaignID
-		adsMap[key] = ad
-	}
 
-	// Deduplicate CRM by (created_at, utm_campaign, utm_source, utm_medium)
-	opportunitiesMap := make(map[string]models.Opportunity)
-	for _, opp := range opportunitiesFiltered {
-		key := opp.CreatedAt + ":" + opp.UTMCampaign + ":" + opp.UTMSource + ":" + opp.UTMMedium
-		opportunitiesMap[key] = opp
-	}
+// LoadError is returned by Load when some results could not be written
+type LoadError struct {
+	Summary  models.LoadSummary
+	Failures []models.LoadFailure
+}
 
-	// Cross ads and CRM by utm_campaign, utm_source, utm_medium
-	results := make([]models.ETLResult, 0)
-	for _, ad := range adsMap {
-		var leads, opportunities, closedWon int
-		var revenue float64
-		for _, opp := range opportunitiesMap {
-			if ad.Date == opp.CreatedAt && ad.UTMCampaign == opp.UTMCampaign && ad.UTMSource == opp.UTMSource && ad.UTMMedium == opp.UTMMedium {
-				opportunities++
-				if opp.Stage == "lead" {
-					leads++
-				}
-				if opp.Stage == "closed_won" {
-					closedWon++
-					revenue += opp.Amount
-				}
-			}
-		}
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("failed to load %d of %d results", e.Summary.Failed, e.Summary.Rows)
+}
 
-		// Calculate metrics
-		cpc := 0.0
-		if ad.Clicks > 0 {
-			cpc = ad.Cost / float64(ad.Clicks)
-		}
-		cpa := 0.0
-		if leads > 0 {
-			cpa = ad.Cost / float64(leads)
-		}
-		cvrLeadToOpp := 0.0
-		if leads > 0 {
-			cvrLeadToOpp = float64(opportunities) / float64(leads)
-		}
-		cvrOppToWon := 0.0
-		if opportunities > 0 {
-			cvrOppToWon = float64(closedWon) / float64(opportunities)
-		}
-		roas := 0.0
-		if ad.Cost > 0 {
-			roas = revenue / ad.Cost
-		}
+// Partial reports whether some results were written despite the failures
+func (e *LoadError) Partial() bool {
+	return e.Summary.Failed < e.Summary.Rows
+}
 
-		res := models.ETLResult{
-			Date:          ad.Date,
-			Channel:       ad.Channel,
-			CampaignID:    ad.CampaignID,
-			UTMCampaign:   ad.UTMCampaign,
-			Clicks:        ad.Clicks,
-			Impressions:   ad.Impressions,
-			Cost:          ad.Cost,
-			Leads:         leads,
-			Opportunities: opportunities,
-			ClosedWon:     closedWon,
-			Revenue:       revenue,
-			CPC:           utils.RoundFloat(cpc, 2),
-			CPA:           utils.RoundFloat(cpa, 2),
-			CVRLeadToOpp:  utils.RoundFloat(cvrLeadToOpp, 2),
-			CVROppToWon:   utils.RoundFloat(cvrOppToWon, 2),
-			ROAS:          utils.RoundFloat(roas, 2),
-		}
-		results = append(results, res)
+
+// Load upserts the ETL results into the result store by (date, channel, campaignid), LOAD_BATCH_SIZE
+// results per batch and LOAD_PARALLELISM batches at a time.
+// A *LoadError listing every row that was not written is returned if any batch fails.
+func Load(data []models.ETLResult) (models.LoadSummary, error) {
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return failedLoad(models.LoadUpsert, data, err)
+	}
+	type batchResult struct {
+		summary  models.LoadSummary
+		failures []models.LoadFailure
 	}
+	batches := splitBatches(data, loadBatchSize())
+	out := make(chan batchResult, len(batches))
+	sem := make(chan struct{}, loadParallelism())
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(batch []models.ETLResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			defer cancel()
+			summary, failures := resultStore.Save(ctx, batch)
+			out <- batchResult{summary, failures}
+		}(batch)
+	}
+	wg.Wait()
+	close(out)
+	summary := models.LoadSummary{Mode: models.LoadUpsert}
+	var failures []models.LoadFailure
+	for r := range out {
+		summary.Add(r.summary)
+		failures = append(failures, r.failures...)
+	}
+	if summary.Failed > 0 {
+		return summary, &LoadError{Summary: summary, Failures: failures}
+	}
+	return summary, nil
+}
+
 
-	return results, nil
+// ReplaceRange loads data, stamped with runID, as the new content of the range of the result store it was
+// computed from: the dates on or after from (every date when empty) of the channels present in data. Stored
+// rows of that range that data does not rewrite are deleted atomically, see the backends of store.ResultStore.
+// Nothing is written on failure and every row is reported failed.
+func ReplaceRange(runID, from string, data []models.ETLResult) (models.LoadSummary, error) {
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return failedLoad(models.LoadTransaction, data, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	summary, err := resultStore.Replace(ctx, runID, from, data)
+	if err != nil {
+		return failedLoad(summary.Mode, data, err)
+	}
+	return summary, nil
 }
 
 
-// Load persists the ETL results into MongoDB.
-func Load(data []models.ETLResult) {
-       for _, result := range data {
-	       SaveResult(result)
-       }
+// failedLoad reports every row of data as failed with err
+func failedLoad(mode string, data []models.ETLResult, err error) (models.LoadSummary, error) {
+	summary := models.LoadSummary{Mode: mode, Rows: len(data), Failed: len(data)}
+	return summary, &LoadError{Summary: summary, Failures: store.Failures(data, err)}
 }
 
 
-// transforms and normalizes ads performance data
-func TransformPerformanceData(data []models.AdPerformance) []models.AdPerformance {
-	var err error
+// splitBatches splits data into consecutive batches of at most size results
+func splitBatches(data []models.ETLResult, size int) [][]models.ETLResult {
+	var batches [][]models.ETLResult
+	for start := 0; start < len(data); start += size {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		batches = append(batches, data[start:end])
+	}
+	return batches
+}
+
+
+// transforms, normalizes and validates ads performance data, recording discarded records and
+// rule violations in report. A *validation.Error is returned if any record breaks a fail rule.
+func TransformPerformanceData(data []models.AdPerformance, report *models.QualityReport) ([]models.AdPerformance, error) {
+	validator, err := loadValidator()
+	if err != nil {
+		return nil, err
+	}
+	var failures []models.Violation
 	transformed := make([]models.AdPerformance, 0, len(data))
 	for _, ad := range data {
-		if ad.Date != "" {
-			ad.Date, err = utils.NormalizeDate(ad.Date)
-			if err != nil {
-				ad.Date = ""
+		if ad.Date == "" {
+			deadLetterAd(report, ad, models.DropMissingDate)
+			continue
+		}
+		date, err := utils.NormalizeDate(ad.Date)
+		if err != nil {
+			deadLetterAd(report, ad, models.DropInvalidDate)
+			continue
+		}
+		ad.Date = date
+		if ad.Channel == "" || ad.CampaignID == "" {
+			deadLetterAd(report, ad, models.DropMissingKeys)
+			continue
+		}
+		ad.Channel = utils.SanitizeString(ad.Channel)
+		ad.CampaignID = utils.SanitizeString(ad.CampaignID)
+		ad.Clicks = utils.SanitizeInt(ad.Clicks)
+		ad.Impressions = utils.SanitizeInt(ad.Impressions)
+		ad.Cost = utils.SanitizeFloat(ad.Cost)
+		drop := false
+		for _, v := range validator.Validate(ad) {
+			report.AddViolation(v)
+			switch validation.Severity(v.Severity) {
+			case validation.SeverityDrop:
+				drop = true
+			case validation.SeverityFail:
+				failures = append(failures, v)
 			}
 		}
-		if ad.Date != "" && ad.Channel != "" && ad.CampaignID != "" {
-			ad.Channel = utils.SanitizeString(ad.Channel)
-			ad.CampaignID = utils.SanitizeString(ad.CampaignID)
-			ad.Clicks = utils.SanitizeInt(ad.Clicks)
-			ad.Impressions = utils.SanitizeInt(ad.Impressions)
-			ad.Cost = utils.SanitizeFloat(ad.Cost)
-			transformed = append(transformed, ad)
+		if drop {
+			deadLetterAd(report, ad, models.DropValidation)
+			continue
 		}
+		transformed = append(transformed, ad)
+	}
+	if len(failures) > 0 {
+		return nil, &validation.Error{Violations: failures}
 	}
-	return transformed
+	return transformed, nil
 }
 
 
-// transforms and normalizes CRM opportunities data
-func TransformOpportunitiesData(data []models.Opportunity) []models.Opportunity {
-	var err error
+// transforms and normalizes CRM opportunities data, recording discarded records in report.
+// Contact emails are hashed and, unless the PII policy allows plaintext, cleared.
+func TransformOpportunitiesData(data []models.Opportunity, report *models.QualityReport) ([]models.Opportunity, error) {
+	policy, err := loadPolicy()
+	if err != nil {
+		return nil, err
+	}
 	transformed := make([]models.Opportunity, 0, len(data))
 	for _, opp := range data {
-		if opp.CreatedAt != "" {
-			opp.CreatedAt, err = utils.NormalizeDate(opp.CreatedAt)
-			if err != nil {
-				opp.CreatedAt = ""
-			}
+		if opp.CreatedAt == "" {
+			deadLetterOpportunity(report, policy, opp, models.DropMissingDate)
+			continue
 		}
-		if opp.CreatedAt != "" && opp.UTMCampaign != "" && opp.UTMSource != "" && opp.UTMMedium != "" {
-			opp.ContactEmail = utils.SanitizeString(opp.ContactEmail)
-			opp.UTMCampaign = utils.SanitizeString(opp.UTMCampaign)
-			opp.UTMSource = utils.SanitizeString(opp.UTMSource)
-			opp.UTMMedium = utils.SanitizeString(opp.UTMMedium)
-			opp.Stage = utils.SanitizeString(opp.Stage)
-			opp.Amount = utils.SanitizeFloat(opp.Amount)
-			opp.OpportunityID = utils.SanitizeString(opp.OpportunityID)
-			transformed = append(transformed, opp)
+		date, err := utils.NormalizeDate(opp.CreatedAt)
+		if err != nil {
+			deadLetterOpportunity(report, policy, opp, models.DropInvalidDate)
+			continue
 		}
+		opp.CreatedAt = date
+		if opp.UTMCampaign == "" || opp.UTMSource == "" || opp.UTMMedium == "" {
+			deadLetterOpportunity(report, policy, opp, models.DropMissingKeys)
+			continue
+		}
+		opp.ContactEmail = utils.SanitizeString(opp.ContactEmail)
+		opp.UTMCampaign = utils.SanitizeString(opp.UTMCampaign)
+		opp.UTMSource = utils.SanitizeString(opp.UTMSource)
+		opp.UTMMedium = utils.SanitizeString(opp.UTMMedium)
+		opp.Stage = utils.SanitizeString(opp.Stage)
+		opp.Amount = utils.SanitizeFloat(opp.Amount)
+		opp.OpportunityID = utils.SanitizeString(opp.OpportunityID)
+		transformed = append(transformed, policy.Protect(opp))
 	}
-	return transformed
-}
\ No newline at end of file
+	return transformed, nil
+}
@@ -0,0 +1,105 @@
+package etl
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/store"
+)
+
+func TestSplitBatches(t *testing.T) {
+	data := make([]models.ETLResult, 5)
+	batches := splitBatches(data, 2)
+	assert.Len(t, batches, 3)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[2], 1)
+	assert.Len(t, splitBatches(data, 10), 1)
+	assert.Empty(t, splitBatches(nil, 2))
+}
+
+func TestLoadError(t *testing.T) {
+	err := &LoadError{Summary: models.LoadSummary{Rows: 3, Failed: 1}}
+	assert.Equal(t, "failed to load 1 of 3 results", err.Error())
+	assert.True(t, err.Partial())
+	err.Summary.Failed = 3
+	assert.False(t, err.Partial())
+}
+
+func TestWithoutFailures(t *testing.T) {
+	restatements := []models.Restatement{
+		{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C1"},
+		{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C2"},
+	}
+	failures := []models.LoadFailure{{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C1", Error: "boom"}}
+	kept := withoutFailures(restatements, failures)
+	assert.Len(t, kept, 1)
+	assert.Equal(t, "C2", kept[0].CampaignID)
+}
+
+// useMemoryStore makes loadResultStore return a new memory store until the test ends
+func useMemoryStore(t *testing.T) *store.Memory {
+	memory := store.NewMemory()
+	previous := loadResultStore
+	loadResultStore = func() (store.ResultStore, error) {
+		return memory, nil
+	}
+	t.Cleanup(func() { loadResultStore = previous })
+	return memory
+}
+
+func TestLoad_MemoryStore(t *testing.T) {
+	useMemoryStore(t)
+
+	data := []models.ETLResult{
+		{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C1", Cost: money.NewFromInt(10)},
+		{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C2", Cost: money.NewFromInt(20)},
+	}
+	summary, err := Load(data)
+	assert.NoError(t, err)
+	assert.Equal(t, models.LoadUpsert, summary.Mode)
+	assert.Equal(t, 2, summary.Inserted)
+
+	summary, err = ReplaceRange("run2", "2025-09-01", data[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Deleted)
+	page, err := GetResultsByChannel("2025-09-01", "2025-09-01", "google_ads", 10, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+	if assert.Len(t, page.Results, 1) {
+		assert.Equal(t, "run2", page.Results[0].RunID)
+	}
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestGetResultsByChannel_Pages(t *testing.T) {
+	useMemoryStore(t)
+
+	_, err := Load([]models.ETLResult{
+		{Date: "2025-09-02", Channel: "google_ads", CampaignID: "C1"},
+		{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C2"},
+		{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C1"},
+		{Date: "2025-09-01", Channel: "facebook_ads", CampaignID: "C1"},
+	})
+	assert.NoError(t, err)
+
+	page, err := GetResultsByChannel("2025-09-01", "2025-09-30", "google_ads", 2, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	if assert.Len(t, page.Results, 2) {
+		assert.Equal(t, "C1", page.Results[0].CampaignID)
+		assert.Equal(t, "C2", page.Results[1].CampaignID)
+	}
+	assert.NotEmpty(t, page.NextCursor)
+
+	page, err = GetResultsByChannel("2025-09-01", "2025-09-30", "google_ads", 2, 0, page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	if assert.Len(t, page.Results, 1) {
+		assert.Equal(t, "2025-09-02", page.Results[0].Date)
+	}
+	assert.Empty(t, page.NextCursor)
+
+	_, err = GetResultsByChannel("2025-09-01", "2025-09-30", "google_ads", 2, 0, "not-a-cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -0,0 +1,253 @@
+package etl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"goetl/internal/db"
+	"goetl/internal/models"
+	"goetl/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const indexTimeout = 10 * time.Minute
+
+// indexSpec is an index goetl requires
+type indexSpec struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+}
+
+// requiredIndexes back the upsert keys, the filters and sort order of the store queries and the retention purge. The
+// timestamp indexes of the purged collections become TTL indexes with RETENTION_MODE=ttl.
+var requiredIndexes = []indexSpec{
+	{etlCollection, "date_channel_campaignid", bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}}, true},
+	{etlCollection, "channel_date_campaignid", bson.D{{Key: "channel", Value: 1}, {Key: "date", Value: 1}, {Key: "campaignid", Value: 1}}, false},
+	{etlCollection, "utmcampaign_date_channel_campaignid", bson.D{{Key: "utmcampaign", Value: 1}, {Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}}, false},
+	{etlCollection, "campaignid_date", bson.D{{Key: "campaignid", Value: 1}, {Key: "date", Value: 1}}, false},
+	{resultVersionCollection, "date_channel_campaignid_writtenat", bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}, {Key: "writtenat", Value: 1}}, false},
+	{resultVersionCollection, "channel_date", bson.D{{Key: "channel", Value: 1}, {Key: "date", Value: 1}}, false},
+	{resultVersionCollection, "utmcampaign_date", bson.D{{Key: "utmcampaign", Value: 1}, {Key: "date", Value: 1}}, false},
+	{resultVersionCollection, "runid_writtenat", bson.D{{Key: "runid", Value: 1}, {Key: "writtenat", Value: 1}}, false},
+	{rollbackCollection, "runid", bson.D{{Key: "runid", Value: 1}}, false},
+	{rollbackCollection, "restoredat", bson.D{{Key: "restoredat", Value: 1}}, false},
+	{qualityCollection, "runid", bson.D{{Key: "runid", Value: 1}}, true},
+	{qualityCollection, "startedat", bson.D{{Key: "startedat", Value: 1}}, false},
+	{violationCollection, "runid", bson.D{{Key: "runid", Value: 1}}, false},
+	{violationCollection, "createdat", bson.D{{Key: "createdat", Value: 1}}, false},
+	{deadLetterCollection, "runid", bson.D{{Key: "runid", Value: 1}}, false},
+	{deadLetterCollection, "contacthash", bson.D{{Key: "contacthash", Value: 1}}, false},
+	{deadLetterCollection, "createdat", bson.D{{Key: "createdat", Value: 1}}, false},
+	{rawPayloadCollection, "runid_source", bson.D{{Key: "runid", Value: 1}, {Key: "source", Value: 1}}, false},
+	{rawPayloadCollection, "contacthashes", bson.D{{Key: "contacthashes", Value: 1}}, false},
+	{rawPayloadCollection, "createdat", bson.D{{Key: "createdat", Value: 1}}, false},
+	{restatementCollection, "runid", bson.D{{Key: "runid", Value: 1}}, false},
+	{restatementCollection, "createdat", bson.D{{Key: "createdat", Value: 1}}, false},
+	{anomalyCollection, "date_channel_campaignid_metric", bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}, {Key: "metric", Value: 1}}, true},
+	{budgetCollection, "id", bson.D{{Key: "id", Value: 1}}, true},
+	{budgetCollection, "campaignid_startdate", bson.D{{Key: "campaignid", Value: 1}, {Key: "startdate", Value: 1}}, false},
+	{erasureCollection, "id", bson.D{{Key: "id", Value: 1}}, true},
+	{lineageCollection, "date_channel_campaignid", bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}}, true},
+	{lineageCollection, "campaignid_date", bson.D{{Key: "campaignid", Value: 1}, {Key: "date", Value: 1}}, false},
+	{stagingAdCollection, "date_channel_campaignid", bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}}, true},
+	{stagingOpportunityCollection, "opportunityid_createdat", bson.D{{Key: "opportunityid", Value: 1}, {Key: "createdat", Value: 1}}, true},
+	{stagingOpportunityCollection, "createdat", bson.D{{Key: "createdat", Value: 1}}, false},
+	{stagingOpportunityCollection, "contactemailhash", bson.D{{Key: "contactemailhash", Value: 1}}, false},
+}
+
+
+// ensureIndexesOnStartup reports whether the server reconciles indexes when it starts, disabled with ENSURE_INDEXES=false
+func ensureIndexesOnStartup() bool {
+	return utils.Getenv("ENSURE_INDEXES") != "false"
+}
+
+
+// EnsureIndexes creates the missing required indexes, unless disabled with ENSURE_INDEXES=false, and logs
+// the status of every index that was not already in place
+func EnsureIndexes() {
+	if !ensureIndexesOnStartup() {
+		return
+	}
+	statuses, err := ReconcileIndexes(false)
+	if err != nil {
+		log.Printf("Index reconciliation failed: %v", err)
+		return
+	}
+	ready := 0
+	for _, s := range statuses {
+		switch s.Status {
+		case models.IndexPresent:
+			ready++
+		case models.IndexCreated, models.IndexRebuilt:
+			ready++
+			log.Printf("Index %s.%s %s", s.Collection, s.Name, s.Status)
+		default:
+			log.Printf("Index %s.%s %s %s", s.Collection, s.Name, s.Status, s.Error)
+		}
+	}
+	log.Printf("Indexes ready: %d of %d", ready, len(requiredIndexes))
+}
+
+
+// ListIndexes compares the indexes of every goetl collection with the required ones. Required indexes are
+// present, missing or different (same name, other keys or options); any other index is extra.
+func ListIndexes() ([]models.IndexStatus, error) {
+	return reconcileIndexes(false, false)
+}
+
+
+// ReconcileIndexes creates missing indexes, rebuilds different ones and, with dropExtra, drops the indexes
+// goetl does not require. Failures are reported per index.
+func ReconcileIndexes(dropExtra bool) ([]models.IndexStatus, error) {
+	return reconcileIndexes(true, dropExtra)
+}
+
+
+// reconcileIndexes compares the indexes of every goetl collection with the required ones, fixing them when apply is set
+func reconcileIndexes(apply, dropExtra bool) ([]models.IndexStatus, error) {
+	database, err := db.GetDatabase()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+	var statuses []models.IndexStatus
+	for _, name := range indexedCollections() {
+		collection := database.Collection(name)
+		specs, err := collection.Indexes().ListSpecifications(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list indexes of %s: %w", name, err)
+		}
+		existing := map[string]*mongo.IndexSpecification{}
+		for _, spec := range specs {
+			existing[spec.Name] = spec
+		}
+		for _, req := range requiredIndexes {
+			if req.Collection != name {
+				continue
+			}
+			status := models.IndexStatus{Collection: name, Name: req.Name, Keys: keysString(req.Keys), Unique: req.Unique, ExpireAfterSeconds: indexTTL(req), Status: models.IndexPresent}
+			spec, ok := existing[req.Name]
+			delete(existing, req.Name)
+			if !ok {
+				status.Status = models.IndexMissing
+			} else if keysString(spec.KeysDocument) != status.Keys || isUnique(spec) != req.Unique || expireAfter(spec) != status.ExpireAfterSeconds {
+				status.Status = models.IndexDifferent
+			}
+			if apply && status.Status != models.IndexPresent {
+				status.Status, status.Error = applyIndex(ctx, collection, req, status.Status == models.IndexDifferent)
+			}
+			statuses = append(statuses, status)
+		}
+		for _, spec := range specs {
+			if _, extra := existing[spec.Name]; !extra || spec.Name == "_id_" {
+				continue
+			}
+			status := models.IndexStatus{Collection: name, Name: spec.Name, Keys: keysString(spec.KeysDocument), Unique: isUnique(spec), ExpireAfterSeconds: expireAfter(spec), Status: models.IndexExtra}
+			if apply && dropExtra {
+				status.Status = models.IndexDropped
+				if _, err := collection.Indexes().DropOne(ctx, spec.Name); err != nil {
+					status.Status, status.Error = models.IndexFailed, err.Error()
+				}
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+
+// applyIndex creates req, dropping the index with the same name first when rebuild is set
+func applyIndex(ctx context.Context, collection *mongo.Collection, req indexSpec, rebuild bool) (string, string) {
+	if rebuild {
+		if _, err := collection.Indexes().DropOne(ctx, req.Name); err != nil {
+			return models.IndexFailed, err.Error()
+		}
+	}
+	opts := options.Index().SetName(req.Name).SetUnique(req.Unique)
+	if ttl := indexTTL(req); ttl > 0 {
+		opts.SetExpireAfterSeconds(ttl)
+	}
+	model := mongo.IndexModel{Keys: req.Keys, Options: opts}
+	if _, err := collection.Indexes().CreateOne(ctx, model); err != nil {
+		return models.IndexFailed, err.Error()
+	}
+	if rebuild {
+		return models.IndexRebuilt, ""
+	}
+	return models.IndexCreated, ""
+}
+
+
+// indexTTL returns the expireAfterSeconds of req: the retention of its collection for the timestamp index a
+// purge filters on when RETENTION_MODE=ttl, 0 otherwise
+func indexTTL(req indexSpec) int32 {
+	cfg, err := loadRetentionConfig()
+	if err != nil {
+		return 0
+	}
+	for _, target := range retentionTargets {
+		if target.Collection == req.Collection && !target.Date && keysString(req.Keys) == target.Field+":1" {
+			return cfg.TTLSeconds(target.Group)
+		}
+	}
+	return 0
+}
+
+
+// expireAfter returns the expireAfterSeconds of an existing index, 0 when it is not a TTL index
+func expireAfter(spec *mongo.IndexSpecification) int32 {
+	if spec.ExpireAfterSeconds == nil {
+		return 0
+	}
+	return *spec.ExpireAfterSeconds
+}
+
+
+// indexedCollections returns the collections with required indexes, in declaration order
+func indexedCollections() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, req := range requiredIndexes {
+		if !seen[req.Collection] {
+			seen[req.Collection] = true
+			names = append(names, req.Collection)
+		}
+	}
+	return names
+}
+
+
+// keysString formats an index key document as "field:1,field:-1", whatever the numeric type of the values
+func keysString(keys interface{}) string {
+	raw, err := bson.Marshal(keys)
+	if err != nil {
+		return ""
+	}
+	elements, err := bson.Raw(raw).Elements()
+	if err != nil {
+		return ""
+	}
+	parts := make([]string, 0, len(elements))
+	for _, e := range elements {
+		value := e.Value()
+		if n, ok := value.AsInt64OK(); ok {
+			parts = append(parts, fmt.Sprintf("%s:%d", e.Key(), n))
+		} else {
+			parts = append(parts, e.Key()+":"+strings.Trim(value.String(), `"`))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+
+// isUnique reports whether spec is a unique index
+func isUnique(spec *mongo.IndexSpecification) bool {
+	return spec.Unique != nil && *spec.Unique
+}
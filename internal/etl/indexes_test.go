@@ -0,0 +1,41 @@
+package etl
+
+import (
+	"strings"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestKeysString(t *testing.T) {
+	keys := bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: -1}}
+	assert.Equal(t, "date:1,channel:-1", keysString(keys))
+
+	// the server may return the key values with another numeric type
+	raw, err := bson.Marshal(bson.D{{Key: "date", Value: int64(1)}, {Key: "channel", Value: -1.0}})
+	assert.NoError(t, err)
+	assert.Equal(t, "date:1,channel:-1", keysString(bson.Raw(raw)))
+}
+
+func TestRequiredIndexes_Unique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, req := range requiredIndexes {
+		key := req.Collection + "." + req.Name
+		assert.False(t, seen[key], "duplicate index %s", key)
+		seen[key] = true
+	}
+	assert.Equal(t, etlCollection, indexedCollections()[0])
+}
+
+func TestRequiredIndexes_Retention(t *testing.T) {
+	// every collection expired by a timestamp has the index its purge filters on, which TTL mode expires
+	for _, target := range retentionTargets {
+		found := false
+		for _, req := range requiredIndexes {
+			if req.Collection == target.Collection && (keysString(req.Keys) == target.Field+":1" || target.Date && strings.HasPrefix(keysString(req.Keys), target.Field+":1,")) {
+				found = true
+			}
+		}
+		assert.True(t, found, "no index on %s.%s", target.Collection, target.Field)
+	}
+}
@@ -0,0 +1,260 @@
+package etl
+
+import (
+	"context"
+	"sort"
+	"time"
+	"goetl/internal/lake"
+	"goetl/internal/models"
+	"goetl/internal/store"
+)
+
+
+// ExportLake writes the lake partitions touched by a run as Parquet files under LAKE_DIR and commits the
+// manifest of the run, returning its path, or "" when the export is disabled. Result partitions are rewritten
+// from the result store, so they hold every stored row of their date and channel and not only the run's; in
+// replace mode the partitions of the replaced range left without rows lose their file. With LAKE_STAGING=true
+// the staged ads and opportunities of the run's dates are exported too.
+func ExportLake(report *models.QualityReport, batch *Batch, failures []models.LoadFailure) (string, error) {
+	cfg, err := loadLakeConfig()
+	if err != nil || !cfg.Enabled() {
+		return "", err
+	}
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	manifest := lake.Manifest{RunID: report.RunID, CreatedAt: time.Now().UTC()}
+	files, err := exportResults(ctx, resultStore, cfg.Dir, report.EffectiveSince, replaceRanges(), batch.Results, failures)
+	if err != nil {
+		return "", err
+	}
+	manifest.Files = files
+	if cfg.Staging && report.Extraction == models.ExtractLive && stageRecords() {
+		files, err := exportStaging(cfg.Dir, batch.NormalizedAds, batch.NormalizedOpportunities)
+		if err != nil {
+			return "", err
+		}
+		manifest.Files = append(manifest.Files, files...)
+	}
+	return lake.WriteManifest(cfg.Dir, manifest)
+}
+
+
+// exportResults rewrites the result partitions of the written results and, when the load replaced its range,
+// the existing partitions of that range: the dates of store.ReplaceBounds of the channels present in results
+func exportResults(ctx context.Context, resultStore store.ResultStore, dir, since string, replace bool, results []models.ETLResult, failures []models.LoadFailure) ([]lake.File, error) {
+	failed := make(map[string]bool, len(failures))
+	for _, f := range failures {
+		failed[store.Key(models.ETLResult{Date: f.Date, Channel: f.Channel, CampaignID: f.CampaignID})] = true
+	}
+	touched := map[lake.Partition]bool{}
+	channels := map[string]bool{}
+	for _, res := range results {
+		channels[res.Channel] = true
+		if !failed[store.Key(res)] {
+			touched[lake.Partition{Date: res.Date, Channel: res.Channel}] = true
+		}
+	}
+	if replace {
+		existing, err := lake.Partitions(dir, lake.Results)
+		if err != nil {
+			return nil, err
+		}
+		from, to := store.ReplaceBounds(since, results)
+		for _, p := range existing {
+			if channels[p.Channel] && p.Date >= from && p.Date <= to {
+				touched[p] = true
+			}
+		}
+	}
+	return writeResultPartitions(ctx, resultStore, dir, sortedPartitions(touched))
+}
+
+
+// writeResultPartitions rewrites the result partitions, ordered by date, from the result store
+func writeResultPartitions(ctx context.Context, resultStore store.ResultStore, dir string, partitions []lake.Partition) ([]lake.File, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	stored, err := resultStore.Query(ctx, store.Query{From: partitions[0].Date, To: partitions[len(partitions)-1].Date})
+	if err != nil {
+		return nil, err
+	}
+	rows := map[lake.Partition][]models.ETLResult{}
+	for _, res := range stored {
+		p := lake.Partition{Date: res.Date, Channel: res.Channel}
+		rows[p] = append(rows[p], res)
+	}
+	var files []lake.File
+	for _, p := range partitions {
+		file, err := lake.WriteResults(dir, p, rows[p])
+		if err != nil {
+			return files, err
+		}
+		if file.Rows > 0 || file.Removed {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+
+// exportStaging rewrites the staged ads partitions of the dates and channels of ads and the staged
+// opportunities partitions of the creation dates of opportunities from the staging collections
+func exportStaging(dir string, ads []models.AdPerformance, opportunities []models.Opportunity) ([]lake.File, error) {
+	adPartitions := map[lake.Partition]bool{}
+	for _, ad := range ads {
+		adPartitions[lake.Partition{Date: ad.Date, Channel: ad.Channel}] = true
+	}
+	oppPartitions := map[lake.Partition]bool{}
+	for _, opp := range opportunities {
+		oppPartitions[lake.Partition{Date: opp.CreatedAt}] = true
+	}
+	since := ""
+	for _, partitions := range []map[lake.Partition]bool{adPartitions, oppPartitions} {
+		for p := range partitions {
+			if since == "" || p.Date < since {
+				since = p.Date
+			}
+		}
+	}
+	if since == "" {
+		return nil, nil
+	}
+	stagedAds, stagedOpportunities, err := GetStaging(since)
+	if err != nil {
+		return nil, err
+	}
+	adRows := map[lake.Partition][]models.AdPerformance{}
+	for _, ad := range stagedAds {
+		p := lake.Partition{Date: ad.Date, Channel: ad.Channel}
+		adRows[p] = append(adRows[p], ad)
+	}
+	var files []lake.File
+	for _, p := range sortedPartitions(adPartitions) {
+		file, err := lake.WriteAds(dir, p, adRows[p])
+		if err != nil {
+			return files, err
+		}
+		if file.Rows > 0 || file.Removed {
+			files = append(files, file)
+		}
+	}
+	oppFiles, err := writeOpportunityPartitions(dir, sortedPartitions(oppPartitions), stagedOpportunities)
+	return append(files, oppFiles...), err
+}
+
+
+// writeOpportunityPartitions rewrites the staged opportunities partitions with the staged opportunities of
+// their dates
+func writeOpportunityPartitions(dir string, partitions []lake.Partition, staged []models.Opportunity) ([]lake.File, error) {
+	rows := map[lake.Partition][]models.Opportunity{}
+	for _, opp := range staged {
+		p := lake.Partition{Date: opp.CreatedAt}
+		rows[p] = append(rows[p], opp)
+	}
+	var files []lake.File
+	for _, p := range partitions {
+		file, err := lake.WriteOpportunities(dir, p, rows[p])
+		if err != nil {
+			return files, err
+		}
+		if file.Rows > 0 || file.Removed {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+
+// eraseLake rewrites the lake partitions an erasure changed: the result partitions it retracted rows of and
+// the staged opportunities partitions of the erased opportunities, from the result store and staging, which
+// no longer hold the contact. Only partitions already in the lake are rewritten. A manifest named after the
+// erasure is committed; its path and the number of files are returned, or "" when the export is disabled
+// or nothing changed.
+func eraseLake(erasureID string, results, opportunities map[lake.Partition]bool) (string, int, error) {
+	cfg, err := loadLakeConfig()
+	if err != nil || !cfg.Enabled() {
+		return "", 0, err
+	}
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return "", 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	manifest := lake.Manifest{RunID: erasureID, CreatedAt: time.Now().UTC()}
+	files, err := rewriteResultPartitions(ctx, resultStore, cfg.Dir, results)
+	if err != nil {
+		return "", 0, err
+	}
+	manifest.Files = files
+	partitions, err := existingPartitions(cfg.Dir, lake.StagedOpportunities, opportunities)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(partitions) > 0 {
+		_, staged, err := GetStaging(partitions[0].Date)
+		if err != nil {
+			return "", 0, err
+		}
+		files, err := writeOpportunityPartitions(cfg.Dir, partitions, staged)
+		if err != nil {
+			return "", 0, err
+		}
+		manifest.Files = append(manifest.Files, files...)
+	}
+	if len(manifest.Files) == 0 {
+		return "", 0, nil
+	}
+	path, err := lake.WriteManifest(cfg.Dir, manifest)
+	return path, len(manifest.Files), err
+}
+
+
+// rewriteResultPartitions rewrites the result partitions of set already in the lake from the result store
+func rewriteResultPartitions(ctx context.Context, resultStore store.ResultStore, dir string, set map[lake.Partition]bool) ([]lake.File, error) {
+	partitions, err := existingPartitions(dir, lake.Results, set)
+	if err != nil {
+		return nil, err
+	}
+	return writeResultPartitions(ctx, resultStore, dir, partitions)
+}
+
+
+// existingPartitions returns the partitions of set that dataset has in the lake, ordered by date then channel
+func existingPartitions(dir, dataset string, set map[lake.Partition]bool) ([]lake.Partition, error) {
+	if len(set) == 0 {
+		return nil, nil
+	}
+	existing, err := lake.Partitions(dir, dataset)
+	if err != nil {
+		return nil, err
+	}
+	found := map[lake.Partition]bool{}
+	for _, p := range existing {
+		if set[p] {
+			found[p] = true
+		}
+	}
+	return sortedPartitions(found), nil
+}
+
+
+// sortedPartitions returns the partitions of set ordered by date then channel
+func sortedPartitions(set map[lake.Partition]bool) []lake.Partition {
+	partitions := make([]lake.Partition, 0, len(set))
+	for p := range set {
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Date != partitions[j].Date {
+			return partitions[i].Date < partitions[j].Date
+		}
+		return partitions[i].Channel < partitions[j].Channel
+	})
+	return partitions
+}
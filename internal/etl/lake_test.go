@@ -0,0 +1,85 @@
+package etl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/lake"
+	"goetl/internal/models"
+	"goetl/internal/store"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestExportResults(t *testing.T) {
+	ctx := context.Background()
+	memory := store.NewMemory()
+	dir := t.TempDir()
+	res := func(date, channel, campaignID string) models.ETLResult {
+		return models.ETLResult{Date: date, Channel: channel, CampaignID: campaignID}
+	}
+	first := []models.ETLResult{
+		res("2025-09-01", "google_ads", "C1"), res("2025-09-02", "google_ads", "C1"), res("2025-09-03", "google_ads", "C1"),
+		res("2025-09-04", "google_ads", "C1"), res("2025-09-02", "meta_ads", "C2"),
+	}
+	memory.Replace(ctx, "run1", "2025-09-01", first)
+	files, err := exportResults(ctx, memory, dir, "2025-09-01", true, first, nil)
+	assert.NoError(t, err)
+	assert.Len(t, files, 5)
+
+	// the second run has google_ads rows for 2025-09-01 and 2025-09-03: 2025-09-02 is emptied, while
+	// 2025-09-04, past its latest date, and meta_ads are untouched
+	second := []models.ETLResult{res("2025-09-01", "google_ads", "C1"), res("2025-09-01", "google_ads", "C3"), res("2025-09-03", "google_ads", "C1")}
+	memory.Replace(ctx, "run2", "2025-09-01", second)
+	files, err = exportResults(ctx, memory, dir, "2025-09-01", true, second, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 3) {
+		assert.Equal(t, 2, files[0].Rows)
+		assert.Equal(t, "2025-09-02", files[1].Date)
+		assert.True(t, files[1].Removed)
+		assert.Equal(t, "2025-09-03", files[2].Date)
+	}
+	partitions, err := lake.Partitions(dir, lake.Results)
+	assert.NoError(t, err)
+	assert.Equal(t, []lake.Partition{
+		{Date: "2025-09-01", Channel: "google_ads"}, {Date: "2025-09-02", Channel: "meta_ads"},
+		{Date: "2025-09-03", Channel: "google_ads"}, {Date: "2025-09-04", Channel: "google_ads"},
+	}, partitions)
+
+	// a failed row does not touch its partition
+	failures := []models.LoadFailure{{Date: "2025-09-05", Channel: "google_ads", CampaignID: "C1"}}
+	files, err = exportResults(ctx, memory, dir, "2025-09-05", false, []models.ETLResult{res("2025-09-05", "google_ads", "C1")}, failures)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+	_, err = os.Stat(filepath.Join(dir, lake.Results, "date=2025-09-05"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRewriteResultPartitions(t *testing.T) {
+	ctx := context.Background()
+	memory := store.NewMemory()
+	dir := t.TempDir()
+	results := []models.ETLResult{{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C1", Opportunities: 1}}
+	memory.Replace(ctx, "run1", "2025-09-01", results)
+	_, err := exportResults(ctx, memory, dir, "2025-09-01", false, results, nil)
+	assert.NoError(t, err)
+
+	// an erasure retracted the opportunity of the row; partitions not in the lake are not created
+	memory.Save(ctx, []models.ETLResult{{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C1", RunID: "erasure-1"}})
+	set := map[lake.Partition]bool{{Date: "2025-09-01", Channel: "google_ads"}: true, {Date: "2025-09-02", Channel: "google_ads"}: true}
+	files, err := rewriteResultPartitions(ctx, memory, dir, set)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, "2025-09-01", files[0].Date)
+	}
+	rows, err := parquet.ReadFile[lake.ResultRow](filepath.Join(dir, files[0].Path))
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, "erasure-1", rows[0].RunID)
+		assert.Zero(t, rows[0].Opportunities)
+	}
+	partitions, err := lake.Partitions(dir, lake.Results)
+	assert.NoError(t, err)
+	assert.Len(t, partitions, 1)
+}
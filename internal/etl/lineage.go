@@ -0,0 +1,184 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"goetl/internal/db"
+	"goetl/internal/models"
+	"goetl/internal/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const lineageCollection = "result_lineage"
+
+// ErrNoResults is returned when no stored result matches a drill-down
+var ErrNoResults = errors.New("no results")
+
+
+// SaveLineage replaces the lineage of the result rows written by a run, one document per row keyed by
+// (date, channel, campaignid)
+func SaveLineage(runID string, lineage []models.Lineage) error {
+	if len(lineage) == 0 {
+		return nil
+	}
+	database, err := db.GetDatabase()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	writes := make([]mongo.WriteModel, 0, len(lineage))
+	for _, l := range lineage {
+		l.RunID = runID
+		filter := bson.M{"date": l.Date, "channel": l.Channel, "campaignid": l.CampaignID}
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(l).SetUpsert(true))
+	}
+	return bulkUpsert(ctx, database.Collection(lineageCollection), writes)
+}
+
+
+// writtenLineage keeps the lineage of the results that were written, dropping the rows later stages
+// removed and the failed ones
+func writtenLineage(lineage []models.Lineage, results []models.ETLResult, failures []models.LoadFailure) []models.Lineage {
+	written := make(map[string]bool, len(results))
+	for _, res := range results {
+		written[store.Key(res)] = true
+	}
+	for _, f := range failures {
+		delete(written, store.Key(models.ETLResult{Date: f.Date, Channel: f.Channel, CampaignID: f.CampaignID}))
+	}
+	kept := make([]models.Lineage, 0, len(lineage))
+	for _, l := range lineage {
+		if written[store.Key(models.ETLResult{Date: l.Date, Channel: l.Channel, CampaignID: l.CampaignID})] {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+
+// GetResultOpportunities returns the opportunities attributed to the stored results of a campaign on a date,
+// ordered by channel and opportunity id, with their details from staging and the contact data masked unless
+// canReadContacts. ErrNoResults is returned when the campaign has no results that day.
+func GetResultOpportunities(campaignID, date string, canReadContacts bool) ([]models.AttributedOpportunity, error) {
+	policy, err := loadPolicy()
+	if err != nil {
+		return nil, err
+	}
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	results, err := resultStore.Query(ctx, store.Query{CampaignID: campaignID, Date: date})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNoResults
+	}
+	// lineage of rows since deleted by a range replacement is ignored
+	channels := make([]string, len(results))
+	for i, res := range results {
+		channels[i] = res.Channel
+	}
+
+	database, err := db.GetDatabase()
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := database.Collection(lineageCollection).Find(ctx, bson.M{"campaignid": campaignID, "date": date, "channel": bson.M{"$in": channels}})
+	if err != nil {
+		return nil, err
+	}
+	var lineage []models.Lineage
+	if err := cursor.All(ctx, &lineage); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, l := range lineage {
+		for _, a := range l.Opportunities {
+			ids = append(ids, a.OpportunityID)
+		}
+	}
+	staged := map[string]models.Opportunity{}
+	if len(ids) > 0 {
+		cursor, err := database.Collection(stagingOpportunityCollection).Find(ctx, bson.M{"createdat": date, "opportunityid": bson.M{"$in": ids}})
+		if err != nil {
+			return nil, err
+		}
+		var opportunities []models.StagedOpportunity
+		if err := cursor.All(ctx, &opportunities); err != nil {
+			return nil, err
+		}
+		for _, s := range opportunities {
+			staged[s.OpportunityID] = s.Opportunity
+		}
+	}
+
+	out := []models.AttributedOpportunity{}
+	for _, l := range lineage {
+		for _, a := range l.Opportunities {
+			opp, ok := staged[a.OpportunityID]
+			if !ok {
+				// not staged, only the id is known
+				opp = models.Opportunity{OpportunityID: a.OpportunityID, CreatedAt: a.CreatedAt}
+			}
+			out = append(out, models.AttributedOpportunity{Opportunity: policy.Mask(opp, canReadContacts), Channel: l.Channel, Weight: a.Weight})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Channel != out[j].Channel {
+			return out[i].Channel < out[j].Channel
+		}
+		return out[i].OpportunityID < out[j].OpportunityID
+	})
+	return out, nil
+}
+
+
+// CanReadContacts reports whether the caller presenting token may see contact data, see PII_READER_TOKENS
+func CanReadContacts(token string) (bool, error) {
+	policy, err := loadPolicy()
+	if err != nil {
+		return false, err
+	}
+	return policy.CanReadContacts(token), nil
+}
+
+
+// attributedLineage returns the lineage of the result rows that counted opp
+func attributedLineage(opp models.Opportunity) ([]models.Lineage, error) {
+	collection, ctx, cancel := db.GetCollection(lineageCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil, ErrDatabaseUnavailable
+	}
+	defer cancel()
+	attribution := bson.M{"opportunityid": opp.OpportunityID, "createdat": opp.CreatedAt}
+	cursor, err := collection.Find(ctx, bson.M{"date": opp.CreatedAt, "opportunities": bson.M{"$elemMatch": attribution}})
+	if err != nil {
+		return nil, err
+	}
+	var lineage []models.Lineage
+	if err := cursor.All(ctx, &lineage); err != nil {
+		return nil, err
+	}
+	return lineage, nil
+}
+
+
+// eraseLineage removes an erased opportunity from the lineage of the results it was attributed to
+func eraseLineage(opp models.Opportunity) error {
+	collection, ctx, cancel := db.GetCollection(lineageCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return ErrDatabaseUnavailable
+	}
+	defer cancel()
+	attribution := bson.M{"opportunityid": opp.OpportunityID, "createdat": opp.CreatedAt}
+	filter := bson.M{"date": opp.CreatedAt, "opportunities": bson.M{"$elemMatch": attribution}}
+	_, err := collection.UpdateMany(ctx, filter, bson.M{"$pull": bson.M{"opportunities": attribution}})
+	return err
+}
@@ -0,0 +1,170 @@
+package etl
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"goetl/internal/models"
+	"goetl/internal/utils"
+)
+
+// DefaultStages is the order in which the built-in stages run when TRANSFORM_STAGES is not set
+var DefaultStages = []string{"normalize", "filter", "dedup", "join", "enrich", "compute"}
+
+// TransformRevision is bumped whenever the built-in stages change what they compute
+const TransformRevision = 1
+
+// Stage is a named step of the transform pipeline. Stages read and replace the records in a Batch.
+type Stage interface {
+	Name() string
+	Run(b *Batch) error
+}
+
+// JoinedAd is an ad performance record with the opportunities attributed to it
+type JoinedAd struct {
+	Ad            models.AdPerformance
+	Opportunities []models.Opportunity
+}
+
+// Batch carries the records of one Transform call between stages
+type Batch struct {
+	Since         string
+	Ads           []models.AdPerformance
+	Opportunities []models.Opportunity
+	// NormalizedAds and NormalizedOpportunities are the records as the normalize stage left them, before
+	// filtering and deduplication. Live runs upsert them into the staging collections.
+	NormalizedAds           []models.AdPerformance
+	NormalizedOpportunities []models.Opportunity
+	Joined                  []JoinedAd
+	Results                 []models.ETLResult
+	// Lineage lists the opportunities of each result, in the order of Results
+	Lineage []models.Lineage
+	// Report may be nil
+	Report *models.QualityReport
+}
+
+// stageFunc adapts a function to the Stage interface
+type stageFunc struct {
+	name string
+	run  func(b *Batch) error
+}
+
+func (s stageFunc) Name() string { return s.name }
+
+func (s stageFunc) Run(b *Batch) error { return s.run(b) }
+
+// NewStage returns a Stage running fn under name
+func NewStage(name string, fn func(b *Batch) error) Stage {
+	return stageFunc{name: name, run: fn}
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Stage{}
+)
+
+func init() {
+	for _, s := range builtinStages() {
+		registry[s.Name()] = s
+	}
+}
+
+// RegisterStage makes a custom stage available to pipelines by name, e.g. in TRANSFORM_STAGES
+func RegisterStage(s Stage) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[s.Name()]; ok {
+		return fmt.Errorf("stage %q already registered", s.Name())
+	}
+	registry[s.Name()] = s
+	return nil
+}
+
+// Pipeline runs stages in order
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline builds a pipeline from registered stage names
+func NewPipeline(names []string) (*Pipeline, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	p := &Pipeline{stages: make([]Stage, 0, len(names))}
+	for _, name := range names {
+		s, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform stage %q", name)
+		}
+		p.stages = append(p.stages, s)
+	}
+	return p, nil
+}
+
+// loadPipeline builds the pipeline named by TRANSFORM_STAGES (comma separated) or DefaultStages
+func loadPipeline() (*Pipeline, error) {
+	names := DefaultStages
+	if env := utils.Getenv("TRANSFORM_STAGES"); env != "" {
+		names = nil
+		for _, name := range strings.Split(env, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return NewPipeline(names)
+}
+
+// transformVersion returns TRANSFORM_VERSION or, when unset, the transform revision followed by a
+// fingerprint of the stage order and metric definitions, so results computed differently are told apart
+func transformVersion() (string, error) {
+	if v := utils.Getenv("TRANSFORM_VERSION"); v != "" {
+		return v, nil
+	}
+	pipeline, err := loadPipeline()
+	if err != nil {
+		return "", err
+	}
+	metricSet, err := loadMetricSet()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintln(h, strings.Join(pipeline.Stages(), ","))
+	for _, m := range metricSet.Metrics() {
+		fmt.Fprintf(h, "%s=%s;%d;%s\n", m.Name, m.Expr, m.Precision, m.Rounding)
+	}
+	return fmt.Sprintf("v%d-%x", TransformRevision, h.Sum(nil)[:6]), nil
+}
+
+// Stages returns the stage names in run order
+func (p *Pipeline) Stages() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+// Run executes every stage on b, recording timing and record counts in b.Report
+func (p *Pipeline) Run(b *Batch) error {
+	for _, s := range p.stages {
+		start := time.Now()
+		err := s.Run(b)
+		if b.Report != nil {
+			b.Report.Stages = append(b.Report.Stages, models.StageStats{
+				Name:          s.Name(),
+				DurationMs:    float64(time.Since(start).Microseconds()) / 1000,
+				Ads:           len(b.Ads),
+				Opportunities: len(b.Opportunities),
+				Joined:        len(b.Joined),
+				Results:       len(b.Results),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", s.Name(), err)
+		}
+	}
+	return nil
+}
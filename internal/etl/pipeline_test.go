@@ -0,0 +1,116 @@
+package etl
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+)
+
+// unregisterStage removes a custom stage registered by a test
+func unregisterStage(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, name)
+}
+
+func sampleInput() ([]models.AdPerformance, []models.Opportunity) {
+	ads := []models.AdPerformance{
+		{Date: "2025-08-01", Channel: "google_ads", CampaignID: "C1", Clicks: 10, Impressions: 100, Cost: 50, UTMCampaign: "bts", UTMSource: "google", UTMMedium: "cpc"},
+		{Date: "2025-08-01", Channel: "google_ads", CampaignID: "C1", Clicks: 20, Impressions: 200, Cost: 100, UTMCampaign: "bts", UTMSource: "google", UTMMedium: "cpc"},
+		{Date: "2025-07-01", Channel: "google_ads", CampaignID: "C1", Clicks: 5, Impressions: 50, Cost: 10, UTMCampaign: "bts", UTMSource: "google", UTMMedium: "cpc"},
+		{Date: "not-a-date", Channel: "google_ads", CampaignID: "C2"},
+	}
+	opportunities := []models.Opportunity{
+		{OpportunityID: "O1", ContactEmail: "ana@example.com", Stage: "closed_won", Amount: 400, CreatedAt: "2025-08-01T10:00:00Z", UTMCampaign: "bts", UTMSource: "google", UTMMedium: "cpc"},
+		{OpportunityID: "O2", Stage: "closed_won", Amount: 300, CreatedAt: "2025-08-01T11:00:00Z", UTMCampaign: "other", UTMSource: "google", UTMMedium: "cpc"},
+		{OpportunityID: "O3", Stage: "lead", CreatedAt: "2025-08-01", UTMCampaign: "", UTMSource: "google", UTMMedium: "cpc"},
+	}
+	return ads, opportunities
+}
+
+func TestTransform_DefaultPipeline(t *testing.T) {
+	ads, opportunities := sampleInput()
+	report := models.NewQualityReport("run", "2025-08-01")
+	results, err := Transform(ads, opportunities, "2025-08-01", report)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	res := results[0]
+	assert.Equal(t, 20, res.Clicks)
+	assert.Equal(t, 1, res.ClosedWon)
+	assert.Equal(t, "400", res.Revenue.String())
+	assert.Equal(t, "4", res.ROAS.String())
+
+	assert.Equal(t, 1, report.Dropped[models.SourceAds][models.DropInvalidDate])
+	assert.Equal(t, 1, report.Dropped[models.SourceAds][models.DropBeforeSince])
+	assert.Equal(t, 1, report.Dropped[models.SourceOpportunities][models.DropMissingKeys])
+	assert.Equal(t, 1, report.Duplicates[models.SourceAds])
+	assert.Equal(t, 0.5, report.Join.MatchRate)
+	assert.Equal(t, "300", report.UnattributedRevenue.String())
+	assert.Len(t, report.Stages, len(DefaultStages))
+	assert.Equal(t, "compute", report.Stages[len(report.Stages)-1].Name)
+}
+
+func TestTransform_FromStaging(t *testing.T) {
+	ads, opportunities := sampleInput()
+	batch, err := transform(ads, opportunities, "2025-08-01", models.NewQualityReport("live", "2025-08-01"))
+	assert.NoError(t, err)
+	// staging keeps the normalized records before the since filter and the deduplication
+	assert.Len(t, batch.NormalizedAds, 3)
+	assert.Len(t, batch.NormalizedOpportunities, 2)
+	assert.Equal(t, "2025-08-01", batch.NormalizedOpportunities[0].CreatedAt)
+	assert.Empty(t, batch.NormalizedOpportunities[0].ContactEmail)
+	assert.NotEmpty(t, batch.NormalizedOpportunities[0].ContactEmailHash)
+
+	// normalizing staged records again changes nothing, so a run from staging computes the same results
+	report := models.NewQualityReport("staging", "2025-08-01")
+	results, err := Transform(batch.NormalizedAds, batch.NormalizedOpportunities, "2025-08-01", report)
+	assert.NoError(t, err)
+	assert.Equal(t, batch.Results, results)
+	assert.Zero(t, report.Dropped[models.SourceAds][models.DropInvalidDate])
+}
+
+func TestPipeline_CustomStage(t *testing.T) {
+	err := RegisterStage(NewStage("drop_facebook", func(b *Batch) error {
+		kept := b.Ads[:0]
+		for _, ad := range b.Ads {
+			if ad.Channel != "facebook_ads" {
+				kept = append(kept, ad)
+			}
+		}
+		b.Ads = kept
+		return nil
+	}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { unregisterStage("drop_facebook") })
+	assert.Error(t, RegisterStage(NewStage("drop_facebook", nil)))
+
+	p, err := NewPipeline([]string{"normalize", "drop_facebook", "dedup", "join", "enrich", "compute"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"normalize", "drop_facebook", "dedup", "join", "enrich", "compute"}, p.Stages())
+	b := &Batch{Ads: []models.AdPerformance{
+		{Date: "2025-08-01", Channel: "facebook_ads", CampaignID: "F1", Impressions: 1},
+		{Date: "2025-08-01", Channel: "google_ads", CampaignID: "G1", Impressions: 1},
+	}}
+	assert.NoError(t, p.Run(b))
+	assert.Len(t, b.Results, 1)
+	assert.Equal(t, "G1", b.Results[0].CampaignID)
+
+	_, err = NewPipeline([]string{"normalize", "missing"})
+	assert.ErrorContains(t, err, "unknown transform stage")
+}
+
+func TestTransform_Lineage(t *testing.T) {
+	ads, opportunities := sampleInput()
+	batch, err := transform(ads, opportunities, "2025-08-01", nil)
+	assert.NoError(t, err)
+	assert.Len(t, batch.Lineage, 1)
+	lineage := batch.Lineage[0]
+	assert.Equal(t, "C1", lineage.CampaignID)
+	assert.Equal(t, []models.Attribution{{OpportunityID: "O1", CreatedAt: "2025-08-01", Weight: 1}}, lineage.Opportunities)
+
+	// only the lineage of written rows is saved
+	failures := []models.LoadFailure{{Date: "2025-08-01", Channel: "google_ads", CampaignID: "C1"}}
+	assert.Len(t, writtenLineage(batch.Lineage, batch.Results, nil), 1)
+	assert.Empty(t, writtenLineage(batch.Lineage, batch.Results, failures))
+	assert.Empty(t, writtenLineage(batch.Lineage, nil, nil))
+}
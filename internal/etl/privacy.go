@@ -0,0 +1,309 @@
+package etl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"goetl/internal/db"
+	"goetl/internal/lake"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/pii"
+	"goetl/internal/store"
+	"goetl/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const erasureCollection = "erasure_receipts"
+
+// ErrDatabaseUnavailable is returned when a MongoDB collection cannot be reached
+var ErrDatabaseUnavailable = errors.New("database unavailable")
+
+
+// ContactHash returns the hash used to find a contact across stored data
+func ContactHash(email string) (string, error) {
+	policy, err := loadPolicy()
+	if err != nil {
+		return "", err
+	}
+	return policy.HashEmail(email), nil
+}
+
+
+// EraseContact removes the contact identified by hash from raw payloads, dead letters and staged
+// opportunities, recomputes the ETL results whose lineage lists its opportunities, removes them from that
+// lineage, rewrites the lake partitions holding them, and stores an erasure receipt
+func EraseContact(hash string) (*models.ErasureReceipt, error) {
+	policy, err := loadPolicy()
+	if err != nil {
+		return nil, err
+	}
+	receipt := &models.ErasureReceipt{
+		ID:          utils.NewID(),
+		ContactHash: hash,
+		RequestedAt: time.Now().UTC(),
+	}
+
+	removed, err := erasePayloads(policy, hash, receipt)
+	if err != nil {
+		return nil, err
+	}
+	if err := eraseDeadLetters(hash, receipt); err != nil {
+		return nil, err
+	}
+	staged, err := eraseStaging(hash, receipt)
+	if err != nil {
+		return nil, err
+	}
+	opportunities := erasedOpportunities(append(staged, removed...))
+	receipt.Opportunities = len(opportunities)
+	resultPartitions := map[lake.Partition]bool{}
+	oppPartitions := map[lake.Partition]bool{}
+	for _, opp := range opportunities {
+		opp, ok := normalizeErased(opp)
+		if !ok {
+			continue
+		}
+		oppPartitions[lake.Partition{Date: opp.CreatedAt}] = true
+		lineage, err := attributedLineage(opp)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lineage {
+			resultPartitions[lake.Partition{Date: l.Date, Channel: l.Channel}] = true
+		}
+		n, err := retractFromResults(receipt.ID, opp, lineage)
+		if err != nil {
+			return nil, err
+		}
+		receipt.Results += n
+		if err := eraseLineage(opp); err != nil {
+			return nil, err
+		}
+	}
+
+	if receipt.LakeManifest, receipt.LakeFiles, err = eraseLake(receipt.ID, resultPartitions, oppPartitions); err != nil {
+		return nil, err
+	}
+
+	receipt.CompletedAt = time.Now().UTC()
+	if err := saveErasureReceipt(receipt); err != nil {
+		return nil, err
+	}
+	log.Printf("Erased contact %s: %d raw payloads, %d dead letters, %d staged opportunities, %d results, %d lake files", hash, receipt.RawPayloads, receipt.DeadLetters, receipt.Staged, receipt.Results, receipt.LakeFiles)
+	return receipt, nil
+}
+
+
+// GetErasureReceipt returns a stored erasure receipt, or nil if it does not exist
+func GetErasureReceipt(id string) *models.ErasureReceipt {
+	collection, ctx, cancel := db.GetCollection(erasureCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil
+	}
+	defer cancel()
+	var receipt models.ErasureReceipt
+	if err := collection.FindOne(ctx, bson.M{"id": id}).Decode(&receipt); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Failed to fetch ErasureReceipt: %v", err)
+		}
+		return nil
+	}
+	return &receipt
+}
+
+
+// erasePayloads rewrites every raw opportunities payload mentioning hash without the contact's
+// opportunities, returning the distinct opportunities removed
+func erasePayloads(policy *pii.Policy, hash string, receipt *models.ErasureReceipt) ([]models.Opportunity, error) {
+	collection, ctx, cancel := db.GetCollection(rawPayloadCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil, ErrDatabaseUnavailable
+	}
+	defer cancel()
+	filter := bson.M{"source": models.SourceOpportunities, "contacthashes": hash}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var payloads []models.RawPayload
+	if err := cursor.All(ctx, &payloads); err != nil {
+		return nil, err
+	}
+
+	removed := make(map[string]models.Opportunity)
+	for _, raw := range payloads {
+		data := []byte(raw.Payload)
+		if raw.Encrypted {
+			if data, err = policy.Decrypt(raw.Ciphertext); err != nil {
+				return nil, fmt.Errorf("decrypting raw payload of run %s: %w", raw.RunID, err)
+			}
+		}
+		var opportunities []models.Opportunity
+		if err := json.Unmarshal(data, &opportunities); err != nil {
+			return nil, fmt.Errorf("decoding raw payload of run %s: %w", raw.RunID, err)
+		}
+		kept := make([]models.Opportunity, 0, len(opportunities))
+		for _, opp := range opportunities {
+			if opp.ContactEmailHash == hash {
+				removed[utils.SanitizeString(opp.OpportunityID)] = opp
+				continue
+			}
+			kept = append(kept, opp)
+		}
+		hashes := make([]string, 0, len(raw.ContactHashes))
+		for _, h := range raw.ContactHashes {
+			if h != hash {
+				hashes = append(hashes, h)
+			}
+		}
+		updated, err := newRawPayload(raw.RunID, raw.Source, policy, kept, len(kept))
+		if err != nil {
+			return nil, err
+		}
+		update := bson.M{"$set": bson.M{
+			"records":       updated.Records,
+			"encrypted":     updated.Encrypted,
+			"payload":       updated.Payload,
+			"ciphertext":    updated.Ciphertext,
+			"contacthashes": hashes,
+		}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"runid": raw.RunID, "source": raw.Source}, update); err != nil {
+			return nil, err
+		}
+		receipt.RawPayloads++
+	}
+
+	out := make([]models.Opportunity, 0, len(removed))
+	for _, opp := range removed {
+		out = append(out, opp)
+	}
+	return out, nil
+}
+
+
+// eraseDeadLetters deletes the dead letters of the contact
+func eraseDeadLetters(hash string, receipt *models.ErasureReceipt) error {
+	collection, ctx, cancel := db.GetCollection(deadLetterCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return ErrDatabaseUnavailable
+	}
+	defer cancel()
+	res, err := collection.DeleteMany(ctx, bson.M{"contacthash": hash})
+	if err != nil {
+		return err
+	}
+	receipt.DeadLetters = int(res.DeletedCount)
+	return nil
+}
+
+
+// erasedOpportunities returns the distinct opportunities by id, keeping the first copy of each. Raw payloads
+// of several runs and staging may hold the same opportunity.
+func erasedOpportunities(opportunities []models.Opportunity) []models.Opportunity {
+	seen := make(map[string]bool, len(opportunities))
+	out := make([]models.Opportunity, 0, len(opportunities))
+	for _, opp := range opportunities {
+		id := utils.SanitizeString(opp.OpportunityID)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, opp)
+	}
+	return out
+}
+
+
+// normalizeErased normalizes an opportunity read back from a raw payload as the normalize stage does, so its
+// date and UTMs match the results and lineage. False is returned for one the stage dead-letters, which never
+// reached the results.
+func normalizeErased(opp models.Opportunity) (models.Opportunity, bool) {
+	if opp.CreatedAt == "" || opp.UTMCampaign == "" || opp.UTMSource == "" || opp.UTMMedium == "" {
+		return opp, false
+	}
+	date, err := utils.NormalizeDate(opp.CreatedAt)
+	if err != nil {
+		return opp, false
+	}
+	opp.CreatedAt = date
+	opp.UTMCampaign = utils.SanitizeString(opp.UTMCampaign)
+	opp.UTMSource = utils.SanitizeString(opp.UTMSource)
+	opp.UTMMedium = utils.SanitizeString(opp.UTMMedium)
+	opp.Stage = utils.SanitizeString(opp.Stage)
+	opp.OpportunityID = utils.SanitizeString(opp.OpportunityID)
+	return opp, true
+}
+
+
+// retractFromResults removes the contribution of opp from the stored ETL results whose lineage lists it,
+// stamping them with the erasure id so their new version is not taken for one of the run that first wrote
+// them. Results the opportunity was not counted in, e.g. after deduplication dropped it, are left alone.
+func retractFromResults(erasureID string, opp models.Opportunity, lineage []models.Lineage) (int, error) {
+	if len(lineage) == 0 {
+		return 0, nil
+	}
+	metricSet, err := loadMetricSet()
+	if err != nil {
+		return 0, err
+	}
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	var results []models.ETLResult
+	for _, l := range lineage {
+		// a row since deleted by a range replacement matches nothing
+		found, err := resultStore.Query(ctx, store.Query{Date: l.Date, Channel: l.Channel, CampaignID: l.CampaignID})
+		if err != nil {
+			return 0, err
+		}
+		results = append(results, found...)
+	}
+	for i := range results {
+		retractOpportunity(&results[i], opp)
+		metricSet.Apply(&results[i])
+		results[i].RunID = erasureID
+	}
+	if _, failures := resultStore.Save(ctx, results); len(failures) > 0 {
+		return 0, errors.New(failures[0].Error)
+	}
+	return len(results), nil
+}
+
+
+// retractOpportunity reverses what Transform added to res for opp
+func retractOpportunity(res *models.ETLResult, opp models.Opportunity) {
+	if res.Opportunities > 0 {
+		res.Opportunities--
+	}
+	if opp.Stage == "lead" && res.Leads > 0 {
+		res.Leads--
+	}
+	if opp.Stage == "closed_won" {
+		if res.ClosedWon > 0 {
+			res.ClosedWon--
+		}
+		res.Revenue = res.Revenue.Sub(money.NewFromFloat(opp.Amount))
+	}
+	if opp.Stage != "closed_won" && opp.Stage != "closed_lost" {
+		res.Pipeline = res.Pipeline.Sub(money.NewFromFloat(opp.Amount))
+	}
+}
+
+
+func saveErasureReceipt(receipt *models.ErasureReceipt) error {
+	collection, ctx, cancel := db.GetCollection(erasureCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return ErrDatabaseUnavailable
+	}
+	defer cancel()
+	_, err := collection.InsertOne(ctx, receipt)
+	return err
+}
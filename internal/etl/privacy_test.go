@@ -0,0 +1,61 @@
+package etl
+
+import (
+	"context"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/store"
+)
+
+func TestEraseContact_RawPayloadOnly(t *testing.T) {
+	memory := useMemoryStore(t)
+
+	res := func(channel, campaignID string) models.ETLResult {
+		return models.ETLResult{
+			Date: "2025-08-05", Channel: channel, CampaignID: campaignID,
+			UTMCampaign: "back_to_school", UTMSource: "google", UTMMedium: "cpc",
+			Opportunities: 1, ClosedWon: 1, Revenue: money.NewFromInt(100),
+		}
+	}
+	_, err := Load([]models.ETLResult{res("google_ads", "C1"), res("facebook_ads", "C2")})
+	assert.NoError(t, err)
+
+	// the contact is not staged: its opportunity is only known from the raw payloads of two runs, as extracted
+	raw := models.Opportunity{
+		OpportunityID: "O-1", CreatedAt: "2025-08-05T10:22:00Z", Stage: "closed_won", Amount: 100,
+		UTMCampaign: " back_to_school ", UTMSource: "google", UTMMedium: "cpc",
+	}
+	deadLettered := models.Opportunity{OpportunityID: "O-2", CreatedAt: "2025-08-05T11:00:00Z", Stage: "closed_won", Amount: 50, UTMCampaign: "back_to_school"}
+	opportunities := erasedOpportunities([]models.Opportunity{raw, raw, deadLettered})
+	assert.Len(t, opportunities, 2)
+
+	opp, ok := normalizeErased(opportunities[0])
+	assert.True(t, ok)
+	assert.Equal(t, "2025-08-05", opp.CreatedAt)
+	assert.Equal(t, "back_to_school", opp.UTMCampaign)
+	_, ok = normalizeErased(opportunities[1])
+	assert.False(t, ok)
+
+	// only the row whose lineage lists the opportunity counted it
+	lineage := []models.Lineage{{Date: "2025-08-05", Channel: "google_ads", CampaignID: "C1", Opportunities: []models.Attribution{{OpportunityID: "O-1", CreatedAt: "2025-08-05", Weight: 1}}}}
+	n, err := retractFromResults("erasure-1", opp, lineage)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	results, _ := memory.Query(context.Background(), store.Query{})
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "facebook_ads", results[0].Channel)
+		assert.Equal(t, 1, results[0].ClosedWon)
+		assert.Equal(t, "google_ads", results[1].Channel)
+		assert.Equal(t, 0, results[1].Opportunities)
+		assert.Equal(t, 0, results[1].ClosedWon)
+		assert.True(t, results[1].Revenue.IsZero())
+		assert.Equal(t, "erasure-1", results[1].RunID)
+	}
+
+	n, err = retractFromResults("erasure-2", opp, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,67 @@
+package etl
+
+import (
+	"context"
+	"time"
+	"goetl/internal/export"
+	"goetl/internal/metrics"
+	"goetl/internal/models"
+	"goetl/internal/store"
+)
+
+
+// BuildReport sums the results dated from to to by channel, by channel and campaign, by day and in total,
+// evaluating the derived metrics on each sum
+func BuildReport(from, to string) (*export.Report, error) {
+	metricSet, err := loadMetricSet()
+	if err != nil {
+		return nil, err
+	}
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	report := &export.Report{
+		From:           from,
+		To:             to,
+		GeneratedAt:    time.Now().UTC(),
+		CurrencyFormat: reportCurrencyFormat(),
+		Metrics:        metricSet.Metrics(),
+	}
+	q := store.Query{From: from, To: to}
+	groupings := []struct {
+		rows    *[]export.ReportRow
+		groupBy []string
+	}{
+		{&report.Channels, []string{store.GroupChannel}},
+		{&report.Campaigns, []string{store.GroupChannel, store.GroupCampaign}},
+		{&report.Days, []string{store.GroupDate}},
+	}
+	for _, g := range groupings {
+		aggregates, err := resultStore.Aggregate(ctx, q, g.groupBy...)
+		if err != nil {
+			return nil, err
+		}
+		*g.rows = reportRows(metricSet, aggregates)
+	}
+	totals, err := resultStore.Aggregate(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if rows := reportRows(metricSet, totals); len(rows) > 0 {
+		report.Total = rows[0]
+	}
+	return report, nil
+}
+
+
+// reportRows evaluates the derived metrics of each aggregate on its sums
+func reportRows(metricSet *metrics.Set, aggregates []models.ResultAggregate) []export.ReportRow {
+	rows := make([]export.ReportRow, len(aggregates))
+	for i, agg := range aggregates {
+		rows[i] = export.ReportRow{ResultAggregate: agg, Metrics: metricSet.Evaluate(metrics.AggregateFields(agg))}
+	}
+	return rows
+}
@@ -0,0 +1,110 @@
+package etl
+
+import (
+	"sort"
+	"strconv"
+	"time"
+	"goetl/internal/metrics"
+	"goetl/internal/models"
+	"goetl/internal/store"
+	"goetl/internal/utils"
+)
+
+
+// restatementDays returns the number of past days every run reprocesses, set with RESTATEMENT_DAYS
+func restatementDays() int {
+	days, err := strconv.Atoi(utils.Getenv("RESTATEMENT_DAYS"))
+	if err != nil || days < 0 {
+		return 0
+	}
+	return days
+}
+
+
+// effectiveSince widens since so the last days of the restatement window are always reprocessed.
+// An empty since already covers every date and is returned as is, unless the run is scheduled.
+func effectiveSince(since string, days int, now time.Time) string {
+	if days <= 0 {
+		return since
+	}
+	windowStart := now.UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	if since == "" || windowStart < since {
+		return windowStart
+	}
+	return since
+}
+
+
+// resultKey identifies an ETLResult the same way the result store upserts it
+func resultKey(res models.ETLResult) string {
+	return store.Key(res)
+}
+
+
+// diffResults lists the base fields and metrics whose value differs between a stored and an updated result
+func diffResults(old, updated models.ETLResult) []models.FieldChange {
+	var changes []models.FieldChange
+	oldFields, newFields := metrics.Fields(old), metrics.Fields(updated)
+	for _, field := range metrics.BaseFields {
+		if !oldFields[field].Equal(newFields[field]) {
+			changes = append(changes, models.FieldChange{Field: field, Old: oldFields[field], New: newFields[field]})
+		}
+	}
+	names := make([]string, 0, len(updated.Metrics))
+	for name := range updated.Metrics {
+		names = append(names, name)
+	}
+	for name := range old.Metrics {
+		if _, ok := updated.Metrics[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !old.Metrics[name].Equal(updated.Metrics[name]) {
+			changes = append(changes, models.FieldChange{Field: name, Old: old.Metrics[name], New: updated.Metrics[name]})
+		}
+	}
+	return changes
+}
+
+
+// detectRestatements compares results with the stored rows they will overwrite and returns a
+// record for every row whose values changed
+func detectRestatements(runID string, results []models.ETLResult) []models.Restatement {
+	if len(results) == 0 {
+		return nil
+	}
+	from, to := results[0].Date, results[0].Date
+	for _, res := range results {
+		if res.Date < from {
+			from = res.Date
+		}
+		if res.Date > to {
+			to = res.Date
+		}
+	}
+	stored := make(map[string]models.ETLResult)
+	for _, res := range queryResults(store.Query{From: from, To: to}) {
+		stored[resultKey(res)] = res
+	}
+	var restatements []models.Restatement
+	now := time.Now().UTC()
+	for _, res := range results {
+		old, ok := stored[resultKey(res)]
+		if !ok {
+			continue
+		}
+		if changes := diffResults(old, res); len(changes) > 0 {
+			restatements = append(restatements, models.Restatement{
+				RunID:      runID,
+				Date:       res.Date,
+				Channel:    res.Channel,
+				CampaignID: res.CampaignID,
+				Changes:    changes,
+				CreatedAt:  now,
+			})
+		}
+	}
+	return restatements
+}
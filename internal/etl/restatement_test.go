@@ -0,0 +1,34 @@
+package etl
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+)
+
+func TestEffectiveSince(t *testing.T) {
+	now := time.Date(2025, 9, 20, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, "2025-09-18", effectiveSince("2025-09-18", 0, now))
+	assert.Equal(t, "2025-09-13", effectiveSince("2025-09-18", 7, now))
+	assert.Equal(t, "2025-09-01", effectiveSince("2025-09-01", 7, now))
+	assert.Equal(t, "2025-09-13", effectiveSince("", 7, now))
+	assert.Equal(t, "", effectiveSince("", 0, now))
+}
+
+func TestDiffResults(t *testing.T) {
+	d := money.RequireFromString
+	old := models.ETLResult{Date: "2025-09-01", Cost: d("10"), Clicks: 5, Metrics: map[string]money.Decimal{"cpc": d("2"), "ctr": d("0.1")}}
+	updated := models.ETLResult{Date: "2025-09-01", Cost: d("12"), Clicks: 5, Metrics: map[string]money.Decimal{"cpc": d("2.4")}}
+	changes := diffResults(old, updated)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, "cost", changes[0].Field)
+	assert.Equal(t, "10", changes[0].Old.String())
+	assert.Equal(t, "12", changes[0].New.String())
+	assert.Equal(t, "cpc", changes[1].Field)
+	assert.Equal(t, "2.4", changes[1].New.String())
+	assert.Equal(t, "ctr", changes[2].Field)
+	assert.True(t, changes[2].New.IsZero())
+	assert.Empty(t, diffResults(old, old))
+}
@@ -0,0 +1,216 @@
+package etl
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"goetl/internal/db"
+	"goetl/internal/models"
+	"goetl/internal/retention"
+	"goetl/internal/store"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// retentionTarget is a MongoDB collection expired with a retention group, by a timestamp field or, when
+// Date is set, by a YYYY-MM-DD field. Collections holding Contacts are deleted without being archived, as
+// erasures could not reach the archives.
+type retentionTarget struct {
+	Group      string
+	Collection string
+	Field      string
+	Date       bool
+	Contacts   bool
+}
+
+// retentionTargets are the collections purged besides the results and their versions, which go through
+// the result store. Budgets and erasure receipts are never expired.
+var retentionTargets = []retentionTarget{
+	{retention.Results, anomalyCollection, "date", true, false},
+	{retention.Results, lineageCollection, "date", true, false},
+	{retention.Results, stagingAdCollection, "date", true, false},
+	{retention.Results, stagingOpportunityCollection, "createdat", true, true},
+	{retention.RawPayloads, rawPayloadCollection, "createdat", false, true},
+	{retention.DeadLetters, deadLetterCollection, "createdat", false, true},
+	{retention.Runs, qualityCollection, "startedat", false, false},
+	{retention.Runs, violationCollection, "createdat", false, false},
+	{retention.Runs, restatementCollection, "createdat", false, false},
+	{retention.Runs, rollbackCollection, "restoredat", false, false},
+}
+
+
+// contactsNotArchived is the note of the purges that skip RETENTION_ARCHIVE_DIR for contact data
+const contactsNotArchived = "not archived: the collection holds contact data, which erasures cannot reach in archives"
+
+// archiveDir returns the directory the expired documents of t are archived to, none for contact data, and
+// the note to report when dir is set but skipped
+func (t retentionTarget) archiveDir(dir string) (string, string) {
+	if t.Contacts && dir != "" {
+		return "", contactsNotArchived
+	}
+	return dir, ""
+}
+
+
+// Purge deletes the data older than the retention of its group, archiving it first when RETENTION_ARCHIVE_DIR
+// is set, except raw payloads, dead letters and staged opportunities, whose results carry a note instead.
+// With RETENTION_MODE=ttl the time stamped collections are left to their TTL indexes. A failure is reported
+// in the result of its collection, whose data is then kept.
+func Purge() ([]models.PurgeResult, error) {
+	cfg, err := loadRetentionConfig()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	var results []models.PurgeResult
+	if cutoff, ok := cfg.Cutoff(retention.Results, now); ok {
+		resultStore, err := loadResultStore()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, purgeResults(ctx, resultStore, cfg.ArchiveDir, cutoff, now)...)
+	}
+	for _, target := range retentionTargets {
+		cutoff, ok := cfg.Cutoff(target.Group, now)
+		if !ok || (!target.Date && cfg.Mode == retention.ModeTTL) {
+			continue
+		}
+		archiveDir, note := target.archiveDir(cfg.ArchiveDir)
+		result := purgeCollection(ctx, archiveDir, target, cutoff, now)
+		result.Note = note
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+
+// purgeResults deletes the results dated before the day of cutoff, with all their versions
+func purgeResults(ctx context.Context, resultStore store.ResultStore, archiveDir string, cutoff, now time.Time) []models.PurgeResult {
+	rows := models.PurgeResult{Collection: etlCollection, Cutoff: cutoff}
+	versions := models.PurgeResult{Collection: resultVersionCollection, Cutoff: cutoff}
+	fail := func(err error) []models.PurgeResult {
+		rows.Error, versions.Error = err.Error(), err.Error()
+		return []models.PurgeResult{rows, versions}
+	}
+	var err error
+	q := store.Query{To: cutoff.AddDate(0, 0, -1).Format("2006-01-02")}
+	if archiveDir != "" {
+		expired, err := resultStore.Query(ctx, q)
+		if err != nil {
+			return fail(err)
+		}
+		records := make([]interface{}, len(expired))
+		for i := range expired {
+			records[i] = expired[i]
+		}
+		if rows.Archive, rows.Archived, err = archiveRecords(archiveDir, etlCollection, now, records); err != nil {
+			return fail(err)
+		}
+		history, err := resultStore.History(ctx, q, time.Time{})
+		if err != nil {
+			return fail(err)
+		}
+		records = make([]interface{}, len(history))
+		for i := range history {
+			records[i] = history[i]
+		}
+		if versions.Archive, versions.Archived, err = archiveRecords(archiveDir, resultVersionCollection, now, records); err != nil {
+			return fail(err)
+		}
+	}
+	if rows.Deleted, versions.Deleted, err = resultStore.Purge(ctx, q); err != nil {
+		return fail(err)
+	}
+	return []models.PurgeResult{rows, versions}
+}
+
+
+// purgeCollection deletes the documents of target older than cutoff
+func purgeCollection(ctx context.Context, archiveDir string, target retentionTarget, cutoff, now time.Time) models.PurgeResult {
+	result := models.PurgeResult{Collection: target.Collection, Cutoff: cutoff}
+	database, err := db.GetDatabase()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	collection := database.Collection(target.Collection)
+	filter := bson.M{target.Field: bson.M{"$lt": cutoff}}
+	if target.Date {
+		filter = bson.M{target.Field: bson.M{"$lt": cutoff.Format("2006-01-02")}}
+	}
+	if archiveDir != "" {
+		cursor, err := collection.Find(ctx, filter)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		defer cursor.Close(ctx)
+		var records []interface{}
+		for cursor.Next(ctx) {
+			// extended JSON keeps the BSON types of the documents, such as dates and decimals
+			doc, err := bson.MarshalExtJSON(cursor.Current, false, false)
+			if err != nil {
+				result.Error = err.Error()
+				return result
+			}
+			records = append(records, json.RawMessage(doc))
+		}
+		if err := cursor.Err(); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if result.Archive, result.Archived, err = archiveRecords(archiveDir, target.Collection, now, records); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	res, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Deleted = int(res.DeletedCount)
+	return result
+}
+
+
+// archiveRecords writes records to a new archive of collection and returns its path and line count. Nothing
+// is written when there are no records.
+func archiveRecords(dir, collection string, at time.Time, records []interface{}) (string, int, error) {
+	if len(records) == 0 {
+		return "", 0, nil
+	}
+	archive, err := retention.CreateArchive(dir, collection, at)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, record := range records {
+		if err := archive.Write(record); err != nil {
+			archive.Abort()
+			return "", 0, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return "", 0, err
+	}
+	return archive.Path(), archive.Lines, nil
+}
+
+
+// logPurge logs what a purge removed and the collections it failed on
+func logPurge(results []models.PurgeResult) {
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			log.Printf("Retention purge of %s failed: %s", r.Collection, r.Error)
+		case r.Archive != "":
+			log.Printf("Retention purge of %s deleted %d documents older than %s, archived to %s", r.Collection, r.Deleted, r.Cutoff.Format(time.RFC3339), r.Archive)
+		case r.Note != "":
+			log.Printf("Retention purge of %s deleted %d documents older than %s, %s", r.Collection, r.Deleted, r.Cutoff.Format(time.RFC3339), r.Note)
+		case r.Deleted > 0:
+			log.Printf("Retention purge of %s deleted %d documents older than %s", r.Collection, r.Deleted, r.Cutoff.Format(time.RFC3339))
+		}
+	}
+}
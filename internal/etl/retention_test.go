@@ -0,0 +1,66 @@
+package etl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"os"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/store"
+)
+
+func TestPurgeResults(t *testing.T) {
+	ctx := context.Background()
+	memory := store.NewMemory()
+	res := func(date string) models.ETLResult {
+		return models.ETLResult{Date: date, Channel: "google_ads", CampaignID: "C1", Cost: money.NewFromInt(1)}
+	}
+	memory.Save(ctx, []models.ETLResult{res("2025-07-20"), res("2025-07-21"), res("2025-07-22")})
+
+	dir := t.TempDir()
+	now := time.Date(2025, 10, 19, 8, 0, 0, 0, time.UTC)
+	cutoff := time.Date(2025, 7, 21, 8, 0, 0, 0, time.UTC)
+	results := purgeResults(ctx, memory, dir, cutoff, now)
+	assert.Len(t, results, 2)
+	assert.Equal(t, etlCollection, results[0].Collection)
+	assert.Empty(t, results[0].Error)
+	// the day of the cutoff is kept
+	assert.Equal(t, 1, results[0].Deleted)
+	assert.Equal(t, 1, results[0].Archived)
+	assert.Equal(t, 1, results[1].Deleted)
+
+	kept, _ := memory.Query(ctx, store.Query{})
+	assert.Len(t, kept, 2)
+	assert.Equal(t, "2025-07-21", kept[0].Date)
+
+	f, err := os.Open(results[0].Archive)
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	scanner := bufio.NewScanner(gz)
+	assert.True(t, scanner.Scan())
+	assert.Contains(t, scanner.Text(), `"date":"2025-07-20"`)
+	assert.False(t, scanner.Scan())
+}
+
+func TestRetentionTargets_ContactsNotArchived(t *testing.T) {
+	contacts := map[string]bool{rawPayloadCollection: true, deadLetterCollection: true, stagingOpportunityCollection: true}
+	for _, target := range retentionTargets {
+		dir, note := target.archiveDir("/archive")
+		if contacts[target.Collection] {
+			assert.Empty(t, dir, target.Collection)
+			assert.Equal(t, contactsNotArchived, note, target.Collection)
+		} else {
+			assert.Equal(t, "/archive", dir, target.Collection)
+			assert.Empty(t, note, target.Collection)
+		}
+		// without an archive directory nothing is skipped
+		_, note = target.archiveDir("")
+		assert.Empty(t, note, target.Collection)
+	}
+}
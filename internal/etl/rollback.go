@@ -0,0 +1,92 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"goetl/internal/db"
+	"goetl/internal/models"
+	"goetl/internal/store"
+	"goetl/internal/utils"
+)
+
+const rollbackCollection = "rollbacks"
+
+// ErrRunNotFound is returned when the result store holds no version written by a run
+var ErrRunNotFound = errors.New("run not found")
+
+
+// RollbackRun restores the results dated from to to, both inclusive, to their state just before run runID
+// first wrote results: rows inserted since are deleted and rows changed or deleted since get back their prior
+// version. The restored rows are stamped with the id of the rollback, whose audit record is stored and returned.
+func RollbackRun(runID, from, to string) (*models.Rollback, error) {
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	rollback, err := restoreBefore(ctx, resultStore, runID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveRollback(rollback); err != nil {
+		log.Printf("Failed to save Rollback %s: %v", rollback.ID, err)
+	}
+	return rollback, nil
+}
+
+
+// restoreBefore restores the range of resultStore from its version history, see RollbackRun
+func restoreBefore(ctx context.Context, resultStore store.ResultStore, runID, from, to string) (*models.Rollback, error) {
+	before, _, err := runWrites(ctx, resultStore, runID)
+	if err != nil {
+		return nil, err
+	}
+	q := store.Query{From: from, To: to}
+	// versions are recorded to the millisecond, the last one before the run is at least one earlier
+	versions, err := resultStore.History(ctx, q, before.Add(-time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	rollback := &models.Rollback{ID: utils.NewID(), RunID: runID, From: from, To: to, Before: before}
+	if rollback.Load, err = resultStore.Restore(ctx, rollback.ID, q, store.StateOf(versions)); err != nil {
+		return nil, err
+	}
+	rollback.RestoredAt = time.Now().UTC()
+	return rollback, nil
+}
+
+
+// runWrites returns when run runID first and last wrote or deleted results, ErrRunNotFound if it never did
+func runWrites(ctx context.Context, resultStore store.ResultStore, runID string) (time.Time, time.Time, error) {
+	written, err := resultStore.History(ctx, store.Query{RunID: runID}, time.Time{})
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if len(written) == 0 {
+		return time.Time{}, time.Time{}, ErrRunNotFound
+	}
+	first, last := written[0].WrittenAt, written[0].WrittenAt
+	for _, v := range written[1:] {
+		if v.WrittenAt.Before(first) {
+			first = v.WrittenAt
+		}
+		if v.WrittenAt.After(last) {
+			last = v.WrittenAt
+		}
+	}
+	return first, last, nil
+}
+
+
+func saveRollback(rollback *models.Rollback) error {
+	collection, ctx, cancel := db.GetCollection(rollbackCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return ErrDatabaseUnavailable
+	}
+	defer cancel()
+	_, err := collection.InsertOne(ctx, rollback)
+	return err
+}
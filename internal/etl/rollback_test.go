@@ -0,0 +1,65 @@
+package etl
+
+import (
+	"context"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/store"
+)
+
+func TestRestoreBefore(t *testing.T) {
+	ctx := context.Background()
+	memory := store.NewMemory()
+	res := func(campaignID string, cost int) models.ETLResult {
+		return models.ETLResult{Date: "2025-09-01", Channel: "google_ads", CampaignID: campaignID, Cost: money.NewFromInt(cost)}
+	}
+	memory.Replace(ctx, "good", "2025-09-01", []models.ETLResult{res("C1", 10), res("C2", 20)})
+	// versions are told apart by their millisecond
+	time.Sleep(2 * time.Millisecond)
+	memory.Replace(ctx, "bad", "2025-09-01", []models.ETLResult{res("C1", 0), res("C3", 5)})
+
+	_, err := restoreBefore(ctx, memory, "missing", "2025-09-01", "2025-09-01")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+
+	rollback, err := restoreBefore(ctx, memory, "bad", "2025-09-01", "2025-09-01")
+	assert.NoError(t, err)
+	assert.Equal(t, "bad", rollback.RunID)
+	assert.Equal(t, 1, rollback.Load.Inserted)
+	assert.Equal(t, 1, rollback.Load.Modified)
+	assert.Equal(t, 1, rollback.Load.Deleted)
+
+	results, _ := memory.Query(ctx, store.Query{})
+	assert.Len(t, results, 2)
+	assert.Equal(t, "C1", results[0].CampaignID)
+	assert.Equal(t, "10", results[0].Cost.String())
+	assert.Equal(t, rollback.ID, results[0].RunID)
+	assert.Equal(t, "C2", results[1].CampaignID)
+}
+
+func TestRunWrites(t *testing.T) {
+	ctx := context.Background()
+	memory := store.NewMemory()
+	memory.Save(ctx, []models.ETLResult{{RunID: "run1", Date: "2025-09-01", Channel: "google_ads", CampaignID: "C1"}})
+	time.Sleep(2 * time.Millisecond)
+	memory.Save(ctx, []models.ETLResult{{RunID: "run1", Date: "2025-09-02", Channel: "google_ads", CampaignID: "C1"}})
+	first, last, err := runWrites(ctx, memory, "run1")
+	assert.NoError(t, err)
+	assert.True(t, last.After(first))
+	_, _, err = runWrites(ctx, memory, "run2")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+}
+
+func TestTransformVersion(t *testing.T) {
+	version, err := transformVersion()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^v1-[0-9a-f]{12}$`, version)
+	again, _ := transformVersion()
+	assert.Equal(t, version, again)
+
+	t.Setenv("TRANSFORM_VERSION", "2025.09")
+	version, _ = transformVersion()
+	assert.Equal(t, "2025.09", version)
+}
@@ -0,0 +1,47 @@
+package etl
+
+import (
+	"log"
+	"time"
+	"goetl/internal/models"
+)
+
+
+// StartScheduler runs the ETL every interval in the background. Scheduled runs have no 'since',
+// so with RESTATEMENT_DAYS set they reprocess the restatement window only.
+func StartScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			results, report, err := runETL("", models.ExtractLive, true)
+			if err != nil {
+				log.Printf("Scheduled ETL run failed: %v", err)
+				continue
+			}
+			log.Printf("Scheduled ETL run %s processed %d records, restated %d", report.RunID, len(results), report.Restated)
+		}
+	}()
+}
+
+
+// StartRetention purges the expired data now and then every RETENTION_PURGE_INTERVAL in the background.
+// It does nothing when no retention period is set or the interval is 0.
+func StartRetention() {
+	cfg, err := loadRetentionConfig()
+	if err != nil || !cfg.Enabled() || cfg.Interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			results, err := Purge()
+			if err != nil {
+				log.Printf("Retention purge failed: %v", err)
+			}
+			logPurge(results)
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,195 @@
+package etl
+
+import (
+	"strings"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/utils"
+)
+
+// builtinStages returns the stages goetl ships with
+func builtinStages() []Stage {
+	return []Stage{
+		NewStage("normalize", normalizeStage),
+		NewStage("filter", filterStage),
+		NewStage("dedup", dedupStage),
+		NewStage("join", joinStage),
+		NewStage("enrich", enrichStage),
+		NewStage("compute", computeStage),
+	}
+}
+
+
+// normalizeStage sanitizes, validates and protects the raw records
+func normalizeStage(b *Batch) error {
+	var err error
+	if b.Opportunities, err = TransformOpportunitiesData(b.Opportunities, b.Report); err != nil {
+		return err
+	}
+	if b.Ads, err = TransformPerformanceData(b.Ads, b.Report); err != nil {
+		return err
+	}
+	// copies, later stages may filter the records in place
+	b.NormalizedAds = append([]models.AdPerformance(nil), b.Ads...)
+	b.NormalizedOpportunities = append([]models.Opportunity(nil), b.Opportunities...)
+	return nil
+}
+
+
+// filterStage keeps records on or after b.Since, if set
+func filterStage(b *Batch) error {
+	if b.Since == "" {
+		return nil
+	}
+	ads := make([]models.AdPerformance, 0, len(b.Ads))
+	for _, ad := range b.Ads {
+		if ad.Date >= b.Since {
+			ads = append(ads, ad)
+		} else {
+			b.Report.AddDrop(models.SourceAds, models.DropBeforeSince)
+		}
+	}
+	opportunities := make([]models.Opportunity, 0, len(b.Opportunities))
+	for _, opp := range b.Opportunities {
+		if strings.HasPrefix(opp.CreatedAt, b.Since) || opp.CreatedAt >= b.Since {
+			opportunities = append(opportunities, opp)
+		} else {
+			b.Report.AddDrop(models.SourceOpportunities, models.DropBeforeSince)
+		}
+	}
+	b.Ads, b.Opportunities = ads, opportunities
+	return nil
+}
+
+
+// dedupStage keeps the last ad per (date, channel, campaign_id) and the last opportunity per
+// (created_at, utm_campaign, utm_source, utm_medium), in first-seen order
+func dedupStage(b *Batch) error {
+	adIndex := make(map[string]int)
+	ads := make([]models.AdPerformance, 0, len(b.Ads))
+	for _, ad := range b.Ads {
+		key := ad.Date + ":" + ad.Channel + ":" + ad.CampaignID
+		if i, ok := adIndex[key]; ok {
+			b.Report.AddDuplicate(models.SourceAds)
+			ads[i] = ad
+			continue
+		}
+		adIndex[key] = len(ads)
+		ads = append(ads, ad)
+	}
+	oppIndex := make(map[string]int)
+	opportunities := make([]models.Opportunity, 0, len(b.Opportunities))
+	for _, opp := range b.Opportunities {
+		key := opp.CreatedAt + ":" + opp.UTMCampaign + ":" + opp.UTMSource + ":" + opp.UTMMedium
+		if i, ok := oppIndex[key]; ok {
+			b.Report.AddDuplicate(models.SourceOpportunities)
+			opportunities[i] = opp
+			continue
+		}
+		oppIndex[key] = len(opportunities)
+		opportunities = append(opportunities, opp)
+	}
+	b.Ads, b.Opportunities = ads, opportunities
+	return nil
+}
+
+
+// joinStage attributes opportunities to ads by date, utm_campaign, utm_source and utm_medium,
+// recording match statistics and unattributed revenue
+func joinStage(b *Batch) error {
+	type joinKey struct{ date, campaign, source, medium string }
+	byKey := make(map[joinKey][]int)
+	for i, opp := range b.Opportunities {
+		k := joinKey{opp.CreatedAt, opp.UTMCampaign, opp.UTMSource, opp.UTMMedium}
+		byKey[k] = append(byKey[k], i)
+	}
+	matched := make([]bool, len(b.Opportunities))
+	adsMatched := 0
+	b.Joined = make([]JoinedAd, 0, len(b.Ads))
+	for _, ad := range b.Ads {
+		joined := JoinedAd{Ad: ad}
+		for _, i := range byKey[joinKey{ad.Date, ad.UTMCampaign, ad.UTMSource, ad.UTMMedium}] {
+			matched[i] = true
+			joined.Opportunities = append(joined.Opportunities, b.Opportunities[i])
+		}
+		if len(joined.Opportunities) > 0 {
+			adsMatched++
+		}
+		b.Joined = append(b.Joined, joined)
+	}
+
+	if b.Report != nil {
+		opportunitiesMatched := 0
+		unattributed := money.Zero
+		for i, opp := range b.Opportunities {
+			if matched[i] {
+				opportunitiesMatched++
+			} else if opp.Stage == "closed_won" {
+				unattributed = unattributed.Add(money.NewFromFloat(opp.Amount))
+			}
+		}
+		b.Report.Join = models.JoinStats{
+			Ads:                  len(b.Ads),
+			AdsMatched:           adsMatched,
+			Opportunities:        len(b.Opportunities),
+			OpportunitiesMatched: opportunitiesMatched,
+		}
+		if len(b.Opportunities) > 0 {
+			b.Report.Join.MatchRate = utils.RoundFloat(float64(opportunitiesMatched)/float64(len(b.Opportunities)), 4)
+		}
+		b.Report.UnattributedRevenue = unattributed
+	}
+	return nil
+}
+
+
+// enrichStage builds one result per joined ad, aggregating its opportunities, and its lineage. Every ad
+// matching an opportunity is credited with all of it, so the attribution weights are 1.
+func enrichStage(b *Batch) error {
+	b.Results = make([]models.ETLResult, 0, len(b.Joined))
+	b.Lineage = make([]models.Lineage, 0, len(b.Joined))
+	for _, j := range b.Joined {
+		lineage := models.Lineage{Date: j.Ad.Date, Channel: j.Ad.Channel, CampaignID: j.Ad.CampaignID, Opportunities: []models.Attribution{}}
+		res := models.ETLResult{
+			Date:        j.Ad.Date,
+			Channel:     j.Ad.Channel,
+			CampaignID:  j.Ad.CampaignID,
+			UTMCampaign: j.Ad.UTMCampaign,
+			UTMSource:   j.Ad.UTMSource,
+			UTMMedium:   j.Ad.UTMMedium,
+			Clicks:      j.Ad.Clicks,
+			Impressions: j.Ad.Impressions,
+			Cost:        money.NewFromFloat(j.Ad.Cost),
+		}
+		for _, opp := range j.Opportunities {
+			lineage.Opportunities = append(lineage.Opportunities, models.Attribution{OpportunityID: opp.OpportunityID, CreatedAt: opp.CreatedAt, Weight: 1})
+			res.Opportunities++
+			if opp.Stage == "lead" {
+				res.Leads++
+			}
+			if opp.Stage == "closed_won" {
+				res.ClosedWon++
+				res.Revenue = res.Revenue.Add(money.NewFromFloat(opp.Amount))
+			}
+			if opp.Stage != "closed_won" && opp.Stage != "closed_lost" {
+				res.Pipeline = res.Pipeline.Add(money.NewFromFloat(opp.Amount))
+			}
+		}
+		b.Results = append(b.Results, res)
+		b.Lineage = append(b.Lineage, lineage)
+	}
+	return nil
+}
+
+
+// computeStage calculates the derived metrics of every result
+func computeStage(b *Batch) error {
+	metricSet, err := loadMetricSet()
+	if err != nil {
+		return err
+	}
+	for i := range b.Results {
+		metricSet.Apply(&b.Results[i])
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package etl
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"goetl/internal/db"
+	"goetl/internal/models"
+	"goetl/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	stagingAdCollection          = "staging_ads"
+	stagingOpportunityCollection = "staging_opportunities"
+)
+
+
+// stageRecords reports whether runs upsert their normalized records into the staging collections,
+// disabled with STAGE_RECORDS=false
+func stageRecords() bool {
+	return utils.Getenv("STAGE_RECORDS") != "false"
+}
+
+
+// SaveStaging upserts the normalized records of a run into the staging collections, ads by (date, channel,
+// campaign_id) and opportunities by (opportunity_id, created_at), LOAD_BATCH_SIZE records per bulk write
+func SaveStaging(runID string, ads []models.AdPerformance, opportunities []models.Opportunity) error {
+	database, err := db.GetDatabase()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	now := time.Now().UTC()
+	writes := make([]mongo.WriteModel, 0, len(ads))
+	for _, ad := range ads {
+		filter := bson.M{"date": ad.Date, "channel": ad.Channel, "campaignid": ad.CampaignID}
+		doc := models.StagedAd{AdPerformance: ad, RunID: runID, StagedAt: now}
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true))
+	}
+	if err := bulkUpsert(ctx, database.Collection(stagingAdCollection), writes); err != nil {
+		return fmt.Errorf("staging ads: %w", err)
+	}
+	writes = make([]mongo.WriteModel, 0, len(opportunities))
+	for _, opp := range opportunities {
+		filter := bson.M{"opportunityid": opp.OpportunityID, "createdat": opp.CreatedAt}
+		doc := models.StagedOpportunity{Opportunity: opp, RunID: runID, StagedAt: now}
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true))
+	}
+	if err := bulkUpsert(ctx, database.Collection(stagingOpportunityCollection), writes); err != nil {
+		return fmt.Errorf("staging opportunities: %w", err)
+	}
+	return nil
+}
+
+
+// bulkUpsert runs writes as unordered bulk writes of LOAD_BATCH_SIZE models
+func bulkUpsert(ctx context.Context, collection *mongo.Collection, writes []mongo.WriteModel) error {
+	size := loadBatchSize()
+	for start := 0; start < len(writes); start += size {
+		end := start + size
+		if end > len(writes) {
+			end = len(writes)
+		}
+		if _, err := collection.BulkWrite(ctx, writes[start:end], options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+
+// GetStaging returns the staged ads dated on or after since and the staged opportunities created on or after
+// since, every staged record when since is empty
+func GetStaging(since string) ([]models.AdPerformance, []models.Opportunity, error) {
+	database, err := db.GetDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	adFilter, oppFilter := bson.M{}, bson.M{}
+	if since != "" {
+		adFilter["date"] = bson.M{"$gte": since}
+		oppFilter["createdat"] = bson.M{"$gte": since}
+	}
+	sort := options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}})
+	cursor, err := database.Collection(stagingAdCollection).Find(ctx, adFilter, sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stagedAds []models.StagedAd
+	if err := cursor.All(ctx, &stagedAds); err != nil {
+		return nil, nil, err
+	}
+	sort = options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}, {Key: "opportunityid", Value: 1}})
+	cursor, err = database.Collection(stagingOpportunityCollection).Find(ctx, oppFilter, sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stagedOpportunities []models.StagedOpportunity
+	if err := cursor.All(ctx, &stagedOpportunities); err != nil {
+		return nil, nil, err
+	}
+	ads := make([]models.AdPerformance, len(stagedAds))
+	for i, s := range stagedAds {
+		ads[i] = s.AdPerformance
+	}
+	opportunities := make([]models.Opportunity, len(stagedOpportunities))
+	for i, s := range stagedOpportunities {
+		opportunities[i] = s.Opportunity
+	}
+	return ads, opportunities, nil
+}
+
+
+// eraseStaging deletes the staged opportunities of the contact and returns them
+func eraseStaging(hash string, receipt *models.ErasureReceipt) ([]models.Opportunity, error) {
+	collection, ctx, cancel := db.GetCollection(stagingOpportunityCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil, ErrDatabaseUnavailable
+	}
+	defer cancel()
+	filter := bson.M{"contactemailhash": hash}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var staged []models.StagedOpportunity
+	if err := cursor.All(ctx, &staged); err != nil {
+		return nil, err
+	}
+	if _, err := collection.DeleteMany(ctx, filter); err != nil {
+		return nil, err
+	}
+	receipt.Staged = len(staged)
+	opportunities := make([]models.Opportunity, len(staged))
+	for i, s := range staged {
+		opportunities[i] = s.Opportunity
+	}
+	return opportunities, nil
+}
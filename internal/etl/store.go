@@ -1,94 +1,454 @@
 package etl
 
 import (
+	"context"
 	"log"
+	"time"
 	"goetl/internal/models"
 	"goetl/internal/db"
+	"goetl/internal/store"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const etlCollection = "etl_results"
+const (
+	etlCollection           = store.MongoCollection
+	resultVersionCollection = store.MongoVersionsCollection
+	qualityCollection     = "quality_reports"
+	violationCollection   = "validation_violations"
+	deadLetterCollection  = "dead_letters"
+	rawPayloadCollection  = "raw_payloads"
+	restatementCollection = "restatements"
+	anomalyCollection     = "anomalies"
+
+	storeTimeout   = 10 * time.Second
+	replaceTimeout = 5 * time.Minute
+)
 
 
-// SaveResult persists an ETLResult in MongoDB
-func SaveResult(res models.ETLResult) {
-	collection, ctx, cancel := db.GetCollection(etlCollection)
+// SaveQualityReport persists the data quality report of a run in MongoDB
+func SaveQualityReport(report *models.QualityReport) {
+	collection, ctx, cancel := db.GetCollection(qualityCollection)
 	if collection == nil || ctx == nil || cancel == nil {
 		return
 	}
-	if cancel != nil {
-		defer cancel()
-	}
-	filter := bson.M{"date": res.Date, "channel": res.Channel, "campaignid": res.CampaignID}
-	update := bson.M{"$set": res}
+	defer cancel()
+	filter := bson.M{"runid": report.RunID}
+	update := bson.M{"$set": report}
 	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
 	if err != nil {
-		log.Printf("Failed to upsert ETLResult: %v", err)
+		log.Printf("Failed to upsert QualityReport: %v", err)
+	}
+}
+
+
+// GetQualityReport returns the data quality report of a run, or nil if it does not exist
+func GetQualityReport(runID string) *models.QualityReport {
+	collection, ctx, cancel := db.GetCollection(qualityCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil
+	}
+	defer cancel()
+	var report models.QualityReport
+	if err := collection.FindOne(ctx, bson.M{"runid": runID}).Decode(&report); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Failed to fetch QualityReport: %v", err)
+		}
+		return nil
+	}
+	return &report
+}
+
+
+// SaveViolations persists the validation violations of a run in MongoDB, stamped with the time they are saved
+func SaveViolations(violations []models.Violation) {
+	now := time.Now().UTC()
+	docs := make([]interface{}, 0, len(violations))
+	for _, v := range violations {
+		v.CreatedAt = now
+		docs = append(docs, v)
+	}
+	insertMany(violationCollection, docs)
+}
+
+
+// SaveDeadLetters persists the records discarded by a run in MongoDB
+func SaveDeadLetters(deadLetters []models.DeadLetter) {
+	if len(deadLetters) == 0 {
+		return
+	}
+	docs := make([]interface{}, 0, len(deadLetters))
+	for _, d := range deadLetters {
+		docs = append(docs, d)
+	}
+	insertMany(deadLetterCollection, docs)
+}
+
+
+// SaveRawPayloads persists the archived extraction payloads of a run in MongoDB
+func SaveRawPayloads(payloads []models.RawPayload) {
+	docs := make([]interface{}, 0, len(payloads))
+	for _, p := range payloads {
+		docs = append(docs, p)
+	}
+	insertMany(rawPayloadCollection, docs)
+}
+
+
+// SaveRestatements persists the result changes detected by a run in MongoDB
+func SaveRestatements(restatements []models.Restatement) {
+	docs := make([]interface{}, 0, len(restatements))
+	for _, r := range restatements {
+		docs = append(docs, r)
+	}
+	insertMany(restatementCollection, docs)
+}
+
+
+// GetRestatements returns the result changes recorded by a run, paginated, with the count of all of them
+func GetRestatements(runID string, limit, offset int) ([]models.Restatement, int) {
+	collection, ctx, cancel := db.GetCollection(restatementCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil, 0
+	}
+	defer cancel()
+	filter := bson.M{"runid": runID}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to count Restatements: %v", err)
+		return nil, 0
+	}
+	opts := options.Find()
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Failed to fetch Restatements: %v", err)
+		return nil, 0
+	}
+	defer cursor.Close(ctx)
+	var restatements []models.Restatement
+	if err := cursor.All(ctx, &restatements); err != nil {
+		log.Printf("Failed to decode Restatements: %v", err)
+	}
+	return restatements, int(total)
+}
+
+
+// SaveAnomalies replaces in MongoDB the anomalies of the evaluated results, keyed by (date, channel,
+// campaignid), with anomalies, upserted by (date, channel, campaignid, metric). Reprocessing a date does not
+// flag it twice, and a day no longer anomalous after a restatement loses its anomalies.
+func SaveAnomalies(evaluated []models.ETLResult, anomalies []models.Anomaly) {
+	if len(evaluated) == 0 {
+		return
+	}
+	collection, ctx, cancel := db.GetCollection(anomalyCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return
+	}
+	defer cancel()
+	writes := make([]mongo.WriteModel, 0, len(evaluated)+len(anomalies))
+	for _, res := range evaluated {
+		filter := bson.M{"date": res.Date, "channel": res.Channel, "campaignid": res.CampaignID}
+		writes = append(writes, mongo.NewDeleteManyModel().SetFilter(filter))
+	}
+	for _, a := range anomalies {
+		filter := bson.M{"date": a.Date, "channel": a.Channel, "campaignid": a.CampaignID, "metric": a.Metric}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.M{"$set": a}).SetUpsert(true))
+	}
+	// ordered, so the deletes run before the upserts
+	if _, err := collection.BulkWrite(ctx, writes); err != nil {
+		log.Printf("Failed to save Anomalies: %v", err)
 	}
 }
 
 
-// GetResultsByCampaign returns ETL results filtered by utm_campaign, date range, and paginated
-func GetResultsByCampaign(dateStart, dateEnd, utmCampaign string, limit int, offset int) []models.ETLResult {
-       return getResults(dateStart, dateEnd, map[string]string{"utmcampaign": utmCampaign}, limit, offset)
+// GetAnomalies returns anomalies filtered by channel and date range, paginated, with the count of every
+// anomaly matching the filters
+func GetAnomalies(dateStart, dateEnd, channel string, limit, offset int) ([]models.Anomaly, int) {
+	collection, ctx, cancel := db.GetCollection(anomalyCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil, 0
+	}
+	defer cancel()
+	filter := dateRangeFilter(dateStart, dateEnd)
+	if channel != "" {
+		filter["channel"] = channel
+	}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to count Anomalies: %v", err)
+		return nil, 0
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Failed to fetch Anomalies: %v", err)
+		return nil, 0
+	}
+	defer cursor.Close(ctx)
+	var anomalies []models.Anomaly
+	if err := cursor.All(ctx, &anomalies); err != nil {
+		log.Printf("Failed to decode Anomalies: %v", err)
+	}
+	return anomalies, int(total)
 }
 
 
-// GetResultsByChannel returns ETL results filtered by channel, date range, and paginated
-func GetResultsByChannel(dateStart, dateEnd, channel string, limit, offset int) []models.ETLResult {
-	return getResults(dateStart, dateEnd, map[string]string{"channel": channel}, limit, offset)
+// dateRangeFilter filters the date field between dateStart and dateEnd, either of which may be empty
+func dateRangeFilter(dateStart, dateEnd string) bson.M {
+	filter := bson.M{}
+	if dateStart != "" && dateEnd != "" {
+		filter["date"] = bson.M{"$gte": dateStart, "$lte": dateEnd}
+	} else if dateStart != "" {
+		filter["date"] = bson.M{"$gte": dateStart}
+	} else if dateEnd != "" {
+		filter["date"] = bson.M{"$lte": dateEnd}
+	}
+	return filter
 }
 
 
-// getResults is a shared helper for channel/campaign queries with pagination by date range and limit/offset
-func getResults(dateStart, dateEnd string, filterFields map[string]string, limit, offset int) []models.ETLResult {
-       collection, ctx, cancel := db.GetCollection(etlCollection)
-       if collection == nil || ctx == nil || cancel == nil {
-	       return nil
-       }
-       defer cancel()
-       filter := bson.M{}
-       for k, v := range filterFields {
-	       if v != "" {
-		       filter[k] = v
-	       }
-       }
-       // filter collection by date range
-       if dateStart != "" && dateEnd != "" {
-	       filter["date"] = bson.M{"$gte": dateStart, "$lte": dateEnd}
-       } else if dateStart != "" {
-	       filter["date"] = bson.M{"$gte": dateStart}
-       } else if dateEnd != "" {
-	       filter["date"] = bson.M{"$lte": dateEnd}
-       }
+// insertMany inserts docs into a collection, logging failures
+func insertMany(collectionName string, docs []interface{}) {
+	if len(docs) == 0 {
+		return
+	}
+	collection, ctx, cancel := db.GetCollection(collectionName)
+	if collection == nil || ctx == nil || cancel == nil {
+		return
+	}
+	defer cancel()
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		log.Printf("Failed to insert into %s: %v", collectionName, err)
+	}
+}
 
-       opts := options.Find()
-       if limit > 0 {
-	       opts.SetLimit(int64(limit))
-       }
-       if offset > 0 {
-	       opts.SetSkip(int64(offset))
-       }
 
+// GetViolations returns the validation violations of a run, paginated, with the count of all of them
+func GetViolations(runID string, limit, offset int) ([]models.Violation, int) {
+	collection, ctx, cancel := db.GetCollection(violationCollection)
+	if collection == nil || ctx == nil || cancel == nil {
+		return nil, 0
+	}
+	defer cancel()
+	filter := bson.M{"runid": runID}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to count Violations: %v", err)
+		return nil, 0
+	}
+	opts := options.Find()
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
 	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Failed to fetch Violations: %v", err)
+		return nil, 0
+	}
+	defer cursor.Close(ctx)
+	var violations []models.Violation
+	if err := cursor.All(ctx, &violations); err != nil {
+		log.Printf("Failed to decode Violations: %v", err)
+	}
+	return violations, int(total)
+}
+
+
+// GetResultsByCampaign returns a page of the ETL results filtered by utm_campaign and date range, ordered by
+// date, channel and campaign id. The page starts after cursor, a next_cursor of an earlier page, when given.
+func GetResultsByCampaign(dateStart, dateEnd, utmCampaign string, limit, offset int, cursor string) (models.ResultPage, error) {
+	return queryPage(store.Query{From: dateStart, To: dateEnd, UTMCampaign: utmCampaign, Limit: limit, Offset: offset}, cursor)
+}
+
+
+// GetResultsByChannel returns a page of the ETL results filtered by channel and date range, ordered by date,
+// channel and campaign id. The page starts after cursor, a next_cursor of an earlier page, when given.
+func GetResultsByChannel(dateStart, dateEnd, channel string, limit, offset int, cursor string) (models.ResultPage, error) {
+	return queryPage(store.Query{From: dateStart, To: dateEnd, Channel: channel, Limit: limit, Offset: offset}, cursor)
+}
+
+
+// EachResultByCampaign calls fn with the results of GetResultsByCampaign, streamed from the result store
+func EachResultByCampaign(dateStart, dateEnd, utmCampaign string, limit, offset int, cursor string, fn func(models.ETLResult) error) error {
+	return eachResult(store.Query{From: dateStart, To: dateEnd, UTMCampaign: utmCampaign, Limit: limit, Offset: offset}, cursor, fn)
+}
+
+
+// EachResultByChannel calls fn with the results of GetResultsByChannel, streamed from the result store
+func EachResultByChannel(dateStart, dateEnd, channel string, limit, offset int, cursor string, fn func(models.ETLResult) error) error {
+	return eachResult(store.Query{From: dateStart, To: dateEnd, Channel: channel, Limit: limit, Offset: offset}, cursor, fn)
+}
+
+
+// eachResult streams the results of q after cursor to fn. Exports may be large, so the store gets replaceTimeout.
+func eachResult(q store.Query, cursor string, fn func(models.ETLResult) error) error {
+	q, err := withCursor(q, cursor)
+	if err != nil {
+		return err
+	}
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replaceTimeout)
+	defer cancel()
+	return resultStore.Each(ctx, q, fn)
+}
+
+
+// ErrInvalidCursor is returned for a cursor that is not a next_cursor of the metrics endpoints
+var ErrInvalidCursor = store.ErrInvalidCursor
+
+
+// MetricNames returns the names of the derived metrics, in evaluation order
+func MetricNames() ([]string, error) {
+	metricSet, err := loadMetricSet()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(metricSet.Metrics()))
+	for _, m := range metricSet.Metrics() {
+		names = append(names, m.Name)
+	}
+	return names, nil
+}
+
+
+// GetResultsByCampaignAsOf returns what GetResultsByCampaign returned at asOf, rebuilt from the result versions
+func GetResultsByCampaignAsOf(dateStart, dateEnd, utmCampaign string, limit, offset int, cursor string, asOf time.Time) (models.ResultPage, error) {
+	return queryPageAsOf(store.Query{From: dateStart, To: dateEnd, UTMCampaign: utmCampaign, Limit: limit, Offset: offset}, cursor, asOf)
+}
+
+
+// GetResultsByChannelAsOf returns what GetResultsByChannel returned at asOf, rebuilt from the result versions
+func GetResultsByChannelAsOf(dateStart, dateEnd, channel string, limit, offset int, cursor string, asOf time.Time) (models.ResultPage, error) {
+	return queryPageAsOf(store.Query{From: dateStart, To: dateEnd, Channel: channel, Limit: limit, Offset: offset}, cursor, asOf)
+}
+
+
+// RunLoadedAt returns when run runID last wrote results, the time the results looked like the run left them.
+// ErrRunNotFound is returned if the run never wrote results.
+func RunLoadedAt(runID string) (time.Time, error) {
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return time.Time{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	_, last, err := runWrites(ctx, resultStore, runID)
+	return last, err
+}
+
+
+// queryPage returns the page of q after cursor, with the count of every result matching q
+func queryPage(q store.Query, cursor string) (models.ResultPage, error) {
+	q, err := withCursor(q, cursor)
+	if err != nil {
+		return models.ResultPage{}, err
+	}
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return models.ResultPage{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	total, err := resultStore.Count(ctx, q)
+	if err != nil {
+		return models.ResultPage{}, err
+	}
+	results, err := resultStore.Query(ctx, peek(q))
+	if err != nil {
+		return models.ResultPage{}, err
+	}
+	return newPage(results, total, q.Limit), nil
+}
+
+
+// queryPageAsOf returns the page of q after cursor of the results as they were at asOf. They are rebuilt
+// whole from the versions, which also gives their count.
+func queryPageAsOf(q store.Query, cursor string, asOf time.Time) (models.ResultPage, error) {
+	q, err := withCursor(q, cursor)
+	if err != nil {
+		return models.ResultPage{}, err
+	}
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return models.ResultPage{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	filter := q
+	filter.Limit, filter.Offset, filter.After = 0, 0, nil
+	all, err := store.QueryAsOf(ctx, resultStore, filter, asOf)
+	if err != nil {
+		return models.ResultPage{}, err
+	}
+	return newPage(store.Paginate(all, peek(q)), len(all), q.Limit), nil
+}
+
+
+// withCursor starts q after the result a next_cursor points to, from the first result when cursor is empty
+func withCursor(q store.Query, cursor string) (store.Query, error) {
+	if cursor == "" {
+		return q, nil
+	}
+	after, err := store.DecodeCursor(cursor)
+	if err != nil {
+		return q, err
+	}
+	q.After = &after
+	return q, nil
+}
+
+
+// peek asks for one result more than the page holds, telling whether another page follows
+func peek(q store.Query) store.Query {
+	if q.Limit > 0 {
+		q.Limit++
+	}
+	return q
+}
+
+
+// newPage trims the results of a peeked query to limit, with the cursor of the next page when one follows
+func newPage(results []models.ETLResult, total, limit int) models.ResultPage {
+	page := models.ResultPage{Total: total, Results: results}
+	if limit > 0 && len(results) > limit {
+		page.Results = results[:limit]
+		page.NextCursor = store.CursorOf(results[limit-1]).Encode()
+	}
+	return page
+}
+
+
+// queryResults is a shared helper for result queries, logging failures
+func queryResults(q store.Query) []models.ETLResult {
+	resultStore, err := loadResultStore()
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	results, err := resultStore.Query(ctx, q)
 	if err != nil {
 		log.Printf("Failed to fetch filtered ETLResults: %v", err)
 		return nil
-       }
-       defer cursor.Close(ctx)
-
-       var results []models.ETLResult
-       for cursor.Next(ctx) {
-	       var res models.ETLResult
-	       if err := cursor.Decode(&res); err != nil {
-			log.Printf("Failed to decode ETLResult: %v", err)
-		       continue
-	       }
-	       results = append(results, res)
-       }
-       if err := cursor.Err(); err != nil {
-		log.Printf("Cursor error: %v", err)
-       }
-       return results
+	}
+	return results
 }
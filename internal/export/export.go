@@ -0,0 +1,140 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"goetl/internal/models"
+)
+
+// Formats of the metrics endpoints
+const (
+	JSON   = "json"
+	CSV    = "csv"
+	NDJSON = "ndjson"
+)
+
+// ContentTypes maps the streamed formats to their media type
+var ContentTypes = map[string]string{
+	CSV:    "text/csv; charset=utf-8",
+	NDJSON: "application/x-ndjson",
+}
+
+// mediaTypes maps the Accept media types to their format
+var mediaTypes = map[string]string{
+	"application/json":     JSON,
+	"text/csv":             CSV,
+	"application/x-ndjson": NDJSON,
+	"application/ndjson":   NDJSON,
+	"application/jsonl":    NDJSON,
+}
+
+// Columns are the fixed CSV columns, followed by the derived metrics that are not one of them
+var Columns = []string{
+	"date", "channel", "campaign_id", "utm_campaign", "utm_source", "utm_medium",
+	"clicks", "impressions", "cost", "leads", "opportunities", "closed_won", "revenue", "pipeline",
+	"cpc", "cpa", "cvr_lead_to_opp", "cvr_opp_to_won", "roas", "run_id", "transform_version",
+}
+
+// Negotiate returns the format asked with the format param, or else with the Accept header, JSON when neither
+// names one. The first supported media type of Accept wins, ignoring quality values.
+func Negotiate(format, accept string) (string, error) {
+	if format != "" {
+		switch f := strings.ToLower(format); f {
+		case JSON, CSV, NDJSON:
+			return f, nil
+		default:
+			return "", fmt.Errorf("unknown format %q, must be %s, %s or %s", format, JSON, CSV, NDJSON)
+		}
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if f, ok := mediaTypes[strings.ToLower(strings.TrimSpace(mediaType))]; ok {
+			return f, nil
+		}
+	}
+	return JSON, nil
+}
+
+// Writer encodes results one at a time
+type Writer interface {
+	Write(res models.ETLResult) error
+	// Close flushes the buffered output
+	Close() error
+}
+
+// NewWriter returns the writer of a streamed format. The CSV header is written at once, with a column per
+// metric name not already among Columns.
+func NewWriter(format string, w io.Writer, metrics []string) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w, metrics)
+	case NDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("format %q is not streamed", format)
+	}
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+// Write encodes res as one JSON line
+func (n *ndjsonWriter) Write(res models.ETLResult) error {
+	return n.enc.Encode(res)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	metrics []string
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, metrics []string) (*csvWriter, error) {
+	fixed := make(map[string]bool, len(Columns))
+	for _, c := range Columns {
+		fixed[c] = true
+	}
+	c := &csvWriter{w: csv.NewWriter(w)}
+	header := append([]string{}, Columns...)
+	for _, name := range metrics {
+		if !fixed[name] {
+			c.metrics = append(c.metrics, name)
+			header = append(header, name)
+		}
+	}
+	c.record = make([]string, len(header))
+	return c, c.w.Write(header)
+}
+
+// Write encodes res as one record, money and ratios as exact decimals. A metric res lacks is left empty.
+func (c *csvWriter) Write(res models.ETLResult) error {
+	record := append(c.record[:0],
+		res.Date, res.Channel, res.CampaignID, res.UTMCampaign, res.UTMSource, res.UTMMedium,
+		strconv.Itoa(res.Clicks), strconv.Itoa(res.Impressions), res.Cost.String(), strconv.Itoa(res.Leads),
+		strconv.Itoa(res.Opportunities), strconv.Itoa(res.ClosedWon), res.Revenue.String(), res.Pipeline.String(),
+		res.CPC.String(), res.CPA.String(), res.CVRLeadToOpp.String(), res.CVROppToWon.String(), res.ROAS.String(),
+		res.RunID, res.TransformVersion,
+	)
+	for _, name := range c.metrics {
+		value := ""
+		if v, ok := res.Metrics[name]; ok {
+			value = v.String()
+		}
+		record = append(record, value)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
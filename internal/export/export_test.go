@@ -0,0 +1,89 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		format, accept, want string
+	}{
+		{"", "", JSON},
+		{"", "*/*", JSON},
+		{"", "text/csv", CSV},
+		{"", "application/x-ndjson", NDJSON},
+		{"", "text/html, text/csv;q=0.9, application/json;q=0.8", CSV},
+		{"NDJSON", "text/csv", NDJSON},
+	}
+	for _, c := range cases {
+		got, err := Negotiate(c.format, c.accept)
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, got, "format=%q accept=%q", c.format, c.accept)
+	}
+	_, err := Negotiate("xml", "")
+	assert.Error(t, err)
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(CSV, &buf, []string{"cpc", "cpm"})
+	assert.NoError(t, err)
+	assert.NoError(t, w.Write(models.ETLResult{
+		Date:        "2025-09-01",
+		Channel:     "google_ads",
+		CampaignID:  "C-1",
+		UTMCampaign: "back, to school",
+		Clicks:      10,
+		Cost:        money.RequireFromString("12.50"),
+		CPC:         money.RequireFromString("1.25"),
+		Metrics:     map[string]money.Decimal{"cpm": money.RequireFromString("3.1")},
+	}))
+	assert.NoError(t, w.Write(models.ETLResult{Date: "2025-09-02", Channel: "google_ads", CampaignID: "C-2"}))
+	assert.NoError(t, w.Close())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 3) {
+		header := records[0]
+		// cpc is already a fixed column
+		assert.Equal(t, len(Columns)+1, len(header))
+		assert.Equal(t, "cpm", header[len(header)-1])
+		assert.Equal(t, "back, to school", records[1][3])
+		assert.Equal(t, "12.5", records[1][8])
+		assert.Equal(t, "3.1", records[1][len(header)-1])
+		assert.Equal(t, "", records[2][len(header)-1])
+	}
+}
+
+func TestCSVWriter_HeaderWithoutRows(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(CSV, &buf, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, strings.Join(Columns, ",")+"\n", buf.String())
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(NDJSON, &buf, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Write(models.ETLResult{Date: "2025-09-01", CampaignID: "C-1", Cost: money.RequireFromString("0.1")}))
+	assert.NoError(t, w.Write(models.ETLResult{Date: "2025-09-02", CampaignID: "C-2"}))
+	assert.NoError(t, w.Close())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		var res models.ETLResult
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &res))
+		assert.Equal(t, "C-1", res.CampaignID)
+		assert.Contains(t, lines[0], `"cost":0.1`)
+	}
+	_, err = NewWriter(JSON, &buf, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,270 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"goetl/internal/metrics"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"github.com/xuri/excelize/v2"
+)
+
+// XLSXContentType is the media type of the workbook report
+const XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// DefaultCurrencyFormat is the Excel number format of money amounts when none is configured
+const DefaultCurrencyFormat = `"$"#,##0.00`
+
+// Sheets of the workbook report
+const (
+	SummarySheet   = "Summary"
+	CampaignsSheet = "Campaigns"
+	DailySheet     = "Daily"
+)
+
+// Report is the content of the workbook report: the results of a date range summed by channel, by campaign
+// and by day, with the derived metrics evaluated on the sums
+type Report struct {
+	From        string
+	To          string
+	GeneratedAt time.Time
+	// CurrencyFormat is the Excel number format of money amounts, DefaultCurrencyFormat when empty
+	CurrencyFormat string
+	Metrics        []metrics.Metric
+	Channels       []ReportRow
+	Campaigns      []ReportRow
+	Days           []ReportRow
+	Total          ReportRow
+}
+
+// ReportRow is a group of results with its derived metrics
+type ReportRow struct {
+	models.ResultAggregate
+	Metrics map[string]money.Decimal
+}
+
+// cell kinds, each with its number format
+const (
+	textCell = iota
+	integerCell
+	currencyCell
+	percentCell
+	decimalCell
+)
+
+// column is a sheet column with how its cells are read from a row and formatted
+type column struct {
+	header string
+	kind   int
+	places int
+	value  func(ReportRow) interface{}
+}
+
+// WriteXLSX writes the report as a workbook with a summary sheet by channel, a campaign detail sheet and a
+// daily trend sheet with a chart of cost and revenue
+func WriteXLSX(w io.Writer, r *Report) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	styles := &styleSet{file: f, currency: r.CurrencyFormat, ids: map[string]int{}}
+	if styles.currency == "" {
+		styles.currency = DefaultCurrencyFormat
+	}
+	if err := f.SetSheetName("Sheet1", SummarySheet); err != nil {
+		return err
+	}
+	for _, name := range []string{CampaignsSheet, DailySheet} {
+		if _, err := f.NewSheet(name); err != nil {
+			return err
+		}
+	}
+
+	channel := column{header: "Channel", kind: textCell, value: func(row ReportRow) interface{} { return row.Channel }}
+	campaign := column{header: "Campaign", kind: textCell, value: func(row ReportRow) interface{} { return row.CampaignID }}
+	date := column{header: "Date", kind: textCell, value: func(row ReportRow) interface{} { return row.Date }}
+	measures := measureColumns(r.Metrics)
+
+	total := r.Total
+	total.Channel = "Total"
+	if err := writeSheet(f, styles, SummarySheet, append([]column{channel}, measures...), r.Channels, &total); err != nil {
+		return err
+	}
+	if err := writeSheet(f, styles, CampaignsSheet, append([]column{channel, campaign}, measures...), r.Campaigns, nil); err != nil {
+		return err
+	}
+	daily := append([]column{date}, measures...)
+	if err := writeSheet(f, styles, DailySheet, daily, r.Days, nil); err != nil {
+		return err
+	}
+	if len(r.Days) > 0 {
+		if err := addTrendChart(f, daily, len(r.Days)); err != nil {
+			return err
+		}
+	}
+	err := f.SetDocProps(&excelize.DocProperties{
+		Title:   fmt.Sprintf("Results %s to %s", r.From, r.To),
+		Creator: "goetl",
+		Created: r.GeneratedAt.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	f.SetActiveSheet(0)
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// measureColumns returns the summed base fields followed by the derived metrics. The default cost ratios
+// are money, the default conversion rates percentages, and other metrics decimals with their precision.
+func measureColumns(metricList []metrics.Metric) []column {
+	columns := []column{
+		{header: "Rows", kind: integerCell, value: func(row ReportRow) interface{} { return row.Rows }},
+		{header: "Clicks", kind: integerCell, value: func(row ReportRow) interface{} { return row.Clicks }},
+		{header: "Impressions", kind: integerCell, value: func(row ReportRow) interface{} { return row.Impressions }},
+		{header: "Cost", kind: currencyCell, value: func(row ReportRow) interface{} { return row.Cost.Float64() }},
+		{header: "Leads", kind: integerCell, value: func(row ReportRow) interface{} { return row.Leads }},
+		{header: "Opportunities", kind: integerCell, value: func(row ReportRow) interface{} { return row.Opportunities }},
+		{header: "Closed Won", kind: integerCell, value: func(row ReportRow) interface{} { return row.ClosedWon }},
+		{header: "Revenue", kind: currencyCell, value: func(row ReportRow) interface{} { return row.Revenue.Float64() }},
+		{header: "Pipeline", kind: currencyCell, value: func(row ReportRow) interface{} { return row.Pipeline.Float64() }},
+	}
+	for _, m := range metricList {
+		name := m.Name
+		col := column{header: strings.ToUpper(name), kind: decimalCell, places: m.Precision}
+		switch {
+		case name == "cpc" || name == "cpa":
+			col.kind = currencyCell
+		case strings.HasPrefix(name, "cvr_"):
+			col.kind = percentCell
+		}
+		col.value = func(row ReportRow) interface{} { return row.Metrics[name].Float64() }
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// writeSheet writes a header row, one row per group and an optional bold total row, with the header frozen
+func writeSheet(f *excelize.File, styles *styleSet, sheet string, columns []column, rows []ReportRow, total *ReportRow) error {
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col.header
+	}
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+	last, err := excelize.CoordinatesToCellName(len(columns), 1)
+	if err != nil {
+		return err
+	}
+	style, err := styles.get(textCell, 0, true)
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellStyle(sheet, "A1", last, style); err != nil {
+		return err
+	}
+	if total != nil {
+		rows = append(append([]ReportRow{}, rows...), *total)
+	}
+	for i, row := range rows {
+		bold := total != nil && i == len(rows)-1
+		for j, col := range columns {
+			cell, err := excelize.CoordinatesToCellName(j+1, i+2)
+			if err != nil {
+				return err
+			}
+			if err := f.SetCellValue(sheet, cell, col.value(row)); err != nil {
+				return err
+			}
+			style, err := styles.get(col.kind, col.places, bold)
+			if err != nil {
+				return err
+			}
+			if err := f.SetCellStyle(sheet, cell, cell, style); err != nil {
+				return err
+			}
+		}
+	}
+	for j, col := range columns {
+		name, err := excelize.ColumnNumberToName(j + 1)
+		if err != nil {
+			return err
+		}
+		width := 14.0
+		if col.kind == textCell {
+			width = 20
+		}
+		if err := f.SetColWidth(sheet, name, name, width); err != nil {
+			return err
+		}
+	}
+	return f.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+}
+
+// addTrendChart adds a line chart of the daily cost and revenue right of the daily table
+func addTrendChart(f *excelize.File, columns []column, days int) error {
+	anchor, err := excelize.CoordinatesToCellName(len(columns)+2, 2)
+	if err != nil {
+		return err
+	}
+	var series []excelize.ChartSeries
+	for i, col := range columns {
+		if col.header != "Cost" && col.header != "Revenue" {
+			continue
+		}
+		name, err := excelize.ColumnNumberToName(i + 1)
+		if err != nil {
+			return err
+		}
+		series = append(series, excelize.ChartSeries{
+			Name:       fmt.Sprintf("%s!$%s$1", DailySheet, name),
+			Categories: fmt.Sprintf("%s!$A$2:$A$%d", DailySheet, days+1),
+			Values:     fmt.Sprintf("%s!$%s$2:$%s$%d", DailySheet, name, name, days+1),
+		})
+	}
+	return f.AddChart(DailySheet, anchor, &excelize.Chart{
+		Type:      excelize.Line,
+		Series:    series,
+		Title:     excelize.ChartTitle{Paragraph: []excelize.RichTextRun{{Text: "Daily cost and revenue"}}},
+		Legend:    excelize.ChartLegend{Position: "bottom"},
+		Dimension: excelize.ChartDimension{Width: 720, Height: 360},
+	})
+}
+
+// styleSet creates the cell styles of the workbook once per kind, precision and weight
+type styleSet struct {
+	file     *excelize.File
+	currency string
+	ids      map[string]int
+}
+
+func (s *styleSet) get(kind, places int, bold bool) (int, error) {
+	key := fmt.Sprintf("%d:%d:%t", kind, places, bold)
+	if id, ok := s.ids[key]; ok {
+		return id, nil
+	}
+	style := &excelize.Style{Font: &excelize.Font{Bold: bold}}
+	switch kind {
+	case integerCell:
+		// #,##0
+		style.NumFmt = 3
+	case currencyCell:
+		style.CustomNumFmt = &s.currency
+	case percentCell:
+		// 0.00%
+		style.NumFmt = 10
+	case decimalCell:
+		format := "#,##0"
+		if places > 0 {
+			format += "." + strings.Repeat("0", places)
+		}
+		style.CustomNumFmt = &format
+	}
+	id, err := s.file.NewStyle(style)
+	if err != nil {
+		return 0, err
+	}
+	s.ids[key] = id
+	return id, nil
+}
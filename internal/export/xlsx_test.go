@@ -0,0 +1,75 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+	"goetl/internal/metrics"
+	"goetl/internal/models"
+	"goetl/internal/money"
+)
+
+func reportRow(set *metrics.Set, agg models.ResultAggregate) ReportRow {
+	return ReportRow{ResultAggregate: agg, Metrics: set.Evaluate(metrics.AggregateFields(agg))}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	set, err := metrics.NewSet(metrics.Defaults)
+	assert.NoError(t, err)
+	google := models.ResultAggregate{Channel: "google_ads", Rows: 2, Clicks: 1200, Cost: money.RequireFromString("300.5"), Leads: 10, Opportunities: 4, Revenue: money.NewFromInt(900)}
+	meta := models.ResultAggregate{Channel: "meta_ads", Rows: 1, Clicks: 50, Cost: money.NewFromInt(25)}
+	total := models.ResultAggregate{Rows: 3, Clicks: 1250, Cost: money.RequireFromString("325.5"), Leads: 10, Opportunities: 4, Revenue: money.NewFromInt(900)}
+	day1, day2 := google, meta
+	day1.Channel, day1.Date = "", "2025-09-01"
+	day2.Channel, day2.Date = "", "2025-09-02"
+	campaign := google
+	campaign.CampaignID = "C-1"
+	report := &Report{
+		From:           "2025-09-01",
+		To:             "2025-09-02",
+		GeneratedAt:    time.Date(2025, 9, 3, 0, 0, 0, 0, time.UTC),
+		CurrencyFormat: `#,##0.00 "€"`,
+		Metrics:        set.Metrics(),
+		Channels:       []ReportRow{reportRow(set, google), reportRow(set, meta)},
+		Campaigns:      []ReportRow{reportRow(set, campaign)},
+		Days:           []ReportRow{reportRow(set, day1), reportRow(set, day2)},
+		Total:          reportRow(set, total),
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, WriteXLSX(&buf, report))
+
+	f, err := excelize.OpenReader(&buf)
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, []string{SummarySheet, CampaignsSheet, DailySheet}, f.GetSheetList())
+
+	rows, err := f.GetRows(SummarySheet, excelize.Options{RawCellValue: true})
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 4) {
+		assert.Equal(t, []string{"Channel", "Rows", "Clicks", "Impressions", "Cost"}, rows[0][:5])
+		assert.Equal(t, "CPC", rows[0][10])
+		assert.Equal(t, "google_ads", rows[1][0])
+		assert.Equal(t, "300.5", rows[1][4])
+		assert.Equal(t, "Total", rows[3][0])
+		assert.Equal(t, "1250", rows[3][2])
+	}
+	// formatted as shown in Excel: grouped integers, the configured currency and percentages
+	clicks, _ := f.GetCellValue(SummarySheet, "C4")
+	assert.Equal(t, "1,250", clicks)
+	cost, _ := f.GetCellValue(SummarySheet, "E2")
+	assert.Equal(t, "300.50 €", cost)
+	cvr, _ := f.GetCellValue(SummarySheet, "M2")
+	assert.Equal(t, "40.00%", cvr)
+
+	campaigns, err := f.GetRows(CampaignsSheet)
+	assert.NoError(t, err)
+	if assert.Len(t, campaigns, 2) {
+		assert.Equal(t, []string{"Channel", "Campaign"}, campaigns[0][:2])
+		assert.Equal(t, "C-1", campaigns[1][1])
+	}
+	days, err := f.GetRows(DailySheet)
+	assert.NoError(t, err)
+	assert.Len(t, days, 3)
+}
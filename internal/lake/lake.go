@@ -0,0 +1,359 @@
+package lake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"goetl/internal/models"
+	"goetl/internal/utils"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Datasets exported to the lake, each a directory of Hive partitions
+const (
+	// Results are the ETL results, partitioned by date and channel
+	Results = "etl_results"
+	// StagedAds are the normalized ad performance records, partitioned by date and channel
+	StagedAds = "staging_ads"
+	// StagedOpportunities are the normalized opportunities without contact fields, partitioned by creation date
+	StagedOpportunities = "staging_opportunities"
+)
+
+// fileName is the name of the Parquet file of every partition
+const fileName = "data.parquet"
+
+// manifestDir holds the run manifests; query engines skip directories starting with an underscore
+const manifestDir = "_manifests"
+
+// decimalPlaces is the scale of the DECIMAL(18,6) money columns
+const decimalPlaces = 6
+
+// Config holds the lake export settings
+type Config struct {
+	// Dir is the root of the lake, empty disables the export
+	Dir string
+	// Staging also exports the staged ads and opportunities
+	Staging bool
+}
+
+// LoadFromEnv reads LAKE_DIR and LAKE_STAGING (default false)
+func LoadFromEnv() (Config, error) {
+	cfg := Config{Dir: utils.Getenv("LAKE_DIR")}
+	if v := utils.Getenv("LAKE_STAGING"); v != "" {
+		staging, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid LAKE_STAGING %q", v)
+		}
+		cfg.Staging = staging
+	}
+	return cfg, nil
+}
+
+// Enabled reports whether runs export to the lake
+func (c Config) Enabled() bool {
+	return c.Dir != ""
+}
+
+// Partition is a Hive partition. Channel is empty in the datasets partitioned by date only.
+type Partition struct {
+	Date    string
+	Channel string
+}
+
+// Path returns the partition directory relative to its dataset, like date=2025-09-01/channel=google_ads
+func (p Partition) Path() string {
+	path := "date=" + url.PathEscape(p.Date)
+	if p.Channel != "" {
+		path += "/channel=" + url.PathEscape(p.Channel)
+	}
+	return path
+}
+
+// File is a partition file written or removed by an export
+type File struct {
+	Dataset string `json:"dataset"`
+	Path    string `json:"path"`
+	Date    string `json:"date"`
+	Channel string `json:"channel,omitempty"`
+	Rows    int    `json:"rows"`
+	Bytes   int64  `json:"bytes"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+// Manifest lists the files committed by a run, Path being relative to the lake root
+type Manifest struct {
+	RunID     string    `json:"run_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     []File    `json:"files"`
+}
+
+// ResultRow is the Parquet schema of an ETL result. Date and channel are the partition columns.
+type ResultRow struct {
+	RunID            string           `parquet:"run_id"`
+	TransformVersion string           `parquet:"transform_version"`
+	CampaignID       string           `parquet:"campaign_id"`
+	UTMCampaign      string           `parquet:"utm_campaign"`
+	UTMSource        string           `parquet:"utm_source"`
+	UTMMedium        string           `parquet:"utm_medium"`
+	Clicks           int64            `parquet:"clicks"`
+	Impressions      int64            `parquet:"impressions"`
+	Cost             int64            `parquet:"cost,decimal(6:18)"`
+	Leads            int64            `parquet:"leads"`
+	Opportunities    int64            `parquet:"opportunities"`
+	ClosedWon        int64            `parquet:"closed_won"`
+	Revenue          int64            `parquet:"revenue,decimal(6:18)"`
+	Pipeline         int64            `parquet:"pipeline,decimal(6:18)"`
+	CPC              int64            `parquet:"cpc,decimal(6:18)"`
+	CPA              int64            `parquet:"cpa,decimal(6:18)"`
+	CVRLeadToOpp     int64            `parquet:"cvr_lead_to_opp,decimal(6:18)"`
+	CVROppToWon      int64            `parquet:"cvr_opp_to_won,decimal(6:18)"`
+	ROAS             int64            `parquet:"roas,decimal(6:18)"`
+	Metrics          map[string]int64 `parquet:"metrics,optional" parquet-value:",decimal(6:18)"`
+}
+
+// AdRow is the Parquet schema of a staged ad. Date and channel are the partition columns.
+type AdRow struct {
+	CampaignID  string  `parquet:"campaign_id"`
+	Clicks      int64   `parquet:"clicks"`
+	Impressions int64   `parquet:"impressions"`
+	Cost        float64 `parquet:"cost"`
+	UTMCampaign string  `parquet:"utm_campaign"`
+	UTMSource   string  `parquet:"utm_source"`
+	UTMMedium   string  `parquet:"utm_medium"`
+}
+
+// OpportunityRow is the Parquet schema of a staged opportunity. The contact email and its hash are left out so
+// no personal data reaches the lake; the creation date is the partition column.
+type OpportunityRow struct {
+	OpportunityID string  `parquet:"opportunity_id"`
+	Stage         string  `parquet:"stage"`
+	Amount        float64 `parquet:"amount"`
+	CreatedAt     string  `parquet:"created_at"`
+	UTMCampaign   string  `parquet:"utm_campaign"`
+	UTMSource     string  `parquet:"utm_source"`
+	UTMMedium     string  `parquet:"utm_medium"`
+}
+
+// WriteResults replaces the file of a results partition with rows, removing it when rows is empty
+func WriteResults(dir string, p Partition, rows []models.ETLResult) (File, error) {
+	converted := make([]ResultRow, len(rows))
+	for i, res := range rows {
+		converted[i] = ResultRow{
+			RunID:            res.RunID,
+			TransformVersion: res.TransformVersion,
+			CampaignID:       res.CampaignID,
+			UTMCampaign:      res.UTMCampaign,
+			UTMSource:        res.UTMSource,
+			UTMMedium:        res.UTMMedium,
+			Clicks:           int64(res.Clicks),
+			Impressions:      int64(res.Impressions),
+			Cost:             res.Cost.Unscaled(decimalPlaces),
+			Leads:            int64(res.Leads),
+			Opportunities:    int64(res.Opportunities),
+			ClosedWon:        int64(res.ClosedWon),
+			Revenue:          res.Revenue.Unscaled(decimalPlaces),
+			Pipeline:         res.Pipeline.Unscaled(decimalPlaces),
+			CPC:              res.CPC.Unscaled(decimalPlaces),
+			CPA:              res.CPA.Unscaled(decimalPlaces),
+			CVRLeadToOpp:     res.CVRLeadToOpp.Unscaled(decimalPlaces),
+			CVROppToWon:      res.CVROppToWon.Unscaled(decimalPlaces),
+			ROAS:             res.ROAS.Unscaled(decimalPlaces),
+		}
+		if len(res.Metrics) > 0 {
+			converted[i].Metrics = make(map[string]int64, len(res.Metrics))
+			for name, value := range res.Metrics {
+				converted[i].Metrics[name] = value.Unscaled(decimalPlaces)
+			}
+		}
+	}
+	return writePartition(dir, Results, p, converted)
+}
+
+// WriteAds replaces the file of a staged ads partition with rows, removing it when rows is empty
+func WriteAds(dir string, p Partition, rows []models.AdPerformance) (File, error) {
+	converted := make([]AdRow, len(rows))
+	for i, ad := range rows {
+		converted[i] = AdRow{
+			CampaignID:  ad.CampaignID,
+			Clicks:      int64(ad.Clicks),
+			Impressions: int64(ad.Impressions),
+			Cost:        ad.Cost,
+			UTMCampaign: ad.UTMCampaign,
+			UTMSource:   ad.UTMSource,
+			UTMMedium:   ad.UTMMedium,
+		}
+	}
+	return writePartition(dir, StagedAds, p, converted)
+}
+
+// WriteOpportunities replaces the file of a staged opportunities partition with rows, removing it when rows
+// is empty
+func WriteOpportunities(dir string, p Partition, rows []models.Opportunity) (File, error) {
+	converted := make([]OpportunityRow, len(rows))
+	for i, opp := range rows {
+		converted[i] = OpportunityRow{
+			OpportunityID: opp.OpportunityID,
+			Stage:         opp.Stage,
+			Amount:        opp.Amount,
+			CreatedAt:     opp.CreatedAt,
+			UTMCampaign:   opp.UTMCampaign,
+			UTMSource:     opp.UTMSource,
+			UTMMedium:     opp.UTMMedium,
+		}
+	}
+	return writePartition(dir, StagedOpportunities, p, converted)
+}
+
+// writePartition writes rows under a temporary name in the partition directory and renames it over the
+// partition file, so readers see either the previous file or the new one. Without rows the file is removed.
+func writePartition[T any](dir, dataset string, p Partition, rows []T) (File, error) {
+	rel := filepath.Join(dataset, filepath.FromSlash(p.Path()), fileName)
+	file := File{Dataset: dataset, Path: filepath.ToSlash(rel), Date: p.Date, Channel: p.Channel, Rows: len(rows)}
+	path := filepath.Join(dir, rel)
+	if len(rows) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return file, nil
+		}
+		if err != nil {
+			return file, err
+		}
+		file.Removed = true
+		removeEmptyDirs(filepath.Dir(path), filepath.Join(dir, dataset))
+		return file, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return file, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+fileName+"-*.tmp")
+	if err != nil {
+		return file, err
+	}
+	err = parquet.Write(tmp, rows)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(tmp.Name())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return file, fmt.Errorf("%s: %w", file.Path, err)
+	}
+	file.Bytes = info.Size()
+	return file, nil
+}
+
+// removeEmptyDirs removes dir and its parents up to root while they are empty
+func removeEmptyDirs(dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Partitions returns the partitions of dataset holding a file, sorted by date then channel
+func Partitions(dir, dataset string) ([]Partition, error) {
+	root := filepath.Join(dir, dataset)
+	patterns := []string{
+		filepath.Join(root, "date=*", fileName),
+		filepath.Join(root, "date=*", "channel=*", fileName),
+	}
+	var partitions []Partition
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(root, filepath.Dir(match))
+			if err != nil {
+				return nil, err
+			}
+			p, err := parsePartition(filepath.ToSlash(rel))
+			if err != nil {
+				return nil, err
+			}
+			partitions = append(partitions, p)
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Date != partitions[j].Date {
+			return partitions[i].Date < partitions[j].Date
+		}
+		return partitions[i].Channel < partitions[j].Channel
+	})
+	return partitions, nil
+}
+
+// parsePartition reads a partition directory path as built by Partition.Path
+func parsePartition(path string) (Partition, error) {
+	var p Partition
+	for _, segment := range strings.Split(path, "/") {
+		key, value, ok := strings.Cut(segment, "=")
+		if !ok {
+			return p, fmt.Errorf("invalid partition %q", path)
+		}
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return p, fmt.Errorf("invalid partition %q: %w", path, err)
+		}
+		switch key {
+		case "date":
+			p.Date = value
+		case "channel":
+			p.Channel = value
+		default:
+			return p, fmt.Errorf("invalid partition %q", path)
+		}
+	}
+	return p, nil
+}
+
+// WriteManifest commits the manifest of a run as _manifests/<run_id>.json and returns its path. It is written
+// last, so every file it lists is already in place.
+func WriteManifest(dir string, m Manifest) (string, error) {
+	manifests := filepath.Join(dir, manifestDir)
+	if err := os.MkdirAll(manifests, 0o755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(manifests, m.RunID+".json")
+	tmp, err := os.CreateTemp(manifests, "."+m.RunID+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
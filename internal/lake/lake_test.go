@@ -0,0 +1,112 @@
+package lake
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFromEnv(t *testing.T) {
+	os.Setenv("LAKE_DIR", "/data/lake")
+	os.Setenv("LAKE_STAGING", "true")
+	defer os.Unsetenv("LAKE_DIR")
+	defer os.Unsetenv("LAKE_STAGING")
+	cfg, err := LoadFromEnv()
+	assert.NoError(t, err)
+	assert.True(t, cfg.Enabled())
+	assert.True(t, cfg.Staging)
+
+	os.Setenv("LAKE_STAGING", "sometimes")
+	_, err = LoadFromEnv()
+	assert.Error(t, err)
+}
+
+func TestPartition_Path(t *testing.T) {
+	assert.Equal(t, "date=2025-09-01/channel=google_ads", Partition{Date: "2025-09-01", Channel: "google_ads"}.Path())
+	assert.Equal(t, "date=2025-09-01", Partition{Date: "2025-09-01"}.Path())
+	p, err := parsePartition(Partition{Date: "2025-09-01", Channel: "a/b"}.Path())
+	assert.NoError(t, err)
+	assert.Equal(t, "a/b", p.Channel)
+}
+
+func TestWriteResults(t *testing.T) {
+	dir := t.TempDir()
+	p := Partition{Date: "2025-09-01", Channel: "google_ads"}
+	rows := []models.ETLResult{{
+		RunID:      "run-1",
+		Date:       "2025-09-01",
+		Channel:    "google_ads",
+		CampaignID: "C-1",
+		Clicks:     100,
+		Cost:       money.RequireFromString("12.345678"),
+		Revenue:    money.RequireFromString("1000"),
+		Metrics:    map[string]money.Decimal{"cpm": money.RequireFromString("2.5")},
+	}}
+	file, err := WriteResults(dir, p, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, "etl_results/date=2025-09-01/channel=google_ads/data.parquet", file.Path)
+	assert.Equal(t, 1, file.Rows)
+	assert.Positive(t, file.Bytes)
+
+	read, err := parquet.ReadFile[ResultRow](filepath.Join(dir, file.Path))
+	assert.NoError(t, err)
+	if assert.Len(t, read, 1) {
+		assert.Equal(t, "C-1", read[0].CampaignID)
+		assert.Equal(t, int64(12345678), read[0].Cost)
+		assert.Equal(t, int64(2500000), read[0].Metrics["cpm"])
+	}
+	entries, err := os.ReadDir(filepath.Dir(filepath.Join(dir, file.Path)))
+	assert.NoError(t, err)
+	// the temporary file was renamed into place
+	assert.Len(t, entries, 1)
+
+	partitions, err := Partitions(dir, Results)
+	assert.NoError(t, err)
+	assert.Equal(t, []Partition{p}, partitions)
+
+	// an emptied partition loses its file and directories
+	file, err = WriteResults(dir, p, nil)
+	assert.NoError(t, err)
+	assert.True(t, file.Removed)
+	_, err = os.Stat(filepath.Join(dir, Results, "date=2025-09-01"))
+	assert.True(t, os.IsNotExist(err))
+	file, err = WriteResults(dir, p, nil)
+	assert.NoError(t, err)
+	assert.False(t, file.Removed)
+}
+
+func TestWriteOpportunities_NoContactFields(t *testing.T) {
+	dir := t.TempDir()
+	opps := []models.Opportunity{{OpportunityID: "O-1", ContactEmail: "ana@example.com", ContactEmailHash: "abc", CreatedAt: "2025-09-01T10:00:00Z"}}
+	file, err := WriteOpportunities(dir, Partition{Date: "2025-09-01"}, opps)
+	assert.NoError(t, err)
+	assert.Equal(t, "staging_opportunities/date=2025-09-01/data.parquet", file.Path)
+	f, err := os.Open(filepath.Join(dir, file.Path))
+	assert.NoError(t, err)
+	defer f.Close()
+	info, _ := f.Stat()
+	pf, err := parquet.OpenFile(f, info.Size())
+	assert.NoError(t, err)
+	for _, field := range pf.Schema().Fields() {
+		assert.NotContains(t, field.Name(), "email")
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	m := Manifest{RunID: "run-1", CreatedAt: time.Date(2025, 9, 2, 0, 0, 0, 0, time.UTC), Files: []File{{Dataset: Results, Path: "etl_results/date=2025-09-01/channel=google_ads/data.parquet", Rows: 1}}}
+	path, err := WriteManifest(dir, m)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "_manifests", "run-1.json"), path)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var read Manifest
+	assert.NoError(t, json.Unmarshal(data, &read))
+	assert.Equal(t, m, read)
+}
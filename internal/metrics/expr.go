@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"goetl/internal/money"
+)
+
+// Expr is a compiled arithmetic expression over named base fields.
+// Supported syntax: numbers, identifiers, + - * /, unary minus and parentheses.
+// Evaluation uses exact decimals; division by zero evaluates to 0 so ratios over empty rows stay defined.
+type Expr struct {
+	src  string
+	root node
+	vars []string
+}
+
+type node interface {
+	eval(vars map[string]money.Decimal) money.Decimal
+}
+
+type numNode struct{ v money.Decimal }
+
+type varNode string
+
+type negNode struct{ x node }
+
+type binNode struct {
+	op   byte
+	l, r node
+}
+
+func (n numNode) eval(map[string]money.Decimal) money.Decimal { return n.v }
+
+func (n varNode) eval(vars map[string]money.Decimal) money.Decimal { return vars[string(n)] }
+
+func (n negNode) eval(vars map[string]money.Decimal) money.Decimal { return n.x.eval(vars).Neg() }
+
+func (n binNode) eval(vars map[string]money.Decimal) money.Decimal {
+	l, r := n.l.eval(vars), n.r.eval(vars)
+	switch n.op {
+	case '+':
+		return l.Add(r)
+	case '-':
+		return l.Sub(r)
+	case '*':
+		return l.Mul(r)
+	default:
+		return l.Div(r)
+	}
+}
+
+// Compile parses src into an Expr
+func Compile(src string) (*Expr, error) {
+	p := &parser{src: src}
+	p.next()
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d in %q", p.tok.text, p.tok.pos, src)
+	}
+	vars := make([]string, 0, len(p.vars))
+	for v := range p.vars {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	return &Expr{src: src, root: root, vars: vars}, nil
+}
+
+// Eval evaluates the expression, missing fields count as 0
+func (e *Expr) Eval(vars map[string]money.Decimal) money.Decimal {
+	return e.root.eval(vars)
+}
+
+// Vars returns the identifiers referenced by the expression
+func (e *Expr) Vars() []string {
+	return e.vars
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+const (
+	tokEOF = iota
+	tokNum
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+type parser struct {
+	src  string
+	pos  int
+	tok  token
+	vars map[string]bool
+}
+
+func (p *parser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: tokNum, text: p.src[start:p.pos], pos: start}
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '_') {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokOp, text: p.src[start:p.pos], pos: start}
+	}
+}
+
+// parseSum handles + and -
+func (p *parser) parseSum() (node, error) {
+	l, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && (p.tok.text == "+" || p.tok.text == "-") {
+		op := p.tok.text[0]
+		p.next()
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l = binNode{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+// parseProduct handles * and /
+func (p *parser) parseProduct() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && (p.tok.text == "*" || p.tok.text == "/") {
+		op := p.tok.text[0]
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = binNode{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokOp && p.tok.text == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negNode{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokNum:
+		v, err := money.NewFromString(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d in %q", tok.text, tok.pos, p.src)
+		}
+		p.next()
+		return numNode{v: v}, nil
+	case tok.kind == tokIdent:
+		if p.vars == nil {
+			p.vars = map[string]bool{}
+		}
+		name := strings.ToLower(tok.text)
+		p.vars[name] = true
+		p.next()
+		return varNode(name), nil
+	case tok.kind == tokOp && tok.text == "(":
+		p.next()
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokOp || p.tok.text != ")" {
+			return nil, fmt.Errorf("missing ')' at position %d in %q", p.tok.pos, p.src)
+		}
+		p.next()
+		return x, nil
+	case tok.kind == tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression %q", p.src)
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d in %q", tok.text, tok.pos, p.src)
+	}
+}
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"goetl/internal/utils"
+)
+
+// DefaultPrecision is used when a definition does not set its own precision
+const DefaultPrecision = 2
+
+// Definition declares a derived metric as an expression over base fields
+type Definition struct {
+	Name      string `json:"name"`
+	Expr      string `json:"expr"`
+	Precision *int   `json:"precision,omitempty"`
+	// Rounding is one of half_up (default), half_even, down, up, floor or ceil
+	Rounding string `json:"rounding,omitempty"`
+}
+
+// Config is the JSON document read from METRICS_CONFIG
+type Config struct {
+	Metrics []Definition `json:"metrics"`
+}
+
+// Metric is a compiled Definition
+type Metric struct {
+	Name      string
+	Expr      *Expr
+	Precision int
+	Rounding  money.RoundingMode
+}
+
+// Set is an ordered list of compiled metrics
+type Set struct {
+	metrics []Metric
+}
+
+// BaseFields lists the fields of an ETLResult that expressions may reference
+var BaseFields = []string{"clicks", "impressions", "cost", "leads", "opportunities", "closed_won", "revenue", "pipeline"}
+
+// Defaults reproduces the metrics goetl has always computed
+var Defaults = []Definition{
+	{Name: "cpc", Expr: "cost / clicks"},
+	{Name: "cpa", Expr: "cost / leads"},
+	{Name: "cvr_lead_to_opp", Expr: "opportunities / leads"},
+	{Name: "cvr_opp_to_won", Expr: "closed_won / opportunities"},
+	{Name: "roas", Expr: "revenue / cost"},
+}
+
+// NewSet compiles defs, checking names are unique and only base fields are referenced
+func NewSet(defs []Definition) (*Set, error) {
+	known := make(map[string]bool, len(BaseFields))
+	for _, f := range BaseFields {
+		known[f] = true
+	}
+	seen := make(map[string]bool, len(defs))
+	set := &Set{metrics: make([]Metric, 0, len(defs))}
+	for _, def := range defs {
+		if def.Name == "" {
+			return nil, fmt.Errorf("metric with expression %q has no name", def.Expr)
+		}
+		if seen[def.Name] {
+			return nil, fmt.Errorf("metric %q defined twice", def.Name)
+		}
+		seen[def.Name] = true
+		expr, err := Compile(def.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("metric %q: %w", def.Name, err)
+		}
+		for _, v := range expr.Vars() {
+			if !known[v] {
+				return nil, fmt.Errorf("metric %q: unknown field %q", def.Name, v)
+			}
+		}
+		precision := DefaultPrecision
+		if def.Precision != nil {
+			precision = *def.Precision
+		}
+		if precision < 0 {
+			return nil, fmt.Errorf("metric %q: negative precision %d", def.Name, precision)
+		}
+		rounding, err := money.ParseRoundingMode(def.Rounding)
+		if err != nil {
+			return nil, fmt.Errorf("metric %q: %w", def.Name, err)
+		}
+		set.metrics = append(set.metrics, Metric{Name: def.Name, Expr: expr, Precision: precision, Rounding: rounding})
+	}
+	return set, nil
+}
+
+// Merge returns defaults overridden and extended by extra, keeping definition order
+func Merge(defaults, extra []Definition) []Definition {
+	merged := make([]Definition, 0, len(defaults)+len(extra))
+	index := make(map[string]int, len(defaults))
+	for _, def := range defaults {
+		index[def.Name] = len(merged)
+		merged = append(merged, def)
+	}
+	for _, def := range extra {
+		if i, ok := index[def.Name]; ok {
+			merged[i] = def
+			continue
+		}
+		index[def.Name] = len(merged)
+		merged = append(merged, def)
+	}
+	return merged
+}
+
+// Load builds the metric set from the defaults plus the JSON file at path, if any
+func Load(path string) (*Set, error) {
+	if path == "" {
+		return NewSet(Defaults)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading metrics config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing metrics config: %w", err)
+	}
+	return NewSet(Merge(Defaults, cfg.Metrics))
+}
+
+// LoadFromEnv builds the metric set using the file named by METRICS_CONFIG
+func LoadFromEnv() (*Set, error) {
+	return Load(utils.Getenv("METRICS_CONFIG"))
+}
+
+// Metrics returns the compiled metrics in evaluation order
+func (s *Set) Metrics() []Metric {
+	return s.metrics
+}
+
+// Evaluate computes every metric for the given base field values, rounded per definition
+func (s *Set) Evaluate(vars map[string]money.Decimal) map[string]money.Decimal {
+	out := make(map[string]money.Decimal, len(s.metrics))
+	for _, m := range s.metrics {
+		out[m.Name] = m.Expr.Eval(vars).Round(m.Precision, m.Rounding)
+	}
+	return out
+}
+
+// Apply evaluates the set on res, storing every metric in res.Metrics and
+// filling the legacy ratio fields when their metric is defined
+func (s *Set) Apply(res *models.ETLResult) {
+	values := s.Evaluate(Fields(*res))
+	res.Metrics = values
+	res.CPC = values["cpc"]
+	res.CPA = values["cpa"]
+	res.CVRLeadToOpp = values["cvr_lead_to_opp"]
+	res.CVROppToWon = values["cvr_opp_to_won"]
+	res.ROAS = values["roas"]
+}
+
+// Fields returns the base field values of res keyed by their expression name
+func Fields(res models.ETLResult) map[string]money.Decimal {
+	return map[string]money.Decimal{
+		"clicks":        money.NewFromInt(res.Clicks),
+		"impressions":   money.NewFromInt(res.Impressions),
+		"cost":          res.Cost,
+		"leads":         money.NewFromInt(res.Leads),
+		"opportunities": money.NewFromInt(res.Opportunities),
+		"closed_won":    money.NewFromInt(res.ClosedWon),
+		"revenue":       res.Revenue,
+		"pipeline":      res.Pipeline,
+	}
+}
+
+// AggregateFields returns the summed base fields of a, so the metrics of a group are evaluated on its totals
+// rather than averaged over its rows
+func AggregateFields(a models.ResultAggregate) map[string]money.Decimal {
+	return Fields(models.ETLResult{
+		Clicks:        a.Clicks,
+		Impressions:   a.Impressions,
+		Cost:          a.Cost,
+		Leads:         a.Leads,
+		Opportunities: a.Opportunities,
+		ClosedWon:     a.ClosedWon,
+		Revenue:       a.Revenue,
+		Pipeline:      a.Pipeline,
+	})
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+)
+
+func TestCompile_Eval(t *testing.T) {
+	cases := []struct {
+		expr     string
+		expected string
+	}{
+		{"revenue / cost", "4"},
+		{"cost / clicks * 1000", "5000"},
+		{"(revenue - cost) / cost", "3"},
+		{"-cost + 100", "50"},
+		{"1 + 2 * 3", "7"},
+		{"cost / leads", "0"},
+		{"0.1 + 0.2", "0.3"},
+	}
+	vars := map[string]money.Decimal{
+		"revenue": money.NewFromInt(200),
+		"cost":    money.NewFromInt(50),
+		"clicks":  money.NewFromInt(10),
+		"leads":   money.Zero,
+	}
+	for _, c := range cases {
+		e, err := Compile(c.expr)
+		assert.NoError(t, err, c.expr)
+		assert.Equal(t, c.expected, e.Eval(vars).String(), c.expr)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for _, expr := range []string{"", "cost /", "(cost", "cost $ clicks", "cost clicks", "1..2"} {
+		_, err := Compile(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCompile_Vars(t *testing.T) {
+	e, err := Compile("Revenue / cost + cost")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cost", "revenue"}, e.Vars())
+}
+
+func TestNewSet_Validation(t *testing.T) {
+	_, err := NewSet([]Definition{{Name: "x", Expr: "foo / cost"}})
+	assert.ErrorContains(t, err, "unknown field")
+	_, err = NewSet([]Definition{{Name: "x", Expr: "cost"}, {Name: "x", Expr: "clicks"}})
+	assert.ErrorContains(t, err, "defined twice")
+	_, err = NewSet([]Definition{{Expr: "cost"}})
+	assert.ErrorContains(t, err, "no name")
+}
+
+func TestSet_Apply(t *testing.T) {
+	four := 4
+	set, err := NewSet(Merge(Defaults, []Definition{
+		{Name: "ctr", Expr: "clicks / impressions", Precision: &four},
+		{Name: "roas", Expr: "revenue / cost", Precision: &four},
+	}))
+	assert.NoError(t, err)
+	res := models.ETLResult{Clicks: 3, Impressions: 7, Cost: money.NewFromInt(30), Leads: 2, Opportunities: 3, ClosedWon: 1, Revenue: money.NewFromInt(100)}
+	set.Apply(&res)
+	assert.Equal(t, "10", res.CPC.String())
+	assert.Equal(t, "15", res.CPA.String())
+	assert.Equal(t, "1.5", res.CVRLeadToOpp.String())
+	assert.Equal(t, "0.33", res.CVROppToWon.String())
+	assert.Equal(t, "3.3333", res.ROAS.String())
+	assert.Equal(t, "0.4286", res.Metrics["ctr"].String())
+	assert.Len(t, res.Metrics, 6)
+}
+
+func TestSet_Rounding(t *testing.T) {
+	zero := 0
+	set, err := NewSet([]Definition{
+		{Name: "half_up", Expr: "cost / clicks", Precision: &zero},
+		{Name: "half_even", Expr: "cost / clicks", Precision: &zero, Rounding: "half_even"},
+		{Name: "down", Expr: "cost / clicks", Precision: &zero, Rounding: "down"},
+	})
+	assert.NoError(t, err)
+	values := set.Evaluate(map[string]money.Decimal{"cost": money.NewFromInt(5), "clicks": money.NewFromInt(2)})
+	assert.Equal(t, "3", values["half_up"].String())
+	assert.Equal(t, "2", values["half_even"].String())
+	assert.Equal(t, "2", values["down"].String())
+
+	_, err = NewSet([]Definition{{Name: "x", Expr: "cost", Rounding: "sideways"}})
+	assert.ErrorContains(t, err, "unknown rounding mode")
+}
+
+func TestAggregateFields(t *testing.T) {
+	set, err := NewSet(Defaults)
+	assert.NoError(t, err)
+	var agg models.ResultAggregate
+	agg.Add(models.ETLResult{Clicks: 1, Cost: money.NewFromInt(10)})
+	agg.Add(models.ETLResult{Clicks: 9, Cost: money.NewFromInt(10)})
+	// the cpc of the sums, not the mean of the row cpcs (10 and 1.11)
+	assert.Equal(t, "2", set.Evaluate(AggregateFields(agg))["cpc"].String())
+}
+
+func TestLoad(t *testing.T) {
+	set, err := Load("")
+	assert.NoError(t, err)
+	assert.Len(t, set.Metrics(), len(Defaults))
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"metrics":[{"name":"cpm","expr":"cost / impressions * 1000"}]}`), 0o644))
+	set, err = Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, set.Metrics(), len(Defaults)+1)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -1,22 +1,179 @@
 package models
+
+import (
+	"goetl/internal/money"
+	"time"
+)
+
 // ETLResult represents the consolidated data to persist after ETL processing
 type ETLResult struct {
-	Date           string  `json:"date"`
-	Channel        string  `json:"channel"`
-	CampaignID     string  `json:"campaign_id"`
-	UTMCampaign    string  `json:"utm_campaign"`
-	Clicks         int     `json:"clicks"`
-	Impressions    int     `json:"impressions"`
-	Cost           float64 `json:"cost"`
-	Leads          int     `json:"leads"`
-	Opportunities  int     `json:"opportunities"`
-	ClosedWon      int     `json:"closed_won"`
-	Revenue        float64 `json:"revenue"`
-	CPC            float64 `json:"cpc"`
-	CPA            float64 `json:"cpa"`
-	CVRLeadToOpp   float64 `json:"cvr_lead_to_opp"`
-	CVROppToWon    float64 `json:"cvr_opp_to_won"`
-	ROAS           float64 `json:"roas"`
+	RunID            string                   `json:"run_id,omitempty"`
+	TransformVersion string                   `json:"transform_version,omitempty"`
+	Date             string                   `json:"date"`
+	Channel          string                   `json:"channel"`
+	CampaignID       string                   `json:"campaign_id"`
+	UTMCampaign      string                   `json:"utm_campaign"`
+	UTMSource        string                   `json:"utm_source"`
+	UTMMedium        string                   `json:"utm_medium"`
+	Clicks           int                      `json:"clicks"`
+	Impressions      int                      `json:"impressions"`
+	Cost             money.Decimal            `json:"cost"`
+	Leads            int                      `json:"leads"`
+	Opportunities    int                      `json:"opportunities"`
+	ClosedWon        int                      `json:"closed_won"`
+	Revenue          money.Decimal            `json:"revenue"`
+	Pipeline         money.Decimal            `json:"pipeline"`
+	CPC              money.Decimal            `json:"cpc"`
+	CPA              money.Decimal            `json:"cpa"`
+	CVRLeadToOpp     money.Decimal            `json:"cvr_lead_to_opp"`
+	CVROppToWon      money.Decimal            `json:"cvr_opp_to_won"`
+	ROAS             money.Decimal            `json:"roas"`
+	Metrics          map[string]money.Decimal `json:"metrics,omitempty"`
+}
+
+// ResultVersion is an ETLResult as written by a load at WrittenAt, or the tombstone of a result deleted
+// then, RunID naming the run that wrote or deleted it
+type ResultVersion struct {
+	ETLResult `bson:",inline"`
+	WrittenAt time.Time `json:"written_at"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// Rollback is the audit record of the results of a date range restored to their state before a run
+type Rollback struct {
+	ID         string    `json:"id"`
+	RunID      string    `json:"run_id"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	RestoredAt time.Time `json:"restored_at"`
+	// Before is when the rolled back run first wrote results, the state restored is the one just before
+	Before time.Time   `json:"before"`
+	Load   LoadSummary `json:"load"`
+}
+
+// ResultAggregate sums the base fields of the ETL results sharing the same group key. Key fields the
+// results were not grouped by are empty.
+type ResultAggregate struct {
+	Date          string        `json:"date,omitempty"`
+	Channel       string        `json:"channel,omitempty"`
+	CampaignID    string        `json:"campaign_id,omitempty"`
+	Rows          int           `json:"rows"`
+	Clicks        int           `json:"clicks"`
+	Impressions   int           `json:"impressions"`
+	Cost          money.Decimal `json:"cost"`
+	Leads         int           `json:"leads"`
+	Opportunities int           `json:"opportunities"`
+	ClosedWon     int           `json:"closed_won"`
+	Revenue       money.Decimal `json:"revenue"`
+	Pipeline      money.Decimal `json:"pipeline"`
+}
+
+// ResultPage is a page of ETL results with the count of every result of the query and, when more results
+// follow, the cursor of the next page
+type ResultPage struct {
+	Total      int         `json:"total"`
+	Results    []ETLResult `json:"results"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// Add accumulates the base fields of res
+func (a *ResultAggregate) Add(res ETLResult) {
+	a.Rows++
+	a.Clicks += res.Clicks
+	a.Impressions += res.Impressions
+	a.Cost = a.Cost.Add(res.Cost)
+	a.Leads += res.Leads
+	a.Opportunities += res.Opportunities
+	a.ClosedWon += res.ClosedWon
+	a.Revenue = a.Revenue.Add(res.Revenue)
+	a.Pipeline = a.Pipeline.Add(res.Pipeline)
+}
+
+// Restatement records how a stored ETLResult changed when a run reprocessed its date
+type Restatement struct {
+	RunID      string        `json:"run_id"`
+	Date       string        `json:"date"`
+	Channel    string        `json:"channel"`
+	CampaignID string        `json:"campaign_id"`
+	Changes    []FieldChange `json:"changes"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// FieldChange is the old and new value of a restated field or metric
+type FieldChange struct {
+	Field string        `json:"field"`
+	Old   money.Decimal `json:"old"`
+	New   money.Decimal `json:"new"`
+}
+
+// Anomaly is a daily channel/campaign metric outside its rolling baseline
+type Anomaly struct {
+	RunID      string    `json:"run_id"`
+	Date       string    `json:"date"`
+	Channel    string    `json:"channel"`
+	CampaignID string    `json:"campaign_id"`
+	Metric     string    `json:"metric"`
+	Value      float64   `json:"value"`
+	Mean       float64   `json:"mean"`
+	StdDev     float64   `json:"stddev"`
+	ZScore     float64   `json:"zscore"`
+	PctChange  float64   `json:"pct_change"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Budget types
+const (
+	BudgetMonthly = "monthly"
+	BudgetFlight  = "flight"
+)
+
+// Budget is the planned spend of a campaign for a calendar month or a flight between two dates
+type Budget struct {
+	ID         string        `json:"id"`
+	CampaignID string        `json:"campaign_id"`
+	Channel    string        `json:"channel,omitempty"`
+	Type       string        `json:"type"`
+	Month      string        `json:"month,omitempty"`
+	StartDate  string        `json:"start_date"`
+	EndDate    string        `json:"end_date"`
+	Amount     money.Decimal `json:"amount"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+}
+
+// Pacing compares the spend of a budget to date with its plan
+type Pacing struct {
+	BudgetID    string        `json:"budget_id"`
+	CampaignID  string        `json:"campaign_id"`
+	Channel     string        `json:"channel,omitempty"`
+	StartDate   string        `json:"start_date"`
+	EndDate     string        `json:"end_date"`
+	AsOf        string        `json:"as_of"`
+	ElapsedDays int           `json:"elapsed_days"`
+	TotalDays   int           `json:"total_days"`
+	Budget      money.Decimal `json:"budget"`
+	Spend       money.Decimal `json:"spend"`
+	Expected    money.Decimal `json:"expected"`
+	RunRate     money.Decimal `json:"run_rate"`
+	Projected   money.Decimal `json:"projected"`
+	PacingRatio money.Decimal `json:"pacing_ratio"`
+	Status      string        `json:"status"`
+}
+
+// ErasureReceipt is the audit record of a contact erasure request. It never holds the plaintext email.
+type ErasureReceipt struct {
+	ID            string    `json:"id"`
+	ContactHash   string    `json:"contact_hash"`
+	RequestedAt   time.Time `json:"requested_at"`
+	CompletedAt   time.Time `json:"completed_at"`
+	RawPayloads   int       `json:"raw_payloads"`
+	DeadLetters   int       `json:"dead_letters"`
+	Staged        int       `json:"staged"`
+	Opportunities int       `json:"opportunities"`
+	Results       int       `json:"results"`
+	// LakeFiles counts the lake partitions rewritten or removed, listed in the manifest at LakeManifest
+	LakeFiles    int    `json:"lake_files"`
+	LakeManifest string `json:"lake_manifest,omitempty"`
 }
 
 type CRMAPIResponse struct {
@@ -28,14 +185,15 @@ type CRMAPIResponse struct {
 }
 
 type Opportunity struct {
-	OpportunityID string  `json:"opportunity_id"`
-	ContactEmail  string  `json:"contact_email"`
-	Stage         string  `json:"stage"`
-	Amount        float64 `json:"amount"`
-	CreatedAt     string  `json:"created_at"`
-	UTMCampaign   string  `json:"utm_campaign"`
-	UTMSource     string  `json:"utm_source"`
-	UTMMedium     string  `json:"utm_medium"`
+	OpportunityID    string  `json:"opportunity_id"`
+	ContactEmail     string  `json:"contact_email"`
+	Stage            string  `json:"stage"`
+	Amount           float64 `json:"amount"`
+	CreatedAt        string  `json:"created_at"`
+	UTMCampaign      string  `json:"utm_campaign"`
+	UTMSource        string  `json:"utm_source"`
+	UTMMedium        string  `json:"utm_medium"`
+	ContactEmailHash string  `json:"contact_email_hash,omitempty"` // salted SHA-256 of ContactEmail, set during ingestion
 }
 
 type AdsAPIResponse struct {
@@ -57,3 +215,287 @@ type AdPerformance struct {
 	UTMSource   string  `json:"utm_source"`
 	UTMMedium   string  `json:"utm_medium"`
 }
+
+// StagedAd is a normalized ad performance record kept in staging, keyed by (date, channel, campaign_id)
+type StagedAd struct {
+	AdPerformance `bson:",inline"`
+	RunID         string    `json:"run_id"`
+	StagedAt      time.Time `json:"staged_at"`
+}
+
+// StagedOpportunity is a normalized opportunity kept in staging, keyed by (opportunity_id, created_at)
+type StagedOpportunity struct {
+	Opportunity `bson:",inline"`
+	RunID       string    `json:"run_id"`
+	StagedAt    time.Time `json:"staged_at"`
+}
+
+// Lineage lists the opportunities a result row was computed from by the run that last wrote it
+type Lineage struct {
+	Date          string        `json:"date"`
+	Channel       string        `json:"channel"`
+	CampaignID    string        `json:"campaign_id"`
+	RunID         string        `json:"run_id"`
+	Opportunities []Attribution `json:"opportunities"`
+}
+
+// Attribution is an opportunity counted in a result row, with the share of it credited to the row
+type Attribution struct {
+	OpportunityID string  `json:"opportunity_id"`
+	CreatedAt     string  `json:"created_at"`
+	Weight        float64 `json:"weight"`
+}
+
+// AttributedOpportunity is an opportunity with the result row it was attributed to and its weight there
+type AttributedOpportunity struct {
+	Opportunity
+	Channel string  `json:"channel"`
+	Weight  float64 `json:"weight"`
+}
+
+// QualityReport summarizes the data quality of a single ETL run
+type QualityReport struct {
+	RunID               string                    `json:"run_id"`
+	Since               string                    `json:"since"`
+	EffectiveSince      string                    `json:"effective_since"`
+	Extraction          string                    `json:"extraction"`
+	TransformVersion    string                    `json:"transform_version"`
+	StartedAt           time.Time                 `json:"started_at"`
+	FinishedAt          time.Time                 `json:"finished_at"`
+	Inputs              map[string]int            `json:"inputs"`
+	Dropped             map[string]map[string]int `json:"dropped"`
+	Duplicates          map[string]int            `json:"duplicates"`
+	Join                JoinStats                 `json:"join"`
+	UnattributedRevenue money.Decimal             `json:"unattributed_revenue"`
+	Violations          map[string]int            `json:"violations"`
+	Results             int                       `json:"results"`
+	Restated            int                       `json:"restated"`
+	Anomalies           int                       `json:"anomalies"`
+	Load                LoadSummary               `json:"load"`
+	LakeManifest        string                    `json:"lake_manifest,omitempty"`
+	Stages              []StageStats              `json:"stages"`
+	Status              string                    `json:"status"`
+	Error               string                    `json:"error,omitempty"`
+
+	violations  []Violation
+	deadLetters []DeadLetter
+}
+
+// LoadSummary counts the outcome of the bulk upserts of a load
+type LoadSummary struct {
+	Mode      string `json:"mode"`
+	Rows      int    `json:"rows"`
+	Batches   int    `json:"batches"`
+	Inserted  int    `json:"inserted"`
+	Modified  int    `json:"modified"`
+	Unchanged int    `json:"unchanged"`
+	Deleted   int    `json:"deleted"`
+	Failed    int    `json:"failed"`
+}
+
+// IndexStatus describes a MongoDB index compared with the indexes goetl requires
+type IndexStatus struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Keys       string `json:"keys"`
+	Unique     bool   `json:"unique"`
+	// ExpireAfterSeconds is set on the TTL indexes enforcing retention
+	ExpireAfterSeconds int32  `json:"expire_after_seconds,omitempty"`
+	Status             string `json:"status"`
+	Error              string `json:"error,omitempty"`
+}
+
+// PurgeResult describes what a retention purge removed from a collection
+type PurgeResult struct {
+	Collection string `json:"collection"`
+	// Cutoff is the time before which data expired, compared with the date of date keyed collections
+	Cutoff   time.Time `json:"cutoff"`
+	Archived int       `json:"archived"`
+	Archive  string    `json:"archive,omitempty"`
+	Deleted  int       `json:"deleted"`
+	// Note explains why expired data was not archived although an archive directory is set
+	Note  string `json:"note,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// LoadFailure is a result that could not be written during a load
+type LoadFailure struct {
+	Date       string `json:"date"`
+	Channel    string `json:"channel"`
+	CampaignID string `json:"campaign_id"`
+	Error      string `json:"error"`
+}
+
+// Add accumulates the counts of other
+func (s *LoadSummary) Add(other LoadSummary) {
+	s.Rows += other.Rows
+	s.Batches += other.Batches
+	s.Inserted += other.Inserted
+	s.Modified += other.Modified
+	s.Unchanged += other.Unchanged
+	s.Deleted += other.Deleted
+	s.Failed += other.Failed
+}
+
+// DeadLetter is an input record discarded during transformation, kept with its contact email redacted
+type DeadLetter struct {
+	RunID       string    `json:"run_id"`
+	Source      string    `json:"source"`
+	Reason      string    `json:"reason"`
+	Payload     string    `json:"payload"`
+	ContactHash string    `json:"contact_hash,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RawPayload archives the records extracted from a source in a run. Contact emails are
+// hashed before archival and the payload is AES-GCM encrypted into Ciphertext when a key is set.
+type RawPayload struct {
+	RunID         string    `json:"run_id"`
+	Source        string    `json:"source"`
+	Records       int       `json:"records"`
+	Encrypted     bool      `json:"encrypted"`
+	Payload       string    `json:"payload,omitempty"`
+	Ciphertext    []byte    `json:"ciphertext,omitempty"`
+	ContactHashes []string  `json:"contact_hashes,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Violation is a validation rule broken by an input record
+type Violation struct {
+	RunID      string    `json:"run_id"`
+	Rule       string    `json:"rule"`
+	Severity   string    `json:"severity"`
+	Date       string    `json:"date"`
+	Channel    string    `json:"channel"`
+	CampaignID string    `json:"campaign_id"`
+	Message    string    `json:"message"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// JoinStats describes how many ads and opportunities matched when crossing both sources
+type JoinStats struct {
+	Ads                  int     `json:"ads"`
+	AdsMatched           int     `json:"ads_matched"`
+	Opportunities        int     `json:"opportunities"`
+	OpportunitiesMatched int     `json:"opportunities_matched"`
+	MatchRate            float64 `json:"match_rate"`
+}
+
+// StageStats records the duration of a transform stage and the record counts it left behind
+type StageStats struct {
+	Name          string  `json:"name"`
+	DurationMs    float64 `json:"duration_ms"`
+	Ads           int     `json:"ads"`
+	Opportunities int     `json:"opportunities"`
+	Joined        int     `json:"joined"`
+	Results       int     `json:"results"`
+}
+
+// Sources and drop reasons recorded in a QualityReport
+const (
+	SourceAds           = "ads"
+	SourceOpportunities = "opportunities"
+
+	DropInvalidDate = "invalid_date"
+	DropMissingDate = "missing_date"
+	DropMissingKeys = "missing_keys"
+	DropBeforeSince = "before_since"
+	DropValidation  = "validation"
+
+	LoadUpsert      = "upsert"
+	LoadTransaction = "transaction"
+	LoadSwap        = "swap"
+	LoadInPlace     = "in_place"
+
+	IndexPresent   = "present"
+	IndexMissing   = "missing"
+	IndexDifferent = "different"
+	IndexExtra     = "extra"
+	IndexCreated   = "created"
+	IndexRebuilt   = "rebuilt"
+	IndexDropped   = "dropped"
+	IndexFailed    = "failed"
+
+	RunCompleted = "completed"
+	RunPartial   = "partial"
+	RunFailed    = "failed"
+
+	ExtractLive    = "live"
+	ExtractStaging = "staging"
+)
+
+// NewQualityReport returns an empty report for the given run
+func NewQualityReport(runID, since string) *QualityReport {
+	return &QualityReport{
+		RunID:      runID,
+		Since:      since,
+		StartedAt:  time.Now().UTC(),
+		Inputs:     map[string]int{},
+		Dropped:    map[string]map[string]int{},
+		Duplicates: map[string]int{},
+		Violations: map[string]int{},
+	}
+}
+
+// AddInput records n input records read from source. Safe to call on a nil report.
+func (r *QualityReport) AddInput(source string, n int) {
+	if r == nil {
+		return
+	}
+	r.Inputs[source] += n
+}
+
+// AddDrop records a record discarded from source for the given reason. Safe to call on a nil report.
+func (r *QualityReport) AddDrop(source, reason string) {
+	if r == nil {
+		return
+	}
+	if r.Dropped[source] == nil {
+		r.Dropped[source] = map[string]int{}
+	}
+	r.Dropped[source][reason]++
+}
+
+// AddViolation records a validation violation for the run. Safe to call on a nil report.
+func (r *QualityReport) AddViolation(v Violation) {
+	if r == nil {
+		return
+	}
+	v.RunID = r.RunID
+	r.Violations[v.Rule]++
+	r.violations = append(r.violations, v)
+}
+
+// ViolationRecords returns the violations recorded with AddViolation
+func (r *QualityReport) ViolationRecords() []Violation {
+	if r == nil {
+		return nil
+	}
+	return r.violations
+}
+
+// AddDeadLetter records a discarded record and counts it as dropped. Safe to call on a nil report.
+func (r *QualityReport) AddDeadLetter(d DeadLetter) {
+	if r == nil {
+		return
+	}
+	d.RunID = r.RunID
+	r.AddDrop(d.Source, d.Reason)
+	r.deadLetters = append(r.deadLetters, d)
+}
+
+// DeadLetterRecords returns the dead letters recorded with AddDeadLetter
+func (r *QualityReport) DeadLetterRecords() []DeadLetter {
+	if r == nil {
+		return nil
+	}
+	return r.deadLetters
+}
+
+// AddDuplicate records a duplicate resolved in source. Safe to call on a nil report.
+func (r *QualityReport) AddDuplicate(source string) {
+	if r == nil {
+		return
+	}
+	r.Duplicates[source]++
+}
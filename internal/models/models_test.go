@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"testing"
 	"github.com/stretchr/testify/assert"
+	"goetl/internal/money"
 )
 
 func TestETLResult_JSONMarshalling(t *testing.T) {
@@ -14,16 +15,17 @@ func TestETLResult_JSONMarshalling(t *testing.T) {
 		UTMCampaign: "fall2025",
 		Clicks:      10,
 		Impressions: 100,
-		Cost:        50.0,
+		Cost:        money.RequireFromString("50"),
 		Leads:       2,
 		Opportunities: 3,
 		ClosedWon:   1,
-		Revenue:     200.0,
-		CPC:         5.0,
-		CPA:         25.0,
-		CVRLeadToOpp: 1.5,
-		CVROppToWon:  0.33,
-		ROAS:        4.0,
+		Revenue:     money.RequireFromString("200"),
+		Pipeline:    money.RequireFromString("100"),
+		CPC:         money.RequireFromString("5"),
+		CPA:         money.RequireFromString("25"),
+		CVRLeadToOpp: money.RequireFromString("1.5"),
+		CVROppToWon:  money.RequireFromString("0.33"),
+		ROAS:        money.RequireFromString("4"),
 	}
 	b, err := json.Marshal(in)
 	assert.NoError(t, err)
@@ -117,3 +119,28 @@ func TestAdPerformance_Fields(t *testing.T) {
 	assert.Equal(t, "google", ad.UTMSource)
 	assert.Equal(t, "cpc", ad.UTMMedium)
 }
+
+func TestQualityReport_Counters(t *testing.T) {
+	r := NewQualityReport("run1", "2025-09-01")
+	r.AddInput(SourceAds, 3)
+	r.AddDrop(SourceAds, DropInvalidDate)
+	r.AddDrop(SourceAds, DropInvalidDate)
+	r.AddDuplicate(SourceOpportunities)
+	assert.Equal(t, "run1", r.RunID)
+	assert.Equal(t, 3, r.Inputs[SourceAds])
+	assert.Equal(t, 2, r.Dropped[SourceAds][DropInvalidDate])
+	assert.Equal(t, 1, r.Duplicates[SourceOpportunities])
+
+	var nilReport *QualityReport
+	assert.NotPanics(t, func() {
+		nilReport.AddInput(SourceAds, 1)
+		nilReport.AddDrop(SourceAds, DropMissingKeys)
+		nilReport.AddDuplicate(SourceAds)
+	})
+}
+
+func TestLoadSummary_Add(t *testing.T) {
+	s := LoadSummary{Rows: 2, Batches: 1, Inserted: 1, Modified: 1}
+	s.Add(LoadSummary{Rows: 3, Batches: 1, Inserted: 1, Unchanged: 1, Failed: 1})
+	assert.Equal(t, LoadSummary{Rows: 5, Batches: 2, Inserted: 2, Modified: 1, Unchanged: 1, Failed: 1}, s)
+}
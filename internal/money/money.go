@@ -0,0 +1,198 @@
+package money
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// divisionPrecision is the number of decimal places kept by Div before a ratio is rounded
+const divisionPrecision = 28
+
+// Decimal is an exact decimal number for money amounts and ratios.
+// It is encoded as a JSON number and as a BSON Decimal128. The zero value is 0.
+type Decimal struct {
+	d decimal.Decimal
+}
+
+// Zero is the decimal 0
+var Zero = Decimal{}
+
+// NewFromFloat converts f using its shortest decimal representation, so values parsed
+// from JSON like 0.1 stay exactly 0.1
+func NewFromFloat(f float64) Decimal {
+	return Decimal{d: decimal.NewFromFloat(f)}
+}
+
+// NewFromInt converts an integer count
+func NewFromInt(i int) Decimal {
+	return Decimal{d: decimal.NewFromInt(int64(i))}
+}
+
+// NewFromString parses a decimal such as "12.34"
+func NewFromString(s string) (Decimal, error) {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return Zero, err
+	}
+	return Decimal{d: d}, nil
+}
+
+// RequireFromString parses s and panics on error, for constants and tests
+func RequireFromString(s string) Decimal {
+	d, err := NewFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (a Decimal) Add(b Decimal) Decimal { return Decimal{d: a.d.Add(b.d)} }
+
+func (a Decimal) Sub(b Decimal) Decimal { return Decimal{d: a.d.Sub(b.d)} }
+
+func (a Decimal) Mul(b Decimal) Decimal { return Decimal{d: a.d.Mul(b.d)} }
+
+func (a Decimal) Neg() Decimal { return Decimal{d: a.d.Neg()} }
+
+// Div divides keeping enough places for any later rounding; division by zero returns 0
+func (a Decimal) Div(b Decimal) Decimal {
+	if b.d.IsZero() {
+		return Zero
+	}
+	return Decimal{d: a.d.DivRound(b.d, divisionPrecision)}
+}
+
+// Round rounds to places decimal places with the given mode
+func (a Decimal) Round(places int, mode RoundingMode) Decimal {
+	p := int32(places)
+	switch mode {
+	case HalfEven:
+		return Decimal{d: a.d.RoundBank(p)}
+	case Down:
+		return Decimal{d: a.d.RoundDown(p)}
+	case Up:
+		return Decimal{d: a.d.RoundUp(p)}
+	case Floor:
+		return Decimal{d: a.d.RoundFloor(p)}
+	case Ceil:
+		return Decimal{d: a.d.RoundCeil(p)}
+	default:
+		return Decimal{d: a.d.Round(p)}
+	}
+}
+
+func (a Decimal) Cmp(b Decimal) int { return a.d.Cmp(b.d) }
+
+func (a Decimal) Equal(b Decimal) bool { return a.d.Equal(b.d) }
+
+func (a Decimal) IsZero() bool { return a.d.IsZero() }
+
+func (a Decimal) Sign() int { return a.d.Sign() }
+
+// Float64 returns the nearest float64, for statistics that do not need exactness
+func (a Decimal) Float64() float64 {
+	f, _ := a.d.Float64()
+	return f
+}
+
+func (a Decimal) String() string { return a.d.String() }
+
+// Unscaled returns a rounded half up to places decimal places as an integer count of 10^-places units,
+// the representation of fixed point decimal columns
+func (a Decimal) Unscaled(places int) int64 {
+	return a.d.Shift(int32(places)).Round(0).IntPart()
+}
+
+// MarshalJSON encodes the decimal as a bare JSON number, as the float64 fields it replaces were
+func (a Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(a.d.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a quoted decimal string
+func (a *Decimal) UnmarshalJSON(b []byte) error {
+	s := string(bytes.Trim(b, `"`))
+	if s == "null" || s == "" {
+		*a = Zero
+		return nil
+	}
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return fmt.Errorf("invalid decimal %s: %w", b, err)
+	}
+	a.d = d
+	return nil
+}
+
+// MarshalBSONValue stores the decimal as a Decimal128
+func (a Decimal) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	d128, err := primitive.ParseDecimal128(a.d.String())
+	if err != nil {
+		return 0, nil, err
+	}
+	return bson.MarshalValue(d128)
+}
+
+// UnmarshalBSONValue reads a Decimal128, and the doubles and integers stored before decimals were used
+func (a *Decimal) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	rv := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Decimal128:
+		d, err := decimal.NewFromString(rv.Decimal128().String())
+		if err != nil {
+			return err
+		}
+		a.d = d
+	case bsontype.Double:
+		a.d = decimal.NewFromFloat(rv.Double())
+	case bsontype.Int32:
+		a.d = decimal.NewFromInt32(rv.Int32())
+	case bsontype.Int64:
+		a.d = decimal.NewFromInt(rv.Int64())
+	case bsontype.String:
+		d, err := decimal.NewFromString(rv.StringValue())
+		if err != nil {
+			return err
+		}
+		a.d = d
+	case bsontype.Null, bsontype.Undefined:
+		*a = Zero
+	default:
+		return fmt.Errorf("cannot decode BSON %s into a decimal", t)
+	}
+	return nil
+}
+
+// RoundingMode selects how a value is rounded to its precision
+type RoundingMode string
+
+const (
+	// HalfUp rounds halves away from zero, like math.Round. It is the default.
+	HalfUp RoundingMode = "half_up"
+	// HalfEven rounds halves to the even neighbour (banker's rounding)
+	HalfEven RoundingMode = "half_even"
+	// Down truncates toward zero
+	Down RoundingMode = "down"
+	// Up rounds away from zero
+	Up RoundingMode = "up"
+	// Floor rounds toward negative infinity
+	Floor RoundingMode = "floor"
+	// Ceil rounds toward positive infinity
+	Ceil RoundingMode = "ceil"
+)
+
+// ParseRoundingMode validates a configured mode, "" meaning HalfUp
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch mode := RoundingMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return HalfUp, nil
+	case HalfUp, HalfEven, Down, Up, Floor, Ceil:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown rounding mode %q", s)
+	}
+}
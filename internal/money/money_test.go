@@ -0,0 +1,87 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDecimal_ExactSum(t *testing.T) {
+	sum := Zero
+	for i := 0; i < 1000; i++ {
+		sum = sum.Add(NewFromFloat(0.1))
+	}
+	assert.Equal(t, "100", sum.String())
+}
+
+func TestDecimal_Div(t *testing.T) {
+	assert.Equal(t, "0.5", NewFromInt(1).Div(NewFromInt(2)).String())
+	assert.True(t, NewFromInt(1).Div(Zero).IsZero())
+}
+
+func TestDecimal_Round(t *testing.T) {
+	d := RequireFromString("2.345")
+	assert.Equal(t, "2.35", d.Round(2, HalfUp).String())
+	assert.Equal(t, "2.34", d.Round(2, HalfEven).String())
+	assert.Equal(t, "2.34", d.Round(2, Down).String())
+	assert.Equal(t, "2.35", d.Round(2, Up).String())
+	assert.Equal(t, "-2.35", d.Neg().Round(2, Floor).String())
+	assert.Equal(t, "-2.34", d.Neg().Round(2, Ceil).String())
+}
+
+func TestDecimal_Unscaled(t *testing.T) {
+	assert.Equal(t, int64(12345670), RequireFromString("12.34567").Unscaled(6))
+	assert.Equal(t, int64(235), RequireFromString("2.345").Unscaled(2))
+	assert.Equal(t, int64(-235), RequireFromString("-2.345").Unscaled(2))
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	mode, err := ParseRoundingMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, HalfUp, mode)
+	mode, err = ParseRoundingMode("HALF_EVEN")
+	assert.NoError(t, err)
+	assert.Equal(t, HalfEven, mode)
+	_, err = ParseRoundingMode("nearest")
+	assert.Error(t, err)
+}
+
+func TestDecimal_JSON(t *testing.T) {
+	in := struct {
+		Cost Decimal `json:"cost"`
+	}{Cost: RequireFromString("12.34")}
+	b, err := json.Marshal(in)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"cost": 12.34}`, string(b))
+
+	var out struct {
+		Cost Decimal `json:"cost"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"cost": 0.30000000000000004}`), &out))
+	assert.Equal(t, "0.30000000000000004", out.Cost.String())
+	assert.NoError(t, json.Unmarshal([]byte(`{"cost": "7.5"}`), &out))
+	assert.Equal(t, "7.5", out.Cost.String())
+	assert.Error(t, json.Unmarshal([]byte(`{"cost": "abc"}`), &out))
+}
+
+func TestDecimal_BSON(t *testing.T) {
+	type doc struct {
+		Cost Decimal
+	}
+	b, err := bson.Marshal(doc{Cost: RequireFromString("19.99")})
+	assert.NoError(t, err)
+	raw := bson.Raw(b)
+	_, ok := raw.Lookup("cost").Decimal128OK()
+	assert.True(t, ok)
+
+	var out doc
+	assert.NoError(t, bson.Unmarshal(b, &out))
+	assert.Equal(t, "19.99", out.Cost.String())
+
+	// documents written before decimals stored doubles
+	legacy, err := bson.Marshal(bson.M{"cost": 19.99})
+	assert.NoError(t, err)
+	assert.NoError(t, bson.Unmarshal(legacy, &out))
+	assert.Equal(t, "19.99", out.Cost.String())
+}
@@ -0,0 +1,169 @@
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"goetl/internal/models"
+	"goetl/internal/utils"
+)
+
+// Policy decides how contact emails are protected before anything is stored
+type Policy struct {
+	// Salt is prepended to normalized emails before hashing
+	Salt string
+	// Key enables AES-GCM encryption of stored raw payloads when set (16, 24 or 32 bytes)
+	Key []byte
+	// AllowPlaintext keeps ContactEmail on stored records, off unless explicitly enabled
+	AllowPlaintext bool
+	// ReaderTokens are the API tokens of the callers allowed to see contact data unmasked
+	ReaderTokens []string
+}
+
+// LoadFromEnv builds the policy from PII_HASH_SALT, PII_ENCRYPTION_KEY (base64), PII_STORE_PLAINTEXT and
+// PII_READER_TOKENS (comma separated)
+func LoadFromEnv() (*Policy, error) {
+	p := &Policy{
+		Salt:           utils.Getenv("PII_HASH_SALT"),
+		AllowPlaintext: utils.Getenv("PII_STORE_PLAINTEXT") == "true",
+	}
+	for _, token := range strings.Split(utils.Getenv("PII_READER_TOKENS"), ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			p.ReaderTokens = append(p.ReaderTokens, token)
+		}
+	}
+	if k := utils.Getenv("PII_ENCRYPTION_KEY"); k != "" {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("PII_ENCRYPTION_KEY is not valid base64: %w", err)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("PII_ENCRYPTION_KEY must decode to 16, 24 or 32 bytes, got %d", len(key))
+		}
+		p.Key = key
+	}
+	return p, nil
+}
+
+// NormalizeEmail trims and lowercases an email so equal addresses hash equally
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// HashEmail returns the salted SHA-256 hex digest of a normalized email, or "" for an empty email
+func (p *Policy) HashEmail(email string) string {
+	email = NormalizeEmail(email)
+	if email == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(p.Salt + email))
+	return hex.EncodeToString(sum[:])
+}
+
+// RedactEmail masks an email for logs and dead letters, keeping the first character and the domain
+func RedactEmail(email string) string {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return ""
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// Protect hashes the contact email of opp and clears the plaintext unless the policy allows it
+func (p *Policy) Protect(opp models.Opportunity) models.Opportunity {
+	if opp.ContactEmail != "" {
+		opp.ContactEmailHash = p.HashEmail(opp.ContactEmail)
+	}
+	if !p.AllowPlaintext {
+		opp.ContactEmail = ""
+	}
+	return opp
+}
+
+// Redact masks the contact email of opp, keeping its hash so it can still be erased
+func (p *Policy) Redact(opp models.Opportunity) models.Opportunity {
+	if opp.ContactEmail != "" {
+		opp.ContactEmailHash = p.HashEmail(opp.ContactEmail)
+		opp.ContactEmail = RedactEmail(opp.ContactEmail)
+	}
+	return opp
+}
+
+// CanReadContacts reports whether the caller presenting token may see contact data unmasked
+func (p *Policy) CanReadContacts(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, t := range p.ReaderTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Mask returns opp as shown to a caller: unchanged for contact readers, otherwise with the contact email
+// redacted and its hash removed
+func (p *Policy) Mask(opp models.Opportunity, canReadContacts bool) models.Opportunity {
+	if canReadContacts {
+		return opp
+	}
+	opp.ContactEmail = RedactEmail(opp.ContactEmail)
+	opp.ContactEmailHash = ""
+	return opp
+}
+
+// Encrypts reports whether raw payloads are encrypted
+func (p *Policy) Encrypts() bool {
+	return len(p.Key) > 0
+}
+
+// Encrypt seals plaintext with AES-GCM, prefixing the random nonce
+func (p *Policy) Encrypt(plaintext []byte) ([]byte, error) {
+	gcm, err := p.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a payload sealed by Encrypt
+func (p *Policy) Decrypt(ciphertext []byte) ([]byte, error) {
+	gcm, err := p.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func (p *Policy) gcm() (cipher.AEAD, error) {
+	if !p.Encrypts() {
+		return nil, errors.New("no PII encryption key configured")
+	}
+	block, err := aes.NewCipher(p.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
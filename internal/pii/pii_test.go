@@ -0,0 +1,89 @@
+package pii
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+)
+
+func TestHashEmail(t *testing.T) {
+	p := &Policy{Salt: "pepper"}
+	h := p.HashEmail(" Ana@Example.com ")
+	assert.Len(t, h, 64)
+	assert.Equal(t, h, p.HashEmail("ana@example.com"))
+	assert.NotEqual(t, h, (&Policy{Salt: "other"}).HashEmail("ana@example.com"))
+	assert.Equal(t, "", p.HashEmail(""))
+}
+
+func TestRedactEmail(t *testing.T) {
+	assert.Equal(t, "a***@example.com", RedactEmail("ana@example.com"))
+	assert.Equal(t, "***", RedactEmail("not-an-email"))
+	assert.Equal(t, "", RedactEmail(""))
+}
+
+func TestProtect(t *testing.T) {
+	p := &Policy{Salt: "s"}
+	opp := p.Protect(models.Opportunity{OpportunityID: "O1", ContactEmail: "ana@example.com"})
+	assert.Equal(t, "", opp.ContactEmail)
+	assert.Equal(t, p.HashEmail("ana@example.com"), opp.ContactEmailHash)
+
+	p.AllowPlaintext = true
+	opp = p.Protect(models.Opportunity{ContactEmail: "ana@example.com"})
+	assert.Equal(t, "ana@example.com", opp.ContactEmail)
+}
+
+func TestRedact(t *testing.T) {
+	p := &Policy{}
+	opp := p.Redact(models.Opportunity{ContactEmail: "ana@example.com"})
+	assert.Equal(t, "a***@example.com", opp.ContactEmail)
+	assert.Equal(t, p.HashEmail("ana@example.com"), opp.ContactEmailHash)
+}
+
+func TestMask(t *testing.T) {
+	p := &Policy{Salt: "s", ReaderTokens: []string{"reader"}}
+	opp := models.Opportunity{OpportunityID: "O1", ContactEmail: "ana@example.com", ContactEmailHash: "h"}
+	assert.True(t, p.CanReadContacts("reader"))
+	assert.False(t, p.CanReadContacts("other"))
+	assert.False(t, p.CanReadContacts(""))
+
+	assert.Equal(t, opp, p.Mask(opp, true))
+	masked := p.Mask(opp, false)
+	assert.Equal(t, "a***@example.com", masked.ContactEmail)
+	assert.Empty(t, masked.ContactEmailHash)
+	assert.Equal(t, "O1", masked.OpportunityID)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	p := &Policy{Key: []byte("0123456789abcdef0123456789abcdef")}
+	sealed, err := p.Encrypt([]byte("payload"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(sealed), "payload")
+	plain, err := p.Decrypt(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", string(plain))
+
+	sealed[len(sealed)-1] ^= 0xff
+	_, err = p.Decrypt(sealed)
+	assert.Error(t, err)
+
+	_, err = (&Policy{}).Encrypt([]byte("payload"))
+	assert.Error(t, err)
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	os.Setenv("PII_HASH_SALT", "salt")
+	os.Setenv("PII_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+	defer os.Unsetenv("PII_HASH_SALT")
+	defer os.Unsetenv("PII_ENCRYPTION_KEY")
+	p, err := LoadFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, "salt", p.Salt)
+	assert.True(t, p.Encrypts())
+	assert.False(t, p.AllowPlaintext)
+
+	os.Setenv("PII_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte("short")))
+	_, err = LoadFromEnv()
+	assert.ErrorContains(t, err, "16, 24 or 32 bytes")
+}
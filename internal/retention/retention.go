@@ -0,0 +1,180 @@
+package retention
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"goetl/internal/utils"
+)
+
+// Groups of collections sharing a retention period
+const (
+	// Results are the ETL results with their versions and anomalies, expired by date
+	Results = "results"
+	// RawPayloads are the archived extraction payloads
+	RawPayloads = "raw_payloads"
+	// DeadLetters are the discarded records
+	DeadLetters = "dead_letters"
+	// Runs are the quality reports, violations, restatements and rollbacks of past runs
+	Runs = "runs"
+)
+
+// Enforcement modes
+const (
+	// ModePurge deletes expired data with the purge job, archiving it first when an archive directory is set
+	ModePurge = "purge"
+	// ModeTTL lets MongoDB TTL indexes expire the time stamped collections; the purge job still expires
+	// the results, whose dates are not timestamps
+	ModeTTL = "ttl"
+)
+
+// Config holds the retention periods and how they are enforced
+type Config struct {
+	// Days keeps each group for that many days, groups absent or at 0 are kept forever
+	Days map[string]int
+	// Mode is ModePurge or ModeTTL
+	Mode string
+	// Interval is the period of the purge job, 0 disables it
+	Interval time.Duration
+	// ArchiveDir receives the expired data as compressed JSON lines before it is deleted, empty disables it
+	ArchiveDir string
+}
+
+// envDays maps the groups to the variables setting their retention
+var envDays = map[string]string{
+	Results:     "RETENTION_RESULTS_DAYS",
+	RawPayloads: "RETENTION_RAW_PAYLOADS_DAYS",
+	DeadLetters: "RETENTION_DEAD_LETTERS_DAYS",
+	Runs:        "RETENTION_RUNS_DAYS",
+}
+
+// LoadFromEnv reads RETENTION_RESULTS_DAYS, RETENTION_RAW_PAYLOADS_DAYS, RETENTION_DEAD_LETTERS_DAYS,
+// RETENTION_RUNS_DAYS, RETENTION_MODE (default purge), RETENTION_PURGE_INTERVAL (default 24h) and
+// RETENTION_ARCHIVE_DIR
+func LoadFromEnv() (Config, error) {
+	cfg := Config{Days: map[string]int{}, Mode: ModePurge, Interval: 24 * time.Hour, ArchiveDir: utils.Getenv("RETENTION_ARCHIVE_DIR")}
+	for group, name := range envDays {
+		if v := utils.Getenv(name); v != "" {
+			days, err := strconv.Atoi(v)
+			if err != nil || days < 0 {
+				return cfg, fmt.Errorf("invalid %s %q", name, v)
+			}
+			cfg.Days[group] = days
+		}
+	}
+	if v := utils.Getenv("RETENTION_MODE"); v != "" {
+		if v != ModePurge && v != ModeTTL {
+			return cfg, fmt.Errorf("invalid RETENTION_MODE %q, must be %q or %q", v, ModePurge, ModeTTL)
+		}
+		cfg.Mode = v
+	}
+	if v := utils.Getenv("RETENTION_PURGE_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < 0 {
+			return cfg, fmt.Errorf("invalid RETENTION_PURGE_INTERVAL %q", v)
+		}
+		cfg.Interval = interval
+	}
+	return cfg, nil
+}
+
+// Enabled reports whether any group has a retention period
+func (c Config) Enabled() bool {
+	for _, days := range c.Days {
+		if days > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Cutoff returns the time before which the data of group expires at now, false when it is kept forever
+func (c Config) Cutoff(group string, now time.Time) (time.Time, bool) {
+	days := c.Days[group]
+	if days <= 0 {
+		return time.Time{}, false
+	}
+	return now.UTC().AddDate(0, 0, -days), true
+}
+
+// TTLSeconds returns the expireAfterSeconds of the TTL indexes of group, 0 when MongoDB does not expire it
+func (c Config) TTLSeconds(group string) int32 {
+	if c.Mode != ModeTTL || group == Results {
+		return 0
+	}
+	return int32(c.Days[group] * 24 * 60 * 60)
+}
+
+// Archive is a gzip compressed JSON lines file. It is written under a temporary name and renamed by Close,
+// so a file with the final name is always complete.
+type Archive struct {
+	path  string
+	file  *os.File
+	gz    *gzip.Writer
+	buf   *bufio.Writer
+	Lines int
+}
+
+// CreateArchive starts the archive of collection at dir/collection-<at>.jsonl.gz, creating dir if needed
+func CreateArchive(dir, collection string, at time.Time) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, collection+"-"+at.UTC().Format("20060102T150405.000Z")+".jsonl.gz")
+	file, err := os.CreateTemp(dir, "."+collection+"-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(file)
+	return &Archive{path: path, file: file, gz: gz, buf: bufio.NewWriter(gz)}, nil
+}
+
+// Path returns the final name of the archive
+func (a *Archive) Path() string {
+	return a.path
+}
+
+// Write appends v as one JSON line
+func (a *Archive) Write(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := a.buf.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	a.Lines++
+	return nil
+}
+
+// Close flushes the archive to disk and gives it its final name. The temporary file is removed on error.
+func (a *Archive) Close() error {
+	err := a.buf.Flush()
+	if err == nil {
+		err = a.gz.Close()
+	}
+	if err == nil {
+		err = a.file.Sync()
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(a.file.Name(), a.path)
+	}
+	if err != nil {
+		os.Remove(a.file.Name())
+	}
+	return err
+}
+
+// Abort discards the archive
+func (a *Archive) Abort() {
+	a.file.Close()
+	os.Remove(a.file.Name())
+}
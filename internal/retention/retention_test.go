@@ -0,0 +1,83 @@
+package retention
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFromEnv(t *testing.T) {
+	os.Setenv("RETENTION_RESULTS_DAYS", "400")
+	os.Setenv("RETENTION_DEAD_LETTERS_DAYS", "30")
+	os.Setenv("RETENTION_MODE", "ttl")
+	defer os.Unsetenv("RETENTION_RESULTS_DAYS")
+	defer os.Unsetenv("RETENTION_DEAD_LETTERS_DAYS")
+	defer os.Unsetenv("RETENTION_MODE")
+	cfg, err := LoadFromEnv()
+	assert.NoError(t, err)
+	assert.True(t, cfg.Enabled())
+	assert.Equal(t, 400, cfg.Days[Results])
+	assert.Equal(t, 24*time.Hour, cfg.Interval)
+	assert.Equal(t, int32(30*86400), cfg.TTLSeconds(DeadLetters))
+	// results are expired by date, never by a TTL index
+	assert.Equal(t, int32(0), cfg.TTLSeconds(Results))
+	assert.Equal(t, int32(0), cfg.TTLSeconds(Runs))
+
+	os.Setenv("RETENTION_RUNS_DAYS", "-1")
+	defer os.Unsetenv("RETENTION_RUNS_DAYS")
+	_, err = LoadFromEnv()
+	assert.Error(t, err)
+}
+
+func TestCutoff(t *testing.T) {
+	cfg := Config{Days: map[string]int{Results: 90}}
+	now := time.Date(2025, 10, 19, 8, 0, 0, 0, time.UTC)
+	cutoff, ok := cfg.Cutoff(Results, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 7, 21, 8, 0, 0, 0, time.UTC), cutoff)
+	_, ok = cfg.Cutoff(RawPayloads, now)
+	assert.False(t, ok)
+	assert.False(t, Config{}.Enabled())
+}
+
+func TestArchive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "archive")
+	a, err := CreateArchive(dir, "dead_letters", time.Date(2025, 10, 19, 8, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.NoError(t, a.Write(map[string]int{"n": 1}))
+	assert.NoError(t, a.Write(json.RawMessage(`{"n": 2}`)))
+	_, err = os.Stat(a.Path())
+	assert.True(t, os.IsNotExist(err), "the archive is only visible once closed")
+	assert.NoError(t, a.Close())
+	assert.Equal(t, filepath.Join(dir, "dead_letters-20251019T080000.000Z.jsonl.gz"), a.Path())
+
+	f, err := os.Open(a.Path())
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	scanner := bufio.NewScanner(gz)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, []string{`{"n":1}`, `{"n":2}`}, lines)
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
+
+func TestArchive_Abort(t *testing.T) {
+	dir := t.TempDir()
+	a, err := CreateArchive(dir, "raw_payloads", time.Now())
+	assert.NoError(t, err)
+	assert.NoError(t, a.Write(map[string]int{"n": 1}))
+	a.Abort()
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+}
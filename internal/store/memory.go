@@ -0,0 +1,183 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+	"goetl/internal/models"
+)
+
+// Memory keeps results in process memory, for tests and local runs. Its content is lost on exit.
+type Memory struct {
+	mu       sync.RWMutex
+	results  map[string]models.ETLResult
+	versions []models.ResultVersion
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{results: map[string]models.ETLResult{}}
+}
+
+// Save upserts results by their key
+func (m *Memory) Save(ctx context.Context, results []models.ETLResult) (models.LoadSummary, []models.LoadFailure) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.save(results, writeTime()), nil
+}
+
+func (m *Memory) save(results []models.ETLResult, writtenAt time.Time) models.LoadSummary {
+	summary := models.LoadSummary{Rows: len(results), Batches: 1}
+	for _, res := range results {
+		if _, ok := m.results[Key(res)]; ok {
+			summary.Modified++
+		} else {
+			summary.Inserted++
+		}
+		m.results[Key(res)] = res
+		m.versions = append(m.versions, models.ResultVersion{ETLResult: res, WrittenAt: writtenAt})
+	}
+	return summary
+}
+
+// Replace upserts results and deletes the stale rows of their range
+func (m *Memory) Replace(ctx context.Context, runID, from string, results []models.ETLResult) (models.LoadSummary, error) {
+	channels := map[string]bool{}
+	for _, c := range Channels(results) {
+		channels[c] = true
+	}
+	from, to := ReplaceBounds(from, results)
+	return m.replace(runID, results, func(res models.ETLResult) bool {
+		return res.Date >= from && res.Date <= to && channels[res.Channel]
+	}), nil
+}
+
+// Restore upserts results and deletes the other rows matching q
+func (m *Memory) Restore(ctx context.Context, runID string, q Query, results []models.ETLResult) (models.LoadSummary, error) {
+	return m.replace(runID, results, q.Match), nil
+}
+
+// replace stamps results with runID, saves them and deletes the rows in partition they did not rewrite
+func (m *Memory) replace(runID string, results []models.ETLResult, partition func(models.ETLResult) bool) models.LoadSummary {
+	for i := range results {
+		results[i].RunID = runID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writtenAt := writeTime()
+	summary := m.save(results, writtenAt)
+	summary.Mode = models.LoadTransaction
+	for key, res := range m.results {
+		if partition(res) && res.RunID != runID {
+			delete(m.results, key)
+			m.versions = append(m.versions, tombstone(res, runID, writtenAt))
+			summary.Deleted++
+		}
+	}
+	return summary
+}
+
+// Query returns the matching results ordered by date, channel and campaign id
+func (m *Memory) Query(ctx context.Context, q Query) ([]models.ETLResult, error) {
+	m.mu.RLock()
+	var results []models.ETLResult
+	for _, res := range m.results {
+		if q.Match(res) {
+			results = append(results, res)
+		}
+	}
+	m.mu.RUnlock()
+	sortResults(results)
+	return Paginate(results, q), nil
+}
+
+// Count counts the matching results
+func (m *Memory) Count(ctx context.Context, q Query) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for _, res := range m.results {
+		if q.Match(res) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Each calls fn with the matching results
+func (m *Memory) Each(ctx context.Context, q Query, fn func(models.ETLResult) error) error {
+	results, _ := m.Query(ctx, q)
+	for _, res := range results {
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Aggregate sums the matching results by the groupBy fields
+func (m *Memory) Aggregate(ctx context.Context, q Query, groupBy ...string) ([]models.ResultAggregate, error) {
+	if err := checkGroupBy(groupBy); err != nil {
+		return nil, err
+	}
+	q.Limit, q.Offset = 0, 0
+	results, _ := m.Query(ctx, q)
+	return aggregate(results, groupBy), nil
+}
+
+// Delete removes the matching results
+func (m *Memory) Delete(ctx context.Context, q Query) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writtenAt := writeTime()
+	deleted := 0
+	for key, res := range m.results {
+		if q.Match(res) {
+			delete(m.results, key)
+			m.versions = append(m.versions, tombstone(res, "", writtenAt))
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// History returns the matching versions
+func (m *Memory) History(ctx context.Context, q Query, until time.Time) ([]models.ResultVersion, error) {
+	m.mu.RLock()
+	var versions []models.ResultVersion
+	for _, v := range m.versions {
+		if q.Match(v.ETLResult) && (until.IsZero() || !v.WrittenAt.After(until)) {
+			versions = append(versions, v)
+		}
+	}
+	m.mu.RUnlock()
+	sortVersions(versions)
+	return versions, nil
+}
+
+// Purge removes the matching results and versions
+func (m *Memory) Purge(ctx context.Context, q Query) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rows := 0
+	for key, res := range m.results {
+		if q.Match(res) {
+			delete(m.results, key)
+			rows++
+		}
+	}
+	kept := m.versions[:0]
+	for _, v := range m.versions {
+		if !q.Match(v.ETLResult) {
+			kept = append(kept, v)
+		}
+	}
+	versions := len(m.versions) - len(kept)
+	m.versions = kept
+	return rows, versions, nil
+}
+
+// Close does nothing, the content stays available
+func (m *Memory) Close() error {
+	return nil
+}
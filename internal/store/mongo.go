@@ -0,0 +1,614 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"goetl/internal/db"
+	"goetl/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoCollection holds the results of the mongo backend
+const MongoCollection = "etl_results"
+
+// MongoVersionsCollection holds the version history of the mongo backend
+const MongoVersionsCollection = "etl_result_versions"
+
+const mongoStagingCollection = MongoCollection + "_staging"
+
+// Mongo stores results in the etl_results collection of the goetl MongoDB database and their versions in
+// etl_result_versions
+type Mongo struct {
+	batchSize int
+	swap      bool
+
+	// writeMu makes the other writes of the process wait for a swap replacement, which would lose them
+	writeMu sync.RWMutex
+
+	seedMu sync.Mutex
+	seeded bool
+}
+
+// NewMongo returns the MongoDB backend, replacing ranges batchSize rows per bulk write and, on a standalone
+// server, through a swapped copy of the collection when swap is set
+func NewMongo(batchSize int, swap bool) *Mongo {
+	return &Mongo{batchSize: batchSize, swap: swap}
+}
+
+func (m *Mongo) collection() (*mongo.Database, *mongo.Collection, error) {
+	database, err := db.GetDatabase()
+	if err != nil {
+		return nil, nil, errors.Join(ErrUnavailable, err)
+	}
+	return database, database.Collection(MongoCollection), nil
+}
+
+// versions returns etl_result_versions, first copying etl_results into it when it is empty so the rows
+// loaded before versioning become the first version of their key
+func (m *Mongo) versions(ctx context.Context, database *mongo.Database) (*mongo.Collection, error) {
+	versions := database.Collection(MongoVersionsCollection)
+	m.seedMu.Lock()
+	defer m.seedMu.Unlock()
+	if m.seeded {
+		return versions, nil
+	}
+	n, err := versions.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		pipeline := mongo.Pipeline{
+			{{Key: "$project", Value: bson.M{"_id": 0}}},
+			{{Key: "$addFields", Value: bson.M{"writtenat": time.Unix(0, 0).UTC(), "deleted": false}}},
+			{{Key: "$merge", Value: bson.M{"into": MongoVersionsCollection}}},
+		}
+		cursor, err := database.Collection(MongoCollection).Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		cursor.Close(ctx)
+	}
+	m.seeded = true
+	return versions, nil
+}
+
+// recordVersions appends versions to etl_result_versions
+func (m *Mongo) recordVersions(ctx context.Context, database *mongo.Database, versions []models.ResultVersion) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	collection, err := m.versions(ctx, database)
+	if err != nil {
+		return err
+	}
+	docs := make([]interface{}, len(versions))
+	for i, v := range versions {
+		docs[i] = v
+	}
+	_, err = collection.InsertMany(ctx, docs)
+	return err
+}
+
+// writes returns the versions of results written at writtenAt, skipping the failed rows
+func writes(results []models.ETLResult, failures []models.LoadFailure, writtenAt time.Time) []models.ResultVersion {
+	failed := make(map[string]bool, len(failures))
+	for _, f := range failures {
+		failed[f.Date+":"+f.Channel+":"+f.CampaignID] = true
+	}
+	versions := make([]models.ResultVersion, 0, len(results))
+	for _, res := range results {
+		if !failed[Key(res)] {
+			versions = append(versions, models.ResultVersion{ETLResult: res, WrittenAt: writtenAt})
+		}
+	}
+	return versions
+}
+
+// Save upserts results by (date, channel, campaignid) in one unordered BulkWrite, so a failing row
+// does not stop the rest, then records the versions of the rows written. Versions that fail to be
+// recorded are logged, the rows stay written.
+func (m *Mongo) Save(ctx context.Context, results []models.ETLResult) (models.LoadSummary, []models.LoadFailure) {
+	database, collection, err := m.collection()
+	if err != nil {
+		summary := models.LoadSummary{Rows: len(results), Batches: 1, Failed: len(results)}
+		return summary, Failures(results, err)
+	}
+	m.writeMu.RLock()
+	defer m.writeMu.RUnlock()
+	writtenAt := writeTime()
+	summary, failures := mongoUpsert(ctx, collection, results)
+	if err := m.recordVersions(ctx, database, writes(results, failures, writtenAt)); err != nil {
+		log.Printf("Error recording result versions: %v", err)
+	}
+	return summary, failures
+}
+
+// mongoUpsert upserts results into collection with one unordered BulkWrite and returns the rows that were not written
+func mongoUpsert(ctx context.Context, collection *mongo.Collection, results []models.ETLResult) (models.LoadSummary, []models.LoadFailure) {
+	summary := models.LoadSummary{Rows: len(results), Batches: 1}
+	if len(results) == 0 {
+		return summary, nil
+	}
+	writes := make([]mongo.WriteModel, 0, len(results))
+	for _, res := range results {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"date": res.Date, "channel": res.Channel, "campaignid": res.CampaignID}).
+			SetUpdate(bson.M{"$set": res}).
+			SetUpsert(true))
+	}
+	result, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if result != nil {
+		summary.Inserted = int(result.UpsertedCount)
+		summary.Modified = int(result.ModifiedCount)
+		summary.Unchanged = int(result.MatchedCount - result.ModifiedCount)
+	}
+	if err == nil {
+		return summary, nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		// the outcome of the batch is unknown, report every row as failed
+		summary.Inserted, summary.Modified, summary.Unchanged = 0, 0, 0
+		summary.Failed = len(results)
+		return summary, Failures(results, err)
+	}
+	failures := make([]models.LoadFailure, 0, len(bulkErr.WriteErrors))
+	for _, we := range bulkErr.WriteErrors {
+		if we.Index < 0 || we.Index >= len(results) {
+			continue
+		}
+		failures = append(failures, Failure(results[we.Index], we.Message))
+	}
+	summary.Failed = len(failures)
+	return summary, failures
+}
+
+// Replace runs in a transaction when MongoDB is a replica set, so readers never see a half replaced range.
+// On a standalone server it upserts and deletes in place, or goes through a swapped copy when swap is set.
+func (m *Mongo) Replace(ctx context.Context, runID, from string, results []models.ETLResult) (models.LoadSummary, error) {
+	from, to := ReplaceBounds(from, results)
+	stale := mongoFilter(Query{From: from, To: to})
+	stale["channel"] = bson.M{"$in": Channels(results)}
+	return m.replace(ctx, runID, stale, results)
+}
+
+// Restore upserts results and deletes the other rows matching q, like Replace
+func (m *Mongo) Restore(ctx context.Context, runID string, q Query, results []models.ETLResult) (models.LoadSummary, error) {
+	return m.replace(ctx, runID, mongoFilter(q), results)
+}
+
+// replace stamps results with runID, upserts them and deletes the rows matching partition they did not rewrite
+func (m *Mongo) replace(ctx context.Context, runID string, partition bson.M, results []models.ETLResult) (models.LoadSummary, error) {
+	for i := range results {
+		results[i].RunID = runID
+	}
+	database, _, err := m.collection()
+	if err != nil {
+		return models.LoadSummary{}, err
+	}
+	stale := bson.M{"$and": bson.A{partition, bson.M{"runid": bson.M{"$ne": runID}}}}
+	var summary models.LoadSummary
+	switch {
+	case db.SupportsTransactions():
+		m.writeMu.RLock()
+		defer m.writeMu.RUnlock()
+		summary, err = m.replaceInTransaction(ctx, database, runID, stale, results)
+		summary.Mode = models.LoadTransaction
+	case m.swap:
+		m.writeMu.Lock()
+		defer m.writeMu.Unlock()
+		summary, err = m.replaceBySwap(ctx, database, runID, stale, results)
+		summary.Mode = models.LoadSwap
+	default:
+		m.writeMu.RLock()
+		defer m.writeMu.RUnlock()
+		summary, err = m.replaceInPlace(ctx, database, runID, stale, results)
+		summary.Mode = models.LoadInPlace
+	}
+	return summary, err
+}
+
+// replaceInPlace upserts results into etl_results, deletes the stale rows and records the versions. Readers
+// may see the range half replaced, and a failure leaves the batches already written.
+func (m *Mongo) replaceInPlace(ctx context.Context, database *mongo.Database, runID string, stale bson.M, results []models.ETLResult) (models.LoadSummary, error) {
+	var summary models.LoadSummary
+	if _, err := m.versions(ctx, database); err != nil {
+		return summary, err
+	}
+	versions, err := m.writeRange(ctx, database.Collection(MongoCollection), runID, stale, results, &summary)
+	if err != nil {
+		return summary, err
+	}
+	if err := m.recordVersions(ctx, database, versions); err != nil {
+		log.Printf("Error recording result versions: %v", err)
+	}
+	return summary, nil
+}
+
+// replaceInTransaction upserts results, deletes the stale rows and records the versions in a single transaction
+func (m *Mongo) replaceInTransaction(ctx context.Context, database *mongo.Database, runID string, stale bson.M, results []models.ETLResult) (models.LoadSummary, error) {
+	if _, err := m.versions(ctx, database); err != nil {
+		return models.LoadSummary{}, err
+	}
+	session, err := database.Client().StartSession()
+	if err != nil {
+		return models.LoadSummary{}, err
+	}
+	defer session.EndSession(ctx)
+	collection := database.Collection(MongoCollection)
+	var summary models.LoadSummary
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// the callback is retried on transient errors
+		summary = models.LoadSummary{}
+		versions, err := m.writeRange(sc, collection, runID, stale, results, &summary)
+		if err != nil {
+			return nil, err
+		}
+		return nil, m.recordVersions(sc, database, versions)
+	})
+	return summary, err
+}
+
+// replaceBySwap copies etl_results into a staging collection with its indexes, applies the replacement
+// there and renames it over etl_results, then records the versions. The other writes of the process wait
+// for it; rows written to etl_results by other processes during the copy are lost.
+func (m *Mongo) replaceBySwap(ctx context.Context, database *mongo.Database, runID string, stale bson.M, results []models.ETLResult) (models.LoadSummary, error) {
+	collection := database.Collection(MongoCollection)
+	staging := database.Collection(mongoStagingCollection)
+	var summary models.LoadSummary
+	if _, err := m.versions(ctx, database); err != nil {
+		return summary, err
+	}
+	if err := staging.Drop(ctx); err != nil {
+		return summary, err
+	}
+	var versions []models.ResultVersion
+	err := func() error {
+		pipeline := mongo.Pipeline{{{Key: "$out", Value: mongoStagingCollection}}}
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		cursor.Close(ctx)
+		if err := copyIndexes(ctx, collection, staging); err != nil {
+			return err
+		}
+		if versions, err = m.writeRange(ctx, staging, runID, stale, results, &summary); err != nil {
+			return err
+		}
+		rename := bson.D{
+			{Key: "renameCollection", Value: database.Name() + "." + mongoStagingCollection},
+			{Key: "to", Value: database.Name() + "." + MongoCollection},
+			{Key: "dropTarget", Value: true},
+		}
+		return database.Client().Database("admin").RunCommand(ctx, rename).Err()
+	}()
+	if err != nil {
+		staging.Drop(ctx)
+		return summary, err
+	}
+	if err := m.recordVersions(ctx, database, versions); err != nil {
+		log.Printf("Error recording result versions: %v", err)
+	}
+	return summary, nil
+}
+
+// writeRange upserts results into collection in batches and deletes the rows matching stale, stopping at
+// the first failure. It returns the versions to record: the rows written and the tombstones of the rows
+// deleted by runID.
+func (m *Mongo) writeRange(ctx context.Context, collection *mongo.Collection, runID string, stale bson.M, results []models.ETLResult, summary *models.LoadSummary) ([]models.ResultVersion, error) {
+	writtenAt := writeTime()
+	for start := 0; start < len(results); start += m.batchSize {
+		end := start + m.batchSize
+		if end > len(results) {
+			end = len(results)
+		}
+		s, failures := mongoUpsert(ctx, collection, results[start:end])
+		summary.Add(s)
+		if len(failures) > 0 {
+			return nil, errors.New(failures[0].Error)
+		}
+	}
+	versions := writes(results, nil, writtenAt)
+	tombstones, deleted, err := mongoDelete(ctx, collection, stale, runID, writtenAt)
+	if err != nil {
+		return nil, err
+	}
+	summary.Deleted = deleted
+	return append(versions, tombstones...), nil
+}
+
+// mongoDelete deletes the documents of collection matching filter and returns their tombstones, deleted by
+// runID at writtenAt
+func mongoDelete(ctx context.Context, collection *mongo.Collection, filter bson.M, runID string, writtenAt time.Time) ([]models.ResultVersion, int, error) {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	var ids bson.A
+	var tombstones []models.ResultVersion
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID               interface{} `bson:"_id"`
+			models.ETLResult `bson:",inline"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, doc.ID)
+		tombstones = append(tombstones, tombstone(doc.ETLResult, runID, writtenAt))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(ids) == 0 {
+		return nil, 0, nil
+	}
+	res, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, 0, err
+	}
+	return tombstones, int(res.DeletedCount), nil
+}
+
+// copyIndexes creates the indexes of src on dst
+func copyIndexes(ctx context.Context, src, dst *mongo.Collection) error {
+	specs, err := src.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if spec.Name == "_id_" {
+			continue
+		}
+		opts := options.Index().SetName(spec.Name)
+		if spec.Unique != nil {
+			opts.SetUnique(*spec.Unique)
+		}
+		if spec.Sparse != nil {
+			opts.SetSparse(*spec.Sparse)
+		}
+		if spec.ExpireAfterSeconds != nil {
+			opts.SetExpireAfterSeconds(*spec.ExpireAfterSeconds)
+		}
+		model := mongo.IndexModel{Keys: spec.KeysDocument, Options: opts}
+		if _, err := dst.Indexes().CreateOne(ctx, model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the matching results ordered by date, channel and campaign id
+func (m *Mongo) Query(ctx context.Context, q Query) ([]models.ETLResult, error) {
+	var results []models.ETLResult
+	err := m.Each(ctx, q, func(res models.ETLResult) error {
+		results = append(results, res)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Each decodes the matching results from the cursor one at a time, sorted on the unique key index
+func (m *Mongo) Each(ctx context.Context, q Query, fn func(models.ETLResult) error) error {
+	_, collection, err := m.collection()
+	if err != nil {
+		return err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	if q.Offset > 0 {
+		opts.SetSkip(int64(q.Offset))
+	}
+	filter := mongoFilter(q)
+	if q.After != nil {
+		filter["$or"] = mongoAfter(*q.After)
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var res models.ETLResult
+		if err := cursor.Decode(&res); err != nil {
+			return err
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Count counts the matching results with CountDocuments
+func (m *Mongo) Count(ctx context.Context, q Query) (int, error) {
+	_, collection, err := m.collection()
+	if err != nil {
+		return 0, err
+	}
+	count, err := collection.CountDocuments(ctx, mongoFilter(q))
+	return int(count), err
+}
+
+// Aggregate sums the matching results with a $group stage
+func (m *Mongo) Aggregate(ctx context.Context, q Query, groupBy ...string) ([]models.ResultAggregate, error) {
+	if err := checkGroupBy(groupBy); err != nil {
+		return nil, err
+	}
+	_, collection, err := m.collection()
+	if err != nil {
+		return nil, err
+	}
+	id := bson.D{}
+	sort := bson.D{}
+	for _, g := range groupBy {
+		field := mongoField(g)
+		id = append(id, bson.E{Key: field, Value: "$" + field})
+		sort = append(sort, bson.E{Key: "_id." + field, Value: 1})
+	}
+	group := bson.D{{Key: "_id", Value: id}, {Key: "rows", Value: bson.M{"$sum": 1}}}
+	for _, field := range []string{"clicks", "impressions", "cost", "leads", "opportunities", "closedwon", "revenue", "pipeline"} {
+		group = append(group, bson.E{Key: field, Value: bson.M{"$sum": "$" + field}})
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: mongoFilter(q)}}, {{Key: "$group", Value: group}}}
+	if len(sort) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var aggregates []models.ResultAggregate
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID                     map[string]string `bson:"_id"`
+			models.ResultAggregate `bson:",inline"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		doc.ResultAggregate.Date = doc.ID["date"]
+		doc.ResultAggregate.Channel = doc.ID["channel"]
+		doc.ResultAggregate.CampaignID = doc.ID["campaignid"]
+		aggregates = append(aggregates, doc.ResultAggregate)
+	}
+	return aggregates, cursor.Err()
+}
+
+// Delete removes the matching results and records their tombstones
+func (m *Mongo) Delete(ctx context.Context, q Query) (int, error) {
+	database, collection, err := m.collection()
+	if err != nil {
+		return 0, err
+	}
+	m.writeMu.RLock()
+	defer m.writeMu.RUnlock()
+	tombstones, deleted, err := mongoDelete(ctx, collection, mongoFilter(q), "", writeTime())
+	if err != nil {
+		return 0, err
+	}
+	if err := m.recordVersions(ctx, database, tombstones); err != nil {
+		log.Printf("Error recording result versions: %v", err)
+	}
+	return deleted, nil
+}
+
+// History returns the matching versions from etl_result_versions
+func (m *Mongo) History(ctx context.Context, q Query, until time.Time) ([]models.ResultVersion, error) {
+	database, _, err := m.collection()
+	if err != nil {
+		return nil, err
+	}
+	collection, err := m.versions(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	filter := mongoFilter(q)
+	if !until.IsZero() {
+		filter["writtenat"] = bson.M{"$lte": until}
+	}
+	sort := bson.D{{Key: "date", Value: 1}, {Key: "channel", Value: 1}, {Key: "campaignid", Value: 1}, {Key: "writtenat", Value: 1}, {Key: "_id", Value: 1}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(sort))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var versions []models.ResultVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Purge removes the matching results, then their versions. The versions are seeded first, so the history
+// of the remaining results stays complete.
+func (m *Mongo) Purge(ctx context.Context, q Query) (int, int, error) {
+	database, collection, err := m.collection()
+	if err != nil {
+		return 0, 0, err
+	}
+	versions, err := m.versions(ctx, database)
+	if err != nil {
+		return 0, 0, err
+	}
+	m.writeMu.RLock()
+	defer m.writeMu.RUnlock()
+	filter := mongoFilter(q)
+	rows, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, err := versions.DeleteMany(ctx, filter)
+	if err != nil {
+		return int(rows.DeletedCount), 0, err
+	}
+	return int(rows.DeletedCount), int(removed.DeletedCount), nil
+}
+
+// Close does nothing, the MongoDB client is shared
+func (m *Mongo) Close() error {
+	return nil
+}
+
+// mongoFilter translates q into a filter on the lowercase field names of ETLResult
+func mongoFilter(q Query) bson.M {
+	filter := bson.M{}
+	// the range and the exact date both apply, as in sqlWhere and Query.Match
+	date := bson.M{}
+	if q.From != "" {
+		date["$gte"] = q.From
+	}
+	if q.To != "" {
+		date["$lte"] = q.To
+	}
+	if q.Date != "" {
+		date["$eq"] = q.Date
+	}
+	if len(date) > 0 {
+		filter["date"] = date
+	}
+	fields := map[string]string{
+		"runid":       q.RunID,
+		"channel":     q.Channel,
+		"campaignid":  q.CampaignID,
+		"utmcampaign": q.UTMCampaign,
+		"utmsource":   q.UTMSource,
+		"utmmedium":   q.UTMMedium,
+	}
+	for k, v := range fields {
+		if v != "" {
+			filter[k] = v
+		}
+	}
+	return filter
+}
+
+// mongoAfter returns the $or clauses matching the results sorting after c
+func mongoAfter(c Cursor) []bson.M {
+	return []bson.M{
+		{"date": bson.M{"$gt": c.Date}},
+		{"date": c.Date, "channel": bson.M{"$gt": c.Channel}},
+		{"date": c.Date, "channel": c.Channel, "campaignid": bson.M{"$gt": c.CampaignID}},
+	}
+}
+
+// mongoField returns the stored field name of a group field
+func mongoField(group string) string {
+	if group == GroupCampaign {
+		return "campaignid"
+	}
+	return group
+}
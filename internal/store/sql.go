@@ -0,0 +1,306 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"goetl/internal/models"
+	"goetl/internal/money"
+)
+
+// resultColumns are the etl_results columns in ETLResult field order
+var resultColumns = []string{
+	"run_id", "transform_version", "date", "channel", "campaign_id", "utm_campaign", "utm_source", "utm_medium",
+	"clicks", "impressions", "cost", "leads", "opportunities", "closed_won", "revenue", "pipeline",
+	"cpc", "cpa", "cvr_lead_to_opp", "cvr_opp_to_won", "roas", "metrics",
+}
+
+// numericColumns hold money.Decimal values
+var numericColumns = map[string]bool{
+	"cost": true, "revenue": true, "pipeline": true, "cpc": true, "cpa": true,
+	"cvr_lead_to_opp": true, "cvr_opp_to_won": true, "roas": true,
+}
+
+// versionColumns are the columns etl_result_versions adds to resultColumns
+var versionColumns = []string{"written_at", "deleted"}
+
+// sqlTimeLayout formats the write times of versions, as text that sorts chronologically
+const sqlTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// sqlRowsPerStatement bounds the rows of multi-row statements, keeping their parameters under the limits of
+// both backends
+const sqlRowsPerStatement = 500
+
+// sqlDialect holds what differs between the SQL backends
+type sqlDialect struct {
+	// placeholder returns the placeholder of the nth argument
+	placeholder func(n int) string
+	// cast returns the cast of the placeholder of a column value
+	cast func(column string) string
+	// selectExpr returns the expression selecting column as the text or integer scanResult reads
+	selectExpr func(column string) string
+}
+
+// selects returns the select list of columns
+func (d sqlDialect) selects(columns []string) string {
+	exprs := make([]string, len(columns))
+	for i, c := range columns {
+		exprs[i] = d.selectExpr(c)
+	}
+	return strings.Join(exprs, ", ")
+}
+
+// whereFunc builds a WHERE clause, or an empty string, numbering its placeholders with placeholder
+type whereFunc func(placeholder func(n int) string) (string, []interface{})
+
+// queryWhere matches the rows of q
+func queryWhere(q Query) whereFunc {
+	return func(placeholder func(n int) string) (string, []interface{}) {
+		return sqlWhere(q, placeholder)
+	}
+}
+
+// rangeWhere matches the dates from from to to of channels
+func rangeWhere(from, to string, channels []string) whereFunc {
+	return func(placeholder func(n int) string) (string, []interface{}) {
+		args := []interface{}{from, to}
+		in := make([]string, len(channels))
+		for i, c := range channels {
+			args = append(args, c)
+			in[i] = placeholder(len(args))
+		}
+		return " WHERE date >= " + placeholder(1) + " AND date <= " + placeholder(2) + " AND channel IN (" + strings.Join(in, ", ") + ")", args
+	}
+}
+
+// staleWhere matches the rows of partition not written by runID
+func staleWhere(partition whereFunc, runID string) whereFunc {
+	return func(placeholder func(n int) string) (string, []interface{}) {
+		where, args := partition(placeholder)
+		args = append(args, runID)
+		if where == "" {
+			return " WHERE run_id <> " + placeholder(len(args)), args
+		}
+		return where + " AND run_id <> " + placeholder(len(args)), args
+	}
+}
+
+// shift offsets the placeholders of where by n, for arguments placed before its own
+func shift(placeholder func(n int) string, n int) func(int) string {
+	return func(i int) string { return placeholder(i + n) }
+}
+
+// recordWrites appends the stored rows of the keys of results to etl_result_versions as written at writtenAt.
+// It runs after they are upserted.
+func recordWrites(ctx context.Context, tx *sql.Tx, d sqlDialect, results []models.ETLResult, writtenAt time.Time) error {
+	for start := 0; start < len(results); start += sqlRowsPerStatement {
+		end := start + sqlRowsPerStatement
+		if end > len(results) {
+			end = len(results)
+		}
+		args := []interface{}{writtenAt.Format(sqlTimeLayout)}
+		keys := make([]string, 0, end-start)
+		for _, res := range results[start:end] {
+			args = append(args, res.Date, res.Channel, res.CampaignID)
+			n := len(args)
+			keys = append(keys, "("+d.placeholder(n-2)+", "+d.placeholder(n-1)+", "+d.placeholder(n)+")")
+		}
+		columns := strings.Join(resultColumns, ", ")
+		query := "INSERT INTO etl_result_versions (" + columns + ", written_at) SELECT " + columns + ", " +
+			d.placeholder(1) + d.cast("written_at") + " FROM etl_results WHERE (date, channel, campaign_id) IN (VALUES " +
+			strings.Join(keys, ", ") + ")"
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRows deletes the rows matching where, appending their tombstones to etl_result_versions as deleted
+// by runID at writtenAt, and returns how many were deleted
+func deleteRows(ctx context.Context, tx *sql.Tx, d sqlDialect, runID string, writtenAt time.Time, where whereFunc) (int, error) {
+	selects := make([]string, len(resultColumns))
+	for i, c := range resultColumns {
+		selects[i] = c
+		if c == "run_id" {
+			selects[i] = d.placeholder(1)
+		}
+	}
+	cond, args := where(shift(d.placeholder, 2))
+	args = append([]interface{}{runID, writtenAt.Format(sqlTimeLayout)}, args...)
+	query := "INSERT INTO etl_result_versions (" + strings.Join(resultColumns, ", ") + ", written_at, deleted) SELECT " +
+		strings.Join(selects, ", ") + ", " + d.placeholder(2) + d.cast("written_at") + ", TRUE FROM etl_results" + cond
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return 0, err
+	}
+	cond, args = where(d.placeholder)
+	res, err := tx.ExecContext(ctx, "DELETE FROM etl_results"+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
+
+// purgeRows deletes the rows matching q from etl_results and etl_result_versions and returns how many of
+// each were deleted
+func purgeRows(ctx context.Context, tx *sql.Tx, d sqlDialect, q Query) (int, int, error) {
+	var counts [2]int
+	for i, table := range []string{"etl_results", "etl_result_versions"} {
+		where, args := sqlWhere(q, d.placeholder)
+		res, err := tx.ExecContext(ctx, "DELETE FROM "+table+where, args...)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, 0, err
+		}
+		counts[i] = int(n)
+	}
+	return counts[0], counts[1], nil
+}
+
+// queryHistory returns the versions matching q written up to until, every version when zero
+func queryHistory(ctx context.Context, conn *sql.DB, d sqlDialect, q Query, until time.Time) ([]models.ResultVersion, error) {
+	where, args := sqlWhere(q, d.placeholder)
+	if !until.IsZero() {
+		args = append(args, until.UTC().Format(sqlTimeLayout))
+		cond := "written_at <= " + d.placeholder(len(args)) + d.cast("written_at")
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+	}
+	query := "SELECT " + d.selects(append(append([]string{}, resultColumns...), versionColumns...)) +
+		" FROM etl_result_versions" + where + " ORDER BY date, channel, campaign_id, written_at, id"
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var versions []models.ResultVersion
+	for rows.Next() {
+		var v models.ResultVersion
+		var writtenAt string
+		if v.ETLResult, err = scanResult(rows, &writtenAt, &v.Deleted); err != nil {
+			return nil, err
+		}
+		if v.WrittenAt, err = time.Parse(sqlTimeLayout, writtenAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// upsertAssignments updates every column but the key from the conflicting row
+func upsertAssignments() []string {
+	updates := make([]string, 0, len(resultColumns))
+	for _, c := range resultColumns {
+		if c != "date" && c != "channel" && c != "campaign_id" {
+			updates = append(updates, c+" = excluded."+c)
+		}
+	}
+	return updates
+}
+
+// inTx runs fn in a transaction, committed when fn succeeds
+func inTx(ctx context.Context, conn *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlWhere translates q into a WHERE clause, placeholder(n) returning the placeholder of the nth argument
+func sqlWhere(q Query, placeholder func(n int) string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond, value string) {
+		if value != "" {
+			args = append(args, value)
+			conds = append(conds, cond+placeholder(len(args)))
+		}
+	}
+	add("run_id = ", q.RunID)
+	add("date >= ", q.From)
+	add("date <= ", q.To)
+	add("date = ", q.Date)
+	add("channel = ", q.Channel)
+	add("campaign_id = ", q.CampaignID)
+	add("utm_campaign = ", q.UTMCampaign)
+	add("utm_source = ", q.UTMSource)
+	add("utm_medium = ", q.UTMMedium)
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// sqlAfter extends where with the keyset condition of after, matching the rows sorting after the cursor
+func sqlAfter(where string, args []interface{}, after *Cursor, placeholder func(n int) string) (string, []interface{}) {
+	if after == nil {
+		return where, args
+	}
+	args = append(args, after.Date, after.Channel, after.CampaignID)
+	n := len(args)
+	cond := fmt.Sprintf("(date, channel, campaign_id) > (%s, %s, %s)", placeholder(n-2), placeholder(n-1), placeholder(n))
+	if where == "" {
+		return " WHERE " + cond, args
+	}
+	return where + " AND " + cond, args
+}
+
+// resultValues returns the column values of res in resultColumns order. Decimals are passed as text.
+func resultValues(res models.ETLResult) ([]interface{}, error) {
+	var metrics interface{}
+	if res.Metrics != nil {
+		b, err := json.Marshal(res.Metrics)
+		if err != nil {
+			return nil, err
+		}
+		metrics = string(b)
+	}
+	return []interface{}{
+		res.RunID, res.TransformVersion, res.Date, res.Channel, res.CampaignID, res.UTMCampaign, res.UTMSource, res.UTMMedium,
+		res.Clicks, res.Impressions, res.Cost.String(), res.Leads, res.Opportunities, res.ClosedWon,
+		res.Revenue.String(), res.Pipeline.String(), res.CPC.String(), res.CPA.String(),
+		res.CVRLeadToOpp.String(), res.CVROppToWon.String(), res.ROAS.String(), metrics,
+	}, nil
+}
+
+// scanResult reads a row selected with resultColumns, decimals cast to text, followed by the extra columns
+func scanResult(rows *sql.Rows, extra ...interface{}) (models.ETLResult, error) {
+	var res models.ETLResult
+	var decimals [8]string
+	var metrics []byte
+	dest := []interface{}{&res.RunID, &res.TransformVersion, &res.Date, &res.Channel, &res.CampaignID, &res.UTMCampaign, &res.UTMSource, &res.UTMMedium,
+		&res.Clicks, &res.Impressions, &decimals[0], &res.Leads, &res.Opportunities, &res.ClosedWon,
+		&decimals[1], &decimals[2], &decimals[3], &decimals[4], &decimals[5], &decimals[6], &decimals[7], &metrics}
+	err := rows.Scan(append(dest, extra...)...)
+	if err != nil {
+		return res, err
+	}
+	targets := []*money.Decimal{&res.Cost, &res.Revenue, &res.Pipeline, &res.CPC, &res.CPA, &res.CVRLeadToOpp, &res.CVROppToWon, &res.ROAS}
+	for i, target := range targets {
+		if *target, err = money.NewFromString(decimals[i]); err != nil {
+			return res, err
+		}
+	}
+	if metrics != nil {
+		if err := json.Unmarshal(metrics, &res.Metrics); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
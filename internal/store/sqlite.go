@@ -0,0 +1,326 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"goetl/internal/models"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are applied in order and recorded in schema_migrations. Decimals are stored as TEXT
+// so they keep their exact value.
+var sqliteMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS etl_results (
+		run_id          TEXT NOT NULL DEFAULT '',
+		date            TEXT NOT NULL,
+		channel         TEXT NOT NULL,
+		campaign_id     TEXT NOT NULL,
+		utm_campaign    TEXT NOT NULL DEFAULT '',
+		utm_source      TEXT NOT NULL DEFAULT '',
+		utm_medium      TEXT NOT NULL DEFAULT '',
+		clicks          INTEGER NOT NULL DEFAULT 0,
+		impressions     INTEGER NOT NULL DEFAULT 0,
+		cost            TEXT NOT NULL DEFAULT '0',
+		leads           INTEGER NOT NULL DEFAULT 0,
+		opportunities   INTEGER NOT NULL DEFAULT 0,
+		closed_won      INTEGER NOT NULL DEFAULT 0,
+		revenue         TEXT NOT NULL DEFAULT '0',
+		pipeline        TEXT NOT NULL DEFAULT '0',
+		cpc             TEXT NOT NULL DEFAULT '0',
+		cpa             TEXT NOT NULL DEFAULT '0',
+		cvr_lead_to_opp TEXT NOT NULL DEFAULT '0',
+		cvr_opp_to_won  TEXT NOT NULL DEFAULT '0',
+		roas            TEXT NOT NULL DEFAULT '0',
+		metrics         TEXT,
+		PRIMARY KEY (date, channel, campaign_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS etl_results_channel_date ON etl_results (channel, date)`,
+	`CREATE INDEX IF NOT EXISTS etl_results_utm_campaign_date ON etl_results (utm_campaign, date)`,
+	`CREATE INDEX IF NOT EXISTS etl_results_campaign_id_date ON etl_results (campaign_id, date)`,
+	`ALTER TABLE etl_results ADD COLUMN transform_version TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS etl_result_versions (
+		id                INTEGER PRIMARY KEY,
+		run_id            TEXT NOT NULL DEFAULT '',
+		transform_version TEXT NOT NULL DEFAULT '',
+		date              TEXT NOT NULL,
+		channel           TEXT NOT NULL,
+		campaign_id       TEXT NOT NULL,
+		utm_campaign      TEXT NOT NULL DEFAULT '',
+		utm_source        TEXT NOT NULL DEFAULT '',
+		utm_medium        TEXT NOT NULL DEFAULT '',
+		clicks            INTEGER NOT NULL DEFAULT 0,
+		impressions       INTEGER NOT NULL DEFAULT 0,
+		cost              TEXT NOT NULL DEFAULT '0',
+		leads             INTEGER NOT NULL DEFAULT 0,
+		opportunities     INTEGER NOT NULL DEFAULT 0,
+		closed_won        INTEGER NOT NULL DEFAULT 0,
+		revenue           TEXT NOT NULL DEFAULT '0',
+		pipeline          TEXT NOT NULL DEFAULT '0',
+		cpc               TEXT NOT NULL DEFAULT '0',
+		cpa               TEXT NOT NULL DEFAULT '0',
+		cvr_lead_to_opp   TEXT NOT NULL DEFAULT '0',
+		cvr_opp_to_won    TEXT NOT NULL DEFAULT '0',
+		roas              TEXT NOT NULL DEFAULT '0',
+		metrics           TEXT,
+		written_at        TEXT NOT NULL,
+		deleted           INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS etl_result_versions_key ON etl_result_versions (date, channel, campaign_id, written_at)`,
+	`CREATE INDEX IF NOT EXISTS etl_result_versions_run_id ON etl_result_versions (run_id)`,
+	// rows loaded before versioning become the first version of their key
+	`INSERT INTO etl_result_versions (` + strings.Join(resultColumns, ", ") + `, written_at)
+		SELECT ` + strings.Join(resultColumns, ", ") + `, '1970-01-01T00:00:00.000Z' FROM etl_results`,
+	`CREATE INDEX IF NOT EXISTS etl_result_versions_channel_date ON etl_result_versions (channel, date)`,
+	`CREATE INDEX IF NOT EXISTS etl_result_versions_utm_campaign_date ON etl_result_versions (utm_campaign, date)`,
+}
+
+// sqliteDialect uses positional parameters, every value is stored as written
+var sqliteDialect = sqlDialect{
+	placeholder: sqlitePlaceholder,
+	cast:        func(string) string { return "" },
+	selectExpr:  func(column string) string { return column },
+}
+
+// sqliteRowsPerInsert keeps the parameters of a statement under the SQLite limit of 32766
+const sqliteRowsPerInsert = 500
+
+// SQLite stores results in the etl_results table of an embedded SQLite database file
+type SQLite struct {
+	db        *sql.DB
+	batchSize int
+}
+
+// OpenSQLite opens or creates the database file at path and migrates the schema
+func OpenSQLite(path string, batchSize int) (*SQLite, error) {
+	if path == "" {
+		return nil, errors.New("SQLITE_PATH is required by the sqlite result store")
+	}
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer, serialize the concurrent batches of a load
+	conn.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+	if err := migrate(ctx, conn, sqliteMigrations); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &SQLite{db: conn, batchSize: batchSize}, nil
+}
+
+// Save upserts results in one transaction, reporting every row as failed if it is rolled back
+func (s *SQLite) Save(ctx context.Context, results []models.ETLResult) (models.LoadSummary, []models.LoadFailure) {
+	var summary models.LoadSummary
+	writtenAt := writeTime()
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		summary, err = s.upsert(ctx, tx, results, writtenAt)
+		return err
+	})
+	if err != nil {
+		summary = models.LoadSummary{Rows: len(results), Batches: 1, Failed: len(results)}
+		return summary, Failures(results, err)
+	}
+	return summary, nil
+}
+
+// Replace upserts results and deletes the stale rows of their range in one transaction
+func (s *SQLite) Replace(ctx context.Context, runID, from string, results []models.ETLResult) (models.LoadSummary, error) {
+	from, to := ReplaceBounds(from, results)
+	return s.replace(ctx, runID, results, rangeWhere(from, to, Channels(results)))
+}
+
+// Restore upserts results and deletes the other rows matching q in one transaction
+func (s *SQLite) Restore(ctx context.Context, runID string, q Query, results []models.ETLResult) (models.LoadSummary, error) {
+	return s.replace(ctx, runID, results, queryWhere(q))
+}
+
+// replace stamps results with runID, upserts them and deletes the rows of partition they did not rewrite
+func (s *SQLite) replace(ctx context.Context, runID string, results []models.ETLResult, partition whereFunc) (models.LoadSummary, error) {
+	for i := range results {
+		results[i].RunID = runID
+	}
+	writtenAt := writeTime()
+	var summary models.LoadSummary
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		summary = models.LoadSummary{Mode: models.LoadTransaction}
+		for start := 0; start < len(results); start += s.batchSize {
+			end := start + s.batchSize
+			if end > len(results) {
+				end = len(results)
+			}
+			b, err := s.upsert(ctx, tx, results[start:end], writtenAt)
+			if err != nil {
+				return err
+			}
+			summary.Add(b)
+		}
+		var err error
+		summary.Deleted, err = deleteRows(ctx, tx, sqliteDialect, runID, writtenAt, staleWhere(partition, runID))
+		return err
+	})
+	return summary, err
+}
+
+// upsert writes results with multi-row INSERT ... ON CONFLICT statements, counting the keys that already
+// existed as modified, and records their versions
+func (s *SQLite) upsert(ctx context.Context, tx *sql.Tx, results []models.ETLResult, writtenAt time.Time) (models.LoadSummary, error) {
+	summary := models.LoadSummary{Rows: len(results), Batches: 1}
+	for start := 0; start < len(results); start += sqliteRowsPerInsert {
+		end := start + sqliteRowsPerInsert
+		if end > len(results) {
+			end = len(results)
+		}
+		chunk := results[start:end]
+		existing, err := s.countExisting(ctx, tx, chunk)
+		if err != nil {
+			return summary, err
+		}
+		var values []string
+		var args []interface{}
+		for _, res := range chunk {
+			row, err := resultValues(res)
+			if err != nil {
+				return summary, err
+			}
+			args = append(args, row...)
+			values = append(values, "("+sqliteList(len(row))+")")
+		}
+		query := "INSERT INTO etl_results (" + strings.Join(resultColumns, ", ") + ") VALUES " + strings.Join(values, ", ") +
+			" ON CONFLICT (date, channel, campaign_id) DO UPDATE SET " + strings.Join(upsertAssignments(), ", ")
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return summary, err
+		}
+		summary.Inserted += len(chunk) - existing
+		summary.Modified += existing
+	}
+	return summary, recordWrites(ctx, tx, sqliteDialect, results, writtenAt)
+}
+
+// countExisting returns how many keys of results are already stored
+func (s *SQLite) countExisting(ctx context.Context, tx *sql.Tx, results []models.ETLResult) (int, error) {
+	keys := make([]string, 0, len(results))
+	args := make([]interface{}, 0, 3*len(results))
+	for _, res := range results {
+		keys = append(keys, "(?, ?, ?)")
+		args = append(args, res.Date, res.Channel, res.CampaignID)
+	}
+	var n int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM etl_results WHERE (date, channel, campaign_id) IN (VALUES `+strings.Join(keys, ", ")+`)`,
+		args...).Scan(&n)
+	return n, err
+}
+
+// Query returns the matching results ordered by date, channel and campaign id
+func (s *SQLite) Query(ctx context.Context, q Query) ([]models.ETLResult, error) {
+	var results []models.ETLResult
+	err := s.Each(ctx, q, func(res models.ETLResult) error {
+		results = append(results, res)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Each calls fn with the matching results ordered by date, channel and campaign id, as the rows are read
+func (s *SQLite) Each(ctx context.Context, q Query, fn func(models.ETLResult) error) error {
+	where, args := sqlWhere(q, sqlitePlaceholder)
+	where, args = sqlAfter(where, args, q.After, sqlitePlaceholder)
+	query := "SELECT " + sqliteDialect.selects(resultColumns) + " FROM etl_results" + where + " ORDER BY date, channel, campaign_id"
+	if q.Limit > 0 || q.Offset > 0 {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, q.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		res, err := scanResult(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Count counts the matching results with COUNT(*)
+func (s *SQLite) Count(ctx context.Context, q Query) (int, error) {
+	where, args := sqlWhere(q, sqlitePlaceholder)
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM etl_results"+where, args...).Scan(&count)
+	return count, err
+}
+
+// Aggregate sums the matching results in Go, SQLite would add the TEXT decimals as floats
+func (s *SQLite) Aggregate(ctx context.Context, q Query, groupBy ...string) ([]models.ResultAggregate, error) {
+	if err := checkGroupBy(groupBy); err != nil {
+		return nil, err
+	}
+	q.Limit, q.Offset = 0, 0
+	results, err := s.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return aggregate(results, groupBy), nil
+}
+
+// Delete removes the matching results
+func (s *SQLite) Delete(ctx context.Context, q Query) (int, error) {
+	deleted := 0
+	writtenAt := writeTime()
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		deleted, err = deleteRows(ctx, tx, sqliteDialect, "", writtenAt, queryWhere(q))
+		return err
+	})
+	return deleted, err
+}
+
+// History returns the matching versions
+func (s *SQLite) History(ctx context.Context, q Query, until time.Time) ([]models.ResultVersion, error) {
+	return queryHistory(ctx, s.db, sqliteDialect, q, until)
+}
+
+// Purge removes the matching results and versions in a transaction
+func (s *SQLite) Purge(ctx context.Context, q Query) (int, int, error) {
+	rows, versions := 0, 0
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		rows, versions, err = purgeRows(ctx, tx, sqliteDialect, q)
+		return err
+	})
+	return rows, versions, err
+}
+
+// Close closes the database file
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// sqlitePlaceholder returns the positional parameter, the same for every argument
+func sqlitePlaceholder(int) string {
+	return "?"
+}
+
+// sqliteList returns n comma separated placeholders
+func sqliteList(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
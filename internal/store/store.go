@@ -0,0 +1,386 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"goetl/internal/models"
+)
+
+// Backends selected with RESULT_STORE
+const (
+	BackendMongo    = "mongo"
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// Fields the results can be aggregated by
+const (
+	GroupDate     = "date"
+	GroupChannel  = "channel"
+	GroupCampaign = "campaign_id"
+)
+
+// ErrUnavailable is returned when the backend cannot be reached
+var ErrUnavailable = errors.New("result store unavailable")
+
+// ResultStore persists ETL results keyed by (date, channel, campaign id). Every row written or deleted is
+// also appended to a version history, in the same transaction where the backend has one.
+type ResultStore interface {
+	// Save upserts results by their key and returns the rows that could not be written
+	Save(ctx context.Context, results []models.ETLResult) (models.LoadSummary, []models.LoadFailure)
+	// Replace writes results, stamped with runID, as the new content of the range they were computed
+	// from: the dates of ReplaceBounds of the channels present in results. Stored rows of that range that
+	// results do not rewrite are deleted. Nothing changes on error, except in the in-place MongoDB mode.
+	Replace(ctx context.Context, runID, from string, results []models.ETLResult) (models.LoadSummary, error)
+	// Restore writes results, stamped with runID, as the new content of the rows matching q, deleting the
+	// stored rows matching q that results do not rewrite. Nothing changes on error.
+	Restore(ctx context.Context, runID string, q Query, results []models.ETLResult) (models.LoadSummary, error)
+	// Query returns the results matching q ordered by date, channel and campaign id, after q.After when set and
+	// paginated with q.Limit and q.Offset
+	Query(ctx context.Context, q Query) ([]models.ETLResult, error)
+	// Count returns how many results match q, ignoring its pagination
+	Count(ctx context.Context, q Query) (int, error)
+	// Each calls fn with the results matching q one at a time, in the order and pages of Query, without
+	// holding them all in memory. It stops at the first error of fn and returns it.
+	Each(ctx context.Context, q Query, fn func(models.ETLResult) error) error
+	// Aggregate sums the results matching q by the groupBy fields, ordered by them
+	Aggregate(ctx context.Context, q Query, groupBy ...string) ([]models.ResultAggregate, error)
+	// Delete removes the results matching q, ignoring its pagination, and returns how many were removed
+	Delete(ctx context.Context, q Query) (int, error)
+	// History returns the versions of the results matching q written up to until (every version when zero),
+	// ordered by date, channel, campaign id and write order, ignoring the pagination of q
+	History(ctx context.Context, q Query, until time.Time) ([]models.ResultVersion, error)
+	// Purge removes the results matching q together with all their versions, without recording tombstones,
+	// and returns how many results and versions were removed. Retention uses it to drop expired dates.
+	Purge(ctx context.Context, q Query) (rows, versions int, err error)
+	Close() error
+}
+
+// Query filters results. Empty fields match every result; From and To bound the date inclusively.
+type Query struct {
+	RunID       string
+	From        string
+	To          string
+	Date        string
+	Channel     string
+	CampaignID  string
+	UTMCampaign string
+	UTMSource   string
+	UTMMedium   string
+	Limit       int
+	Offset      int
+	// After pages by key: only the results sorting after the cursor are returned, before Offset is applied
+	After *Cursor
+}
+
+// Match reports whether res passes the filters of q
+func (q Query) Match(res models.ETLResult) bool {
+	switch {
+	case q.RunID != "" && res.RunID != q.RunID,
+		q.From != "" && res.Date < q.From,
+		q.To != "" && res.Date > q.To,
+		q.Date != "" && res.Date != q.Date,
+		q.Channel != "" && res.Channel != q.Channel,
+		q.CampaignID != "" && res.CampaignID != q.CampaignID,
+		q.UTMCampaign != "" && res.UTMCampaign != q.UTMCampaign,
+		q.UTMSource != "" && res.UTMSource != q.UTMSource,
+		q.UTMMedium != "" && res.UTMMedium != q.UTMMedium:
+		return false
+	}
+	return true
+}
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the key of the last result of a page, the next page starting after it
+type Cursor struct {
+	Date       string
+	Channel    string
+	CampaignID string
+}
+
+// CursorOf returns the cursor of the page ending at res
+func CursorOf(res models.ETLResult) Cursor {
+	return Cursor{Date: res.Date, Channel: res.Channel, CampaignID: res.CampaignID}
+}
+
+// Before reports whether c sorts before res by date, channel and campaign id
+func (c Cursor) Before(res models.ETLResult) bool {
+	if c.Date != res.Date {
+		return c.Date < res.Date
+	}
+	if c.Channel != res.Channel {
+		return c.Channel < res.Channel
+	}
+	return c.CampaignID < res.CampaignID
+}
+
+// Encode returns c as an opaque URL safe token
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal([]string{c.Date, c.Channel, c.CampaignID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor reads a token returned by Cursor.Encode
+func DecodeCursor(token string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var key []string
+	if err := json.Unmarshal(b, &key); err != nil || len(key) != 3 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Date: key[0], Channel: key[1], CampaignID: key[2]}, nil
+}
+
+// Config selects and configures a backend
+type Config struct {
+	// Backend is mongo (default), memory, postgres or sqlite
+	Backend string
+	// PostgresDSN is the connection string of the postgres backend
+	PostgresDSN string
+	// SQLitePath is the database file of the sqlite backend
+	SQLitePath string
+	// BatchSize is the number of rows written per statement or bulk write by Replace
+	BatchSize int
+	// MongoSwap makes the mongo backend replace ranges on a standalone server through a copy of the
+	// collection swapped in with renameCollection, instead of in place
+	MongoSwap bool
+}
+
+// Open returns the backend selected by cfg
+func Open(cfg Config) (ResultStore, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	switch cfg.Backend {
+	case "", BackendMongo:
+		return NewMongo(cfg.BatchSize, cfg.MongoSwap), nil
+	case BackendMemory:
+		return NewMemory(), nil
+	case BackendPostgres:
+		return OpenPostgres(cfg.PostgresDSN, cfg.BatchSize)
+	case BackendSQLite:
+		return OpenSQLite(cfg.SQLitePath, cfg.BatchSize)
+	}
+	return nil, fmt.Errorf("unknown result store backend %q", cfg.Backend)
+}
+
+// Key identifies a result the way every backend upserts it
+func Key(res models.ETLResult) string {
+	return res.Date + ":" + res.Channel + ":" + res.CampaignID
+}
+
+// ReplaceBounds returns the inclusive date range Replace rewrites: from the later of from and the earliest
+// date of results to the latest date of results. Dates results do not reach are never replaced, so an empty
+// from or an extraction with a shorter lookback does not delete the older history.
+func ReplaceBounds(from string, results []models.ETLResult) (string, string) {
+	if len(results) == 0 {
+		return "", ""
+	}
+	first, last := results[0].Date, results[0].Date
+	for _, res := range results[1:] {
+		if res.Date < first {
+			first = res.Date
+		}
+		if res.Date > last {
+			last = res.Date
+		}
+	}
+	if from < first {
+		from = first
+	}
+	return from, last
+}
+
+// Channels returns the distinct channels of results, sorted
+func Channels(results []models.ETLResult) []string {
+	seen := map[string]bool{}
+	channels := []string{}
+	for _, res := range results {
+		if !seen[res.Channel] {
+			seen[res.Channel] = true
+			channels = append(channels, res.Channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// Failures reports every row of results as failed with err
+func Failures(results []models.ETLResult, err error) []models.LoadFailure {
+	failures := make([]models.LoadFailure, 0, len(results))
+	for _, res := range results {
+		failures = append(failures, Failure(res, err.Error()))
+	}
+	return failures
+}
+
+// Failure records why res could not be written
+func Failure(res models.ETLResult, msg string) models.LoadFailure {
+	return models.LoadFailure{Date: res.Date, Channel: res.Channel, CampaignID: res.CampaignID, Error: msg}
+}
+
+// now is replaced in tests to control the write times of versions
+var now = time.Now
+
+// writeTime returns the time versions written now are recorded at, truncated to the millisecond precision
+// of MongoDB so every backend orders them alike
+func writeTime() time.Time {
+	return now().UTC().Truncate(time.Millisecond)
+}
+
+// StateOf returns the results left by versions ordered as History returns them: the last version of each
+// key unless it is a tombstone
+func StateOf(versions []models.ResultVersion) []models.ETLResult {
+	var results []models.ETLResult
+	for i, v := range versions {
+		if i+1 < len(versions) && Key(versions[i+1].ETLResult) == Key(v.ETLResult) {
+			continue
+		}
+		if !v.Deleted {
+			results = append(results, v.ETLResult)
+		}
+	}
+	return results
+}
+
+// QueryAsOf returns the results matching q as they were at asOf, rebuilt from the history of s and
+// paginated like Query
+func QueryAsOf(ctx context.Context, s ResultStore, q Query, asOf time.Time) ([]models.ETLResult, error) {
+	versions, err := s.History(ctx, q, asOf)
+	if err != nil {
+		return nil, err
+	}
+	return Paginate(StateOf(versions), q), nil
+}
+
+// tombstone returns the version recording that runID deleted res at writtenAt
+func tombstone(res models.ETLResult, runID string, writtenAt time.Time) models.ResultVersion {
+	res.RunID = runID
+	return models.ResultVersion{ETLResult: res, WrittenAt: writtenAt, Deleted: true}
+}
+
+// checkGroupBy rejects unknown aggregation fields
+func checkGroupBy(groupBy []string) error {
+	for _, g := range groupBy {
+		if g != GroupDate && g != GroupChannel && g != GroupCampaign {
+			return fmt.Errorf("cannot group results by %q", g)
+		}
+	}
+	return nil
+}
+
+// groupKey returns the aggregate res belongs to when grouped by groupBy, with only the key fields set
+func groupKey(res models.ETLResult, groupBy []string) models.ResultAggregate {
+	var agg models.ResultAggregate
+	for _, g := range groupBy {
+		switch g {
+		case GroupDate:
+			agg.Date = res.Date
+		case GroupChannel:
+			agg.Channel = res.Channel
+		case GroupCampaign:
+			agg.CampaignID = res.CampaignID
+		}
+	}
+	return agg
+}
+
+// aggregate sums results by the groupBy fields, ordered by them
+func aggregate(results []models.ETLResult, groupBy []string) []models.ResultAggregate {
+	groups := map[models.ResultAggregate]*models.ResultAggregate{}
+	var aggregates []*models.ResultAggregate
+	for _, res := range results {
+		key := groupKey(res, groupBy)
+		agg, ok := groups[key]
+		if !ok {
+			agg = &models.ResultAggregate{Date: key.Date, Channel: key.Channel, CampaignID: key.CampaignID}
+			groups[key] = agg
+			aggregates = append(aggregates, agg)
+		}
+		agg.Add(res)
+	}
+	out := make([]models.ResultAggregate, 0, len(aggregates))
+	for _, agg := range aggregates {
+		out = append(out, *agg)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		for _, g := range groupBy {
+			switch {
+			case g == GroupDate && a.Date != b.Date:
+				return a.Date < b.Date
+			case g == GroupChannel && a.Channel != b.Channel:
+				return a.Channel < b.Channel
+			case g == GroupCampaign && a.CampaignID != b.CampaignID:
+				return a.CampaignID < b.CampaignID
+			}
+		}
+		return false
+	})
+	return out
+}
+
+// sortResults orders results by date, channel and campaign id
+func sortResults(results []models.ETLResult) {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		return a.CampaignID < b.CampaignID
+	})
+}
+
+// sortVersions orders versions by date, channel, campaign id and write time, keeping the write order of
+// versions written at the same time
+func sortVersions(versions []models.ResultVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, b := versions[i], versions[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		if a.CampaignID != b.CampaignID {
+			return a.CampaignID < b.CampaignID
+		}
+		return a.WrittenAt.Before(b.WrittenAt)
+	})
+}
+
+// Paginate applies the pagination of q, After then Offset and Limit, to results ordered by date, channel and
+// campaign id
+func Paginate(results []models.ETLResult, q Query) []models.ETLResult {
+	if q.After != nil {
+		after := *q.After
+		start := sort.Search(len(results), func(i int) bool { return after.Before(results[i]) })
+		results = results[start:]
+	}
+	return paginate(results, q.Limit, q.Offset)
+}
+
+// paginate applies limit and offset to results
+func paginate(results []models.ETLResult, limit, offset int) []models.ETLResult {
+	if offset >= len(results) {
+		return nil
+	}
+	results = results[offset:]
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+	return results
+}
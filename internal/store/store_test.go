@@ -0,0 +1,496 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+	"goetl/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func result(date, channel, campaignID string, cost int) models.ETLResult {
+	return models.ETLResult{Date: date, Channel: channel, CampaignID: campaignID, UTMCampaign: "utm_" + campaignID, Clicks: cost, Cost: money.NewFromInt(cost)}
+}
+
+func TestMemory_SaveAndQuery(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	summary, failures := m.Save(ctx, []models.ETLResult{
+		result("2025-09-02", "google_ads", "C1", 10),
+		result("2025-09-01", "meta_ads", "C2", 20),
+		result("2025-09-01", "google_ads", "C1", 30),
+	})
+	assert.Empty(t, failures)
+	assert.Equal(t, 3, summary.Inserted)
+
+	summary, _ = m.Save(ctx, []models.ETLResult{result("2025-09-01", "google_ads", "C1", 35)})
+	assert.Equal(t, 1, summary.Modified)
+
+	all, err := m.Query(ctx, Query{})
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+	assert.Equal(t, "google_ads", all[0].Channel)
+	assert.Equal(t, "35", all[0].Cost.String())
+	assert.Equal(t, "meta_ads", all[1].Channel)
+	assert.Equal(t, "2025-09-02", all[2].Date)
+
+	page, _ := m.Query(ctx, Query{Limit: 1, Offset: 1})
+	assert.Equal(t, []models.ETLResult{all[1]}, page)
+
+	filtered, _ := m.Query(ctx, Query{From: "2025-09-01", To: "2025-09-01", UTMCampaign: "utm_C1"})
+	assert.Len(t, filtered, 1)
+	assert.Equal(t, "C1", filtered[0].CampaignID)
+}
+
+func TestMemory_Replace(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.Save(ctx, []models.ETLResult{
+		result("2025-08-31", "google_ads", "C1", 1),
+		result("2025-09-01", "google_ads", "C1", 1),
+		result("2025-09-01", "google_ads", "DELETED", 1),
+		result("2025-09-01", "meta_ads", "C2", 1),
+	})
+	summary, err := m.Replace(ctx, "run2", "2025-09-01", []models.ETLResult{result("2025-09-01", "google_ads", "C1", 5)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Modified)
+	assert.Equal(t, 1, summary.Deleted)
+
+	all, _ := m.Query(ctx, Query{})
+	var keys []string
+	for _, res := range all {
+		keys = append(keys, Key(res))
+	}
+	// rows before the range and of other channels are kept
+	assert.Equal(t, []string{"2025-08-31:google_ads:C1", "2025-09-01:google_ads:C1", "2025-09-01:meta_ads:C2"}, keys)
+	assert.Equal(t, "run2", all[1].RunID)
+
+	// without from, only the dates the results reach are replaced
+	summary, err = m.Replace(ctx, "run3", "", []models.ETLResult{result("2025-09-01", "google_ads", "C1", 6)})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, summary.Deleted)
+	all, _ = m.Query(ctx, Query{})
+	assert.Len(t, all, 3)
+}
+
+func TestReplaceBounds(t *testing.T) {
+	results := []models.ETLResult{result("2025-09-03", "a", "C1", 1), result("2025-09-01", "a", "C1", 1), result("2025-09-02", "b", "C1", 1)}
+	from, to := ReplaceBounds("", results)
+	assert.Equal(t, "2025-09-01", from)
+	assert.Equal(t, "2025-09-03", to)
+	from, to = ReplaceBounds("2025-09-02", results)
+	assert.Equal(t, "2025-09-02", from)
+	assert.Equal(t, "2025-09-03", to)
+	from, _ = ReplaceBounds("2025-08-01", results)
+	assert.Equal(t, "2025-09-01", from)
+}
+
+func TestMemory_AggregateAndDelete(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.Save(ctx, []models.ETLResult{
+		result("2025-09-01", "google_ads", "C1", 10),
+		result("2025-09-01", "meta_ads", "C1", 5),
+		result("2025-09-02", "google_ads", "C1", 20),
+		result("2025-09-02", "google_ads", "C2", 7),
+	})
+	byDate, err := m.Aggregate(ctx, Query{CampaignID: "C1"}, GroupDate)
+	assert.NoError(t, err)
+	assert.Len(t, byDate, 2)
+	assert.Equal(t, "2025-09-01", byDate[0].Date)
+	assert.Equal(t, "", byDate[0].Channel)
+	assert.Equal(t, 2, byDate[0].Rows)
+	assert.Equal(t, "15", byDate[0].Cost.String())
+	assert.Equal(t, 20, byDate[1].Clicks)
+
+	total, _ := m.Aggregate(ctx, Query{})
+	assert.Len(t, total, 1)
+	assert.Equal(t, "42", total[0].Cost.String())
+
+	_, err = m.Aggregate(ctx, Query{}, "utm_campaign")
+	assert.Error(t, err)
+
+	deleted, err := m.Delete(ctx, Query{Channel: "google_ads", From: "2025-09-02"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+}
+
+func TestSQLite(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "goetl.db"), 2)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	withMetrics := result("2025-09-01", "google_ads", "C1", 10)
+	withMetrics.Cost = money.RequireFromString("10.015")
+	withMetrics.Metrics = map[string]money.Decimal{"ctr": money.RequireFromString("0.25")}
+	summary, failures := s.Save(ctx, []models.ETLResult{
+		withMetrics,
+		result("2025-09-01", "google_ads", "DELETED", 1),
+		result("2025-09-01", "meta_ads", "C2", 20),
+		result("2025-08-31", "google_ads", "C1", 3),
+	})
+	assert.Empty(t, failures)
+	assert.Equal(t, 4, summary.Inserted)
+
+	all, err := s.Query(ctx, Query{From: "2025-09-01", Channel: "google_ads"})
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+	assert.Equal(t, "10.015", all[0].Cost.String())
+	assert.Equal(t, "0.25", all[0].Metrics["ctr"].String())
+
+	page, _ := s.Query(ctx, Query{Offset: 1})
+	assert.Len(t, page, 3)
+
+	summary, err = s.Replace(ctx, "run2", "2025-09-01", []models.ETLResult{
+		result("2025-09-01", "google_ads", "C1", 5),
+		result("2025-09-01", "google_ads", "C3", 7),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.LoadSummary{Mode: models.LoadTransaction, Rows: 2, Batches: 1, Inserted: 1, Modified: 1, Deleted: 1}, summary)
+
+	all, _ = s.Query(ctx, Query{})
+	var keys []string
+	for _, res := range all {
+		keys = append(keys, Key(res))
+	}
+	assert.Equal(t, []string{"2025-08-31:google_ads:C1", "2025-09-01:google_ads:C1", "2025-09-01:google_ads:C3", "2025-09-01:meta_ads:C2"}, keys)
+	assert.Equal(t, "run2", all[1].RunID)
+
+	byChannel, err := s.Aggregate(ctx, Query{Date: "2025-09-01"}, GroupChannel)
+	assert.NoError(t, err)
+	assert.Len(t, byChannel, 2)
+	assert.Equal(t, "12", byChannel[0].Cost.String())
+
+	deleted, err := s.Delete(ctx, Query{To: "2025-08-31"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
+
+// clock makes the versions written after each call one minute later than the previous ones
+func clock(t *testing.T) func() {
+	current := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	t.Cleanup(func() { now = time.Now })
+	return func() { current = current.Add(time.Minute) }
+}
+
+// testHistoryAndRestore checks the versions recorded by every write and that a range restored to the
+// state before a run matches it
+func testHistoryAndRestore(t *testing.T, s ResultStore) {
+	ctx := context.Background()
+	tick := clock(t)
+	first := result("2025-09-01", "google_ads", "C1", 10)
+	first.RunID = "run1"
+	other := result("2025-09-01", "google_ads", "C2", 4)
+	other.RunID = "run1"
+	_, failures := s.Save(ctx, []models.ETLResult{first, other})
+	assert.Empty(t, failures)
+	run1At := writeTime()
+	tick()
+
+	_, err := s.Replace(ctx, "run2", "2025-09-01", []models.ETLResult{
+		result("2025-09-01", "google_ads", "C1", 99),
+		result("2025-09-01", "google_ads", "C3", 7),
+	})
+	assert.NoError(t, err)
+	tick()
+
+	versions, err := s.History(ctx, Query{CampaignID: "C2"}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.False(t, versions[0].Deleted)
+	assert.True(t, versions[1].Deleted)
+	assert.Equal(t, "run2", versions[1].RunID)
+	assert.True(t, versions[1].WrittenAt.After(versions[0].WrittenAt))
+
+	run2, _ := s.History(ctx, Query{RunID: "run2"}, time.Time{})
+	assert.Len(t, run2, 3)
+
+	before, err := s.History(ctx, Query{From: "2025-09-01", To: "2025-09-01"}, run1At)
+	assert.NoError(t, err)
+	state := StateOf(before)
+	assert.Len(t, state, 2)
+	assert.Equal(t, "10", state[0].Cost.String())
+
+	summary, err := s.Restore(ctx, "rollback1", Query{From: "2025-09-01", To: "2025-09-01"}, state)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Inserted)
+	assert.Equal(t, 1, summary.Modified)
+	assert.Equal(t, 1, summary.Deleted)
+
+	current, _ := s.Query(ctx, Query{})
+	assert.Len(t, current, 2)
+	assert.Equal(t, "C1", current[0].CampaignID)
+	assert.Equal(t, "10", current[0].Cost.String())
+	assert.Equal(t, "rollback1", current[0].RunID)
+	assert.Equal(t, "C2", current[1].CampaignID)
+
+	// the history of every version is kept across the rollback
+	all, _ := s.History(ctx, Query{}, time.Time{})
+	assert.Equal(t, current, restamp(StateOf(all), "rollback1"))
+}
+
+// restamp sets the run id of results
+func restamp(results []models.ETLResult, runID string) []models.ETLResult {
+	for i := range results {
+		results[i].RunID = runID
+	}
+	return results
+}
+
+func TestMemory_HistoryAndRestore(t *testing.T) {
+	testHistoryAndRestore(t, NewMemory())
+}
+
+func TestSQLite_HistoryAndRestore(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "goetl.db"), 100)
+	assert.NoError(t, err)
+	defer s.Close()
+	testHistoryAndRestore(t, s)
+}
+
+func testPurge(t *testing.T, s ResultStore) {
+	ctx := context.Background()
+	_, failures := s.Save(ctx, []models.ETLResult{
+		result("2025-08-30", "google_ads", "C1", 1),
+		result("2025-08-31", "google_ads", "C1", 2),
+		result("2025-09-01", "google_ads", "C1", 3),
+	})
+	assert.Empty(t, failures)
+	_, err := s.Delete(ctx, Query{Date: "2025-08-31"})
+	assert.NoError(t, err)
+
+	rows, versions, err := s.Purge(ctx, Query{To: "2025-08-31"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rows)
+	// the writes of both dates and the tombstone of the deleted one
+	assert.Equal(t, 3, versions)
+
+	current, _ := s.Query(ctx, Query{})
+	assert.Len(t, current, 1)
+	all, _ := s.History(ctx, Query{}, time.Time{})
+	assert.Len(t, all, 1)
+	assert.Equal(t, "2025-09-01", all[0].Date)
+}
+
+func TestMemory_Purge(t *testing.T) {
+	testPurge(t, NewMemory())
+}
+
+func TestSQLite_Purge(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "goetl.db"), 100)
+	assert.NoError(t, err)
+	defer s.Close()
+	testPurge(t, s)
+}
+
+func testEach(t *testing.T, s ResultStore) {
+	ctx := context.Background()
+	_, failures := s.Save(ctx, []models.ETLResult{
+		result("2025-09-02", "google_ads", "C1", 1),
+		result("2025-09-01", "meta_ads", "C2", 2),
+		result("2025-09-01", "google_ads", "C3", 3),
+	})
+	assert.Empty(t, failures)
+	var seen []string
+	err := s.Each(ctx, Query{Limit: 2, Offset: 1}, func(res models.ETLResult) error {
+		seen = append(seen, res.CampaignID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"C2", "C1"}, seen)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = s.Each(ctx, Query{}, func(res models.ETLResult) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestMemory_Each(t *testing.T) {
+	testEach(t, NewMemory())
+}
+
+func TestSQLite_Each(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "goetl.db"), 100)
+	assert.NoError(t, err)
+	defer s.Close()
+	testEach(t, s)
+}
+
+func testPagination(t *testing.T, s ResultStore) {
+	ctx := context.Background()
+	_, failures := s.Save(ctx, []models.ETLResult{
+		result("2025-09-01", "google_ads", "C1", 1),
+		result("2025-09-01", "google_ads", "C2", 2),
+		result("2025-09-01", "meta_ads", "C1", 3),
+		result("2025-09-02", "google_ads", "C1", 4),
+		result("2025-09-02", "meta_ads", "C3", 5),
+	})
+	assert.Empty(t, failures)
+	count, err := s.Count(ctx, Query{Channel: "google_ads", Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// walking the pages by cursor visits every result once, in key order
+	var keys []string
+	q := Query{Limit: 2}
+	for {
+		page, err := s.Query(ctx, q)
+		assert.NoError(t, err)
+		for _, res := range page {
+			keys = append(keys, Key(res))
+		}
+		if len(page) < q.Limit {
+			break
+		}
+		after, err := DecodeCursor(CursorOf(page[len(page)-1]).Encode())
+		assert.NoError(t, err)
+		q.After = &after
+	}
+	assert.Equal(t, []string{
+		"2025-09-01:google_ads:C1", "2025-09-01:google_ads:C2", "2025-09-01:meta_ads:C1",
+		"2025-09-02:google_ads:C1", "2025-09-02:meta_ads:C3",
+	}, keys)
+
+	page, err := s.Query(ctx, Query{Channel: "meta_ads", After: &Cursor{Date: "2025-09-01", Channel: "meta_ads", CampaignID: "C1"}})
+	assert.NoError(t, err)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, "C3", page[0].CampaignID)
+	}
+	past, err := QueryAsOf(ctx, s, Query{After: &Cursor{Date: "2025-09-02"}}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, past, 2)
+}
+
+func TestMemory_Pagination(t *testing.T) {
+	testPagination(t, NewMemory())
+}
+
+func TestSQLite_Pagination(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "goetl.db"), 100)
+	assert.NoError(t, err)
+	defer s.Close()
+	testPagination(t, s)
+}
+
+func TestDecodeCursor(t *testing.T) {
+	c := Cursor{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C/1"}
+	decoded, err := DecodeCursor(c.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, c, decoded)
+	_, err = DecodeCursor("not a cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = DecodeCursor(base64.RawURLEncoding.EncodeToString([]byte(`["2025-09-01"]`)))
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestSQLite_MigratesExistingResults(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "goetl.db")
+	conn, err := sql.Open("sqlite", "file:"+path)
+	assert.NoError(t, err)
+	// the schema before versioning
+	assert.NoError(t, migrate(ctx, conn, sqliteMigrations[:4]))
+	_, err = conn.Exec(`INSERT INTO etl_results (run_id, date, channel, campaign_id, cost) VALUES ('old', '2025-09-01', 'google_ads', 'C1', '12.5')`)
+	assert.NoError(t, err)
+	conn.Close()
+
+	s, err := OpenSQLite(path, 100)
+	assert.NoError(t, err)
+	defer s.Close()
+	versions, err := s.History(ctx, Query{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.Equal(t, "old", versions[0].RunID)
+	assert.Equal(t, "12.5", versions[0].Cost.String())
+	assert.True(t, versions[0].WrittenAt.Equal(time.Unix(0, 0)))
+}
+
+func TestQueryAsOf(t *testing.T) {
+	ctx := context.Background()
+	tick := clock(t)
+	m := NewMemory()
+	m.Save(ctx, []models.ETLResult{result("2025-09-01", "google_ads", "C1", 10), result("2025-09-02", "google_ads", "C1", 20)})
+	sent := writeTime()
+	tick()
+	m.Replace(ctx, "restatement", "2025-09-02", []models.ETLResult{result("2025-09-02", "google_ads", "C1", 25)})
+	tick()
+	m.Delete(ctx, Query{Date: "2025-09-01"})
+
+	current, _ := m.Query(ctx, Query{})
+	assert.Len(t, current, 1)
+	past, err := QueryAsOf(ctx, m, Query{Channel: "google_ads"}, sent)
+	assert.NoError(t, err)
+	assert.Len(t, past, 2)
+	assert.Equal(t, "10", past[0].Cost.String())
+	assert.Equal(t, "20", past[1].Cost.String())
+	page, _ := QueryAsOf(ctx, m, Query{Limit: 1, Offset: 1}, sent)
+	assert.Equal(t, past[1:], page)
+	before, _ := QueryAsOf(ctx, m, Query{}, sent.Add(-time.Millisecond))
+	assert.Empty(t, before)
+}
+
+func TestStateOf(t *testing.T) {
+	v := func(campaignID string, cost int, deleted bool) models.ResultVersion {
+		return models.ResultVersion{ETLResult: result("2025-09-01", "google_ads", campaignID, cost), Deleted: deleted}
+	}
+	state := StateOf([]models.ResultVersion{v("C1", 1, false), v("C1", 2, false), v("C2", 3, false), v("C2", 3, true), v("C3", 4, true), v("C3", 5, false)})
+	assert.Len(t, state, 2)
+	assert.Equal(t, "2", state[0].Cost.String())
+	assert.Equal(t, "C3", state[1].CampaignID)
+	assert.Empty(t, StateOf(nil))
+}
+
+func TestMongoFilter(t *testing.T) {
+	filter := mongoFilter(Query{From: "2025-09-01", To: "2025-09-30", Channel: "google_ads", UTMCampaign: "fall"})
+	assert.Equal(t, bson.M{
+		"date":        bson.M{"$gte": "2025-09-01", "$lte": "2025-09-30"},
+		"channel":     "google_ads",
+		"utmcampaign": "fall",
+	}, filter)
+	assert.Equal(t, bson.M{}, mongoFilter(Query{Limit: 10}))
+	// a date outside the range matches nothing, as with SQL
+	filter = mongoFilter(Query{From: "2025-09-01", To: "2025-09-30", Date: "2025-10-01"})
+	assert.Equal(t, bson.M{"date": bson.M{"$gte": "2025-09-01", "$lte": "2025-09-30", "$eq": "2025-10-01"}}, filter)
+}
+
+func TestSQLWhere(t *testing.T) {
+	q := Query{From: "2025-09-01", Channel: "google_ads", CampaignID: "C1"}
+	where, args := sqlWhere(q, postgresPlaceholder)
+	assert.Equal(t, " WHERE date >= $1 AND channel = $2 AND campaign_id = $3", where)
+	assert.Equal(t, []interface{}{"2025-09-01", "google_ads", "C1"}, args)
+	where, _ = sqlWhere(q, sqlitePlaceholder)
+	assert.Equal(t, " WHERE date >= ? AND channel = ? AND campaign_id = ?", where)
+	where, args = staleWhere(rangeWhere("2025-09-01", "2025-09-30", []string{"a", "b"}), "run1")(shift(postgresPlaceholder, 2))
+	assert.Equal(t, " WHERE date >= $3 AND date <= $4 AND channel IN ($5, $6) AND run_id <> $7", where)
+	assert.Equal(t, []interface{}{"2025-09-01", "2025-09-30", "a", "b", "run1"}, args)
+	where, args = sqlWhere(Query{}, postgresPlaceholder)
+	assert.Equal(t, "", where)
+	assert.Nil(t, args)
+	where, args = sqlAfter(" WHERE channel = $1", []interface{}{"google_ads"}, &Cursor{Date: "2025-09-01", Channel: "google_ads", CampaignID: "C1"}, postgresPlaceholder)
+	assert.Equal(t, " WHERE channel = $1 AND (date, channel, campaign_id) > ($2, $3, $4)", where)
+	assert.Len(t, args, 4)
+}
+
+func TestOpen(t *testing.T) {
+	s, err := Open(Config{Backend: BackendMemory})
+	assert.NoError(t, err)
+	assert.IsType(t, &Memory{}, s)
+	_, err = Open(Config{Backend: BackendPostgres})
+	assert.Error(t, err)
+	_, err = Open(Config{Backend: BackendSQLite})
+	assert.Error(t, err)
+	_, err = Open(Config{Backend: "cassandra"})
+	assert.Error(t, err)
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"strings"
 	"time"
 	"fmt"
@@ -47,6 +49,15 @@ func RoundFloat(f float64, places int) float64 {
 	return math.Round(f*shift) / shift
 }
 
+// NewID returns a random 16-byte hex identifier, used for ETL run ids
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
 func Getenv(key string) string {
 	return os.Getenv(key)
 }
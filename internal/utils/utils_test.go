@@ -51,6 +51,12 @@ func TestRoundFloat(t *testing.T) {
 	assert.Equal(t, 3.0, RoundFloat(3.01, 0))
 }
 
+func TestNewID(t *testing.T) {
+	a, b := NewID(), NewID()
+	assert.Len(t, a, 32)
+	assert.NotEqual(t, a, b)
+}
+
 func TestGetenv(t *testing.T) {
 	os.Setenv("FOO_BAR", "baz")
 	defer os.Unsetenv("FOO_BAR")
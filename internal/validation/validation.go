@@ -0,0 +1,178 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"goetl/internal/models"
+	"goetl/internal/utils"
+)
+
+// Severity decides what happens to a record that violates a rule
+type Severity string
+
+const (
+	// SeverityOff disables a rule
+	SeverityOff Severity = "off"
+	// SeverityWarn keeps the record and records the violation
+	SeverityWarn Severity = "warn"
+	// SeverityDrop discards the record
+	SeverityDrop Severity = "drop"
+	// SeverityFail aborts the whole run
+	SeverityFail Severity = "fail"
+)
+
+// Rule is a named sanity check on an ad performance record
+type Rule struct {
+	Name     string
+	Severity Severity
+	// Check returns a message describing the violation, or "" when the record is valid
+	Check func(ad models.AdPerformance, today string) string
+}
+
+// RuleConfig overrides the severity of a built-in rule
+type RuleConfig struct {
+	Name     string   `json:"name"`
+	Severity Severity `json:"severity"`
+}
+
+// Config is the JSON document read from VALIDATION_CONFIG
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// Defaults returns the built-in rules with their default severities
+func Defaults() []Rule {
+	return []Rule{
+		{Name: "negative_clicks", Severity: SeverityDrop, Check: func(ad models.AdPerformance, _ string) string {
+			if ad.Clicks < 0 {
+				return fmt.Sprintf("clicks %d is negative", ad.Clicks)
+			}
+			return ""
+		}},
+		{Name: "negative_impressions", Severity: SeverityDrop, Check: func(ad models.AdPerformance, _ string) string {
+			if ad.Impressions < 0 {
+				return fmt.Sprintf("impressions %d is negative", ad.Impressions)
+			}
+			return ""
+		}},
+		{Name: "negative_cost", Severity: SeverityDrop, Check: func(ad models.AdPerformance, _ string) string {
+			if ad.Cost < 0 {
+				return fmt.Sprintf("cost %.2f is negative", ad.Cost)
+			}
+			return ""
+		}},
+		{Name: "clicks_exceed_impressions", Severity: SeverityWarn, Check: func(ad models.AdPerformance, _ string) string {
+			if ad.Clicks > ad.Impressions {
+				return fmt.Sprintf("clicks %d exceed impressions %d", ad.Clicks, ad.Impressions)
+			}
+			return ""
+		}},
+		{Name: "cost_without_impressions", Severity: SeverityWarn, Check: func(ad models.AdPerformance, _ string) string {
+			if ad.Cost > 0 && ad.Impressions == 0 {
+				return fmt.Sprintf("cost %.2f with zero impressions", ad.Cost)
+			}
+			return ""
+		}},
+		{Name: "future_date", Severity: SeverityDrop, Check: func(ad models.AdPerformance, today string) string {
+			if ad.Date > today {
+				return fmt.Sprintf("date %s is in the future", ad.Date)
+			}
+			return ""
+		}},
+	}
+}
+
+// Validator applies a rule set to ad performance records
+type Validator struct {
+	rules []Rule
+	now   func() time.Time
+}
+
+// New returns a validator for rules, skipping the ones switched off
+func New(rules []Rule) *Validator {
+	active := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Severity != SeverityOff {
+			active = append(active, rule)
+		}
+	}
+	return &Validator{rules: active, now: time.Now}
+}
+
+// Apply returns rules with the severities overridden by cfg
+func Apply(rules []Rule, cfg Config) ([]Rule, error) {
+	index := make(map[string]int, len(rules))
+	for i, rule := range rules {
+		index[rule.Name] = i
+	}
+	out := append([]Rule(nil), rules...)
+	for _, rc := range cfg.Rules {
+		i, ok := index[rc.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown validation rule %q", rc.Name)
+		}
+		switch sev := Severity(strings.ToLower(string(rc.Severity))); sev {
+		case SeverityOff, SeverityWarn, SeverityDrop, SeverityFail:
+			out[i].Severity = sev
+		default:
+			return nil, fmt.Errorf("validation rule %q: unknown severity %q", rc.Name, rc.Severity)
+		}
+	}
+	return out, nil
+}
+
+// Load builds a validator from the default rules and the JSON file at path, if any
+func Load(path string) (*Validator, error) {
+	if path == "" {
+		return New(Defaults()), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading validation config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing validation config: %w", err)
+	}
+	rules, err := Apply(Defaults(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return New(rules), nil
+}
+
+// LoadFromEnv builds a validator using the file named by VALIDATION_CONFIG
+func LoadFromEnv() (*Validator, error) {
+	return Load(utils.Getenv("VALIDATION_CONFIG"))
+}
+
+// Validate checks a normalized record and returns every violation found
+func (v *Validator) Validate(ad models.AdPerformance) []models.Violation {
+	today := v.now().UTC().Format("2006-01-02")
+	var violations []models.Violation
+	for _, rule := range v.rules {
+		if msg := rule.Check(ad, today); msg != "" {
+			violations = append(violations, models.Violation{
+				Rule:       rule.Name,
+				Severity:   string(rule.Severity),
+				Date:       ad.Date,
+				Channel:    ad.Channel,
+				CampaignID: ad.CampaignID,
+				Message:    msg,
+			})
+		}
+	}
+	return violations
+}
+
+// Error is returned when records violate rules with SeverityFail
+type Error struct {
+	Violations []models.Violation
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("validation failed: %d record(s) violate fail rules, first: %s (%s)", len(e.Violations), e.Violations[0].Rule, e.Violations[0].Message)
+}
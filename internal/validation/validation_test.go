@@ -0,0 +1,72 @@
+package validation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"goetl/internal/models"
+)
+
+func fixedValidator(rules []Rule) *Validator {
+	v := New(rules)
+	v.now = func() time.Time { return time.Date(2025, 9, 20, 12, 0, 0, 0, time.UTC) }
+	return v
+}
+
+func rulesOf(violations []models.Violation) []string {
+	names := make([]string, 0, len(violations))
+	for _, v := range violations {
+		names = append(names, v.Rule)
+	}
+	return names
+}
+
+func TestValidate_Defaults(t *testing.T) {
+	v := fixedValidator(Defaults())
+	ok := models.AdPerformance{Date: "2025-09-20", Channel: "google", CampaignID: "C1", Clicks: 10, Impressions: 100, Cost: 5}
+	assert.Empty(t, v.Validate(ok))
+
+	bad := models.AdPerformance{Date: "2025-09-21", Channel: "google", CampaignID: "C1", Clicks: 10, Impressions: 0, Cost: -1}
+	assert.Equal(t, []string{"negative_cost", "clicks_exceed_impressions", "future_date"}, rulesOf(v.Validate(bad)))
+
+	noImpressions := models.AdPerformance{Date: "2025-09-20", Cost: 3}
+	violations := v.Validate(noImpressions)
+	assert.Equal(t, []string{"cost_without_impressions"}, rulesOf(violations))
+	assert.Equal(t, string(SeverityWarn), violations[0].Severity)
+}
+
+func TestApply(t *testing.T) {
+	rules, err := Apply(Defaults(), Config{Rules: []RuleConfig{
+		{Name: "negative_cost", Severity: "FAIL"},
+		{Name: "future_date", Severity: SeverityOff},
+	}})
+	assert.NoError(t, err)
+	v := fixedValidator(rules)
+	violations := v.Validate(models.AdPerformance{Date: "2030-01-01", Impressions: 1, Cost: -1})
+	assert.Equal(t, []string{"negative_cost"}, rulesOf(violations))
+	assert.Equal(t, string(SeverityFail), violations[0].Severity)
+
+	_, err = Apply(Defaults(), Config{Rules: []RuleConfig{{Name: "nope", Severity: SeverityWarn}}})
+	assert.ErrorContains(t, err, "unknown validation rule")
+	_, err = Apply(Defaults(), Config{Rules: []RuleConfig{{Name: "negative_cost", Severity: "panic"}}})
+	assert.ErrorContains(t, err, "unknown severity")
+}
+
+func TestLoad(t *testing.T) {
+	v, err := Load("")
+	assert.NoError(t, err)
+	assert.Len(t, v.rules, len(Defaults()))
+
+	path := filepath.Join(t.TempDir(), "validation.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rules":[{"name":"cost_without_impressions","severity":"off"}]}`), 0o644))
+	v, err = Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, v.rules, len(Defaults())-1)
+}
+
+func TestError(t *testing.T) {
+	err := &Error{Violations: []models.Violation{{Rule: "negative_cost", Message: "cost -1.00 is negative"}}}
+	assert.Contains(t, err.Error(), "negative_cost")
+}